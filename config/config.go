@@ -7,12 +7,25 @@ import (
 	"io/ioutil"
 	"path/filepath"
 	"sync"
+	"time"
 )
 
 const (
 	EnvDev  = "dev"
 	EnvPro  = "pro"
 	EnvTest = "test"
+
+	// spread queries across all the healthy full node dbs in turn
+	LoadBalanceRoundRobin = "roundRobin"
+	// send queries to the healthy full node db with the lowest latency
+	LoadBalanceLeastLatency = "leastLatency"
+	// send queries to one full node db until it fails
+	LoadBalanceFailover = "failover"
+
+	// drivers of full node db
+	DbDriverMysql = "mysql"
+	DbDriverPostgres = "postgres"
+	DbDriverSqlite = "sqlite3"
 )
 
 
@@ -23,13 +36,94 @@ type FullNodeDbInfo struct {
 	FullNodeDbName     string `json:"fullNodeDbName"`
 	FullNodeDbHost     string `json:"fullNodeDbHost"`
 	FullNodeDbPort     string `json:"fullNodeDbPort"`
+	// tls mode, true/false/skip-verify for mysql, or the sslmode for postgres
+	FullNodeDbTls      string `json:"fullNodeDbTls"`
+	// pem file paths of ca, client certificate and key used by tls
+	FullNodeDbTlsCa    string `json:"fullNodeDbTlsCa"`
+	FullNodeDbTlsCert  string `json:"fullNodeDbTlsCert"`
+	FullNodeDbTlsKey   string `json:"fullNodeDbTlsKey"`
+}
+
+// LocalIndexerInfo is the config of the indexer which mirrors transfer records of full node db into the local store
+type LocalIndexerInfo struct {
+	// serve the http api from the local store
+	Enable bool `json:"enable"`
+	// the local store, it has the same keys as the db of fullNodeDbList
+	Db FullNodeDbInfo `json:"db"`
+	// max count of blocks synced in one round
+	BatchBlocks uint64 `json:"batchBlocks"`
+	// seconds to wait after the local store catches up with the full node db
+	Interval int `json:"interval"`
+}
+
+// WebhookTarget is a receiver of the irreversible transfers of watched accounts
+type WebhookTarget struct {
+	Url string `json:"url"`
+	// key of the HMAC-SHA256 signature of every request
+	Secret string `json:"secret"`
+	Accounts []string `json:"accounts"`
+	// 1:transfer out 2:transfer in 3:both transfer out and in
+	Direction int `json:"direction"`
+	// the transfers at or above this block are delivered
+	Start uint64 `json:"start"`
+}
+
+// WebhookInfo is the config of the webhooks which deliver transfers
+type WebhookInfo struct {
+	Targets []WebhookTarget `json:"targets"`
+	// max count of delivering a transfer, it's dropped after that
+	MaxAttempts int `json:"maxAttempts"`
+	// seconds to wait before the first retry, it's doubled in every retry up to max retry interval
+	RetryInterval int `json:"retryInterval"`
+	MaxRetryInterval int `json:"maxRetryInterval"`
+	// seconds to wait for the response of a delivery
+	Timeout int `json:"timeout"`
+	// the db keeping the notifications and positions of webhooks, it has the same keys as the db of fullNodeDbList
+	Outbox FullNodeDbInfo `json:"outbox"`
+	// days to keep the delivered notifications in outbox
+	OutboxRetention int `json:"outboxRetention"`
+}
+
+// DepositInfo is the config of the tracker which follows the incoming transfers of deposit accounts until they are irreversible
+type DepositInfo struct {
+	Accounts []string `json:"accounts"`
+	// the incoming transfers at or above this block are tracked, it's required
+	Start uint64 `json:"start"`
+	// count of indexed blocks for a transfer to be confirmed
+	Confirmations uint64 `json:"confirmations"`
+	// max count of state transitions kept in memory
+	MaxEvents int `json:"maxEvents"`
+	// the db keeping the position and the unfinished transfers of every deposit account, it has the same keys as the db of fullNodeDbList
+	Db FullNodeDbInfo `json:"db"`
 }
 
 type EnvConfig struct {
 	HttpPort      string      `json:"httpPort"`
 	LogPath         string    `json:"logPath"`
 	FullNodeDbList  []FullNodeDbInfo `json:"fullNodeDbList"`
+	// how to spread queries across the full node dbs: roundRobin(default), leastLatency or failover
+	FullNodeLoadBalance string `json:"fullNodeLoadBalance"`
+	// the full node db whose block height is behind the freshest one more than this is not used
+	FullNodeMaxBlockLag uint64 `json:"fullNodeMaxBlockLag"`
+	// seconds between two rounds of checking the block height of full node dbs
+	BlockCheckInterval int `json:"blockCheckInterval"`
+	// seconds between two reads of the block height and lib of the healthy full node dbs, which wake up the waiters of new blocks
+	HeadPollInterval int `json:"headPollInterval"`
+	AdminVerificationCodeList []string `json:"adminVerificationCodeList"`
 	VerificationCodeList []string `json:"verificationCodeList"`
+	StatisticsMaxHourBuckets int `json:"statisticsMaxHourBuckets"`
+	StatisticsMaxDayBuckets  int `json:"statisticsMaxDayBuckets"`
+	// query deadline in seconds of every api, keyed by api name like getTransferHistory
+	QueryTimeout map[string]int `json:"queryTimeout"`
+	// query deadline in seconds of the apis not in QueryTimeout
+	DefaultQueryTimeout int `json:"defaultQueryTimeout"`
+	// max count of transfer records kept in the cache of irreversible query results, negative to disable the cache
+	QueryCacheMaxRecords int `json:"queryCacheMaxRecords"`
+	LocalIndexer LocalIndexerInfo `json:"localIndexer"`
+	// the db keeping the acknowledged blocks of transfer subscriptions, it has the same keys as the db of fullNodeDbList
+	SubscriptionAckDb FullNodeDbInfo `json:"subscriptionAckDb"`
+	Webhook WebhookInfo `json:"webhook"`
+	Deposit DepositInfo `json:"deposit"`
 }
 
 type serviceConfig struct {
@@ -44,7 +138,12 @@ type DbConfig struct {
 	Password string
 	Host     string
 	Port     string
+	// file path for sqlite
 	DbName   string
+	Tls      string
+	TlsCa    string
+	TlsCert  string
+	TlsKey   string
 }
 
 
@@ -53,6 +152,25 @@ var (
 	configOnce sync.Once
 	env = EnvDev // default env is dev
 	httpPort = "8000" //default http port of web server
+	defaultStatisticsMaxHourBuckets = 24 * 31 // at most 31 days of hourly statistics
+	defaultStatisticsMaxDayBuckets = 366 // at most 366 days of daily statistics
+	defaultFullNodeMaxBlockLag uint64 = 100
+	defaultBlockCheckInterval = 30 * time.Second
+	defaultHeadPollInterval = 3 * time.Second
+	defaultQueryTimeout = 60 * time.Second
+	defaultQueryCacheMaxRecords = 100000
+	defaultLocalIndexerBatchBlocks uint64 = 1000
+	defaultLocalIndexerInterval = 3 * time.Second
+	defaultSubscriptionAckDbPath = "subscription_ack.db"
+	defaultWebhookMaxAttempts = 10
+	defaultWebhookRetryInterval = 5 * time.Second
+	defaultWebhookMaxRetryInterval = 10 * time.Minute
+	defaultWebhookTimeout = 10 * time.Second
+	defaultWebhookOutboxPath = "webhook_outbox.db"
+	defaultWebhookOutboxRetention = 7 * 24 * time.Hour
+	defaultDepositConfirmations uint64 = 10
+	defaultDepositMaxEvents = 100000
+	defaultDepositDbPath = "deposit_tracker.db"
 )
 
 
@@ -124,19 +242,27 @@ func GetLogOutputPath() string {
 }
 
 
+func (cf *FullNodeDbInfo) toDbConfig() *DbConfig {
+	info := &DbConfig{}
+	info.Driver = cf.FullNodeDbDriver
+	info.User = cf.FullNodeDbUser
+	info.Password = cf.FullNodeDbPassword
+	info.Port= cf.FullNodeDbPort
+	info.Host = cf.FullNodeDbHost
+	info.DbName = cf.FullNodeDbName
+	info.Tls = cf.FullNodeDbTls
+	info.TlsCa = cf.FullNodeDbTlsCa
+	info.TlsCert = cf.FullNodeDbTlsCert
+	info.TlsKey = cf.FullNodeDbTlsKey
+	return info
+}
+
 // get cos observe node database config list
 func GetCosFullNodeDbConfigList() ([]*DbConfig, error) {
 	var list []*DbConfig
 	if svConfig != nil {
 		for _,cf := range svConfig.FullNodeDbList {
-			info := &DbConfig{}
-			info.Driver = cf.FullNodeDbDriver
-			info.User = cf.FullNodeDbUser
-			info.Password = cf.FullNodeDbPassword
-			info.Port= cf.FullNodeDbPort
-			info.Host = cf.FullNodeDbHost
-			info.DbName = cf.FullNodeDbName
-			list = append(list, info)
+			list = append(list, cf.toDbConfig())
 		}
 
 	} else {
@@ -161,4 +287,224 @@ func CheckIsValidVerificationCode(code string) bool {
 		}
 	}
 	return false
-}
\ No newline at end of file
+}
+
+// get the max count of buckets in one hourly statistics query
+func GetStatisticsMaxHourBuckets() int {
+	if svConfig != nil && svConfig.StatisticsMaxHourBuckets > 0 {
+		return svConfig.StatisticsMaxHourBuckets
+	}
+	return defaultStatisticsMaxHourBuckets
+}
+
+// get the max count of buckets in one daily statistics query
+func GetStatisticsMaxDayBuckets() int {
+	if svConfig != nil && svConfig.StatisticsMaxDayBuckets > 0 {
+		return svConfig.StatisticsMaxDayBuckets
+	}
+	return defaultStatisticsMaxDayBuckets
+}
+
+// get the max block lag of a usable full node db behind the freshest one
+func GetFullNodeMaxBlockLag() uint64 {
+	if svConfig != nil && svConfig.FullNodeMaxBlockLag > 0 {
+		return svConfig.FullNodeMaxBlockLag
+	}
+	return defaultFullNodeMaxBlockLag
+}
+
+// get the interval of checking the block height of full node dbs
+func GetBlockCheckInterval() time.Duration {
+	if svConfig != nil && svConfig.BlockCheckInterval > 0 {
+		return time.Duration(svConfig.BlockCheckInterval) * time.Second
+	}
+	return defaultBlockCheckInterval
+}
+
+// get the interval of reading the block height and lib of the healthy full node dbs
+func GetHeadPollInterval() time.Duration {
+	if svConfig != nil && svConfig.HeadPollInterval > 0 {
+		return time.Duration(svConfig.HeadPollInterval) * time.Second
+	}
+	return defaultHeadPollInterval
+}
+
+// check the verification code of admin api
+func CheckIsValidAdminVerificationCode(code string) bool {
+	if svConfig != nil {
+		for _,vCode := range svConfig.AdminVerificationCodeList {
+			if code == vCode {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// get the load balance policy of full node dbs
+func GetFullNodeLoadBalance() string {
+	if svConfig != nil {
+		switch svConfig.FullNodeLoadBalance {
+		case LoadBalanceLeastLatency, LoadBalanceFailover:
+			return svConfig.FullNodeLoadBalance
+		}
+	}
+	return LoadBalanceRoundRobin
+}
+
+// get the query deadline of an api
+func GetQueryTimeout(api string) time.Duration {
+	if svConfig != nil {
+		if sec,ok := svConfig.QueryTimeout[api]; ok && sec > 0 {
+			return time.Duration(sec) * time.Second
+		}
+		if svConfig.DefaultQueryTimeout > 0 {
+			return time.Duration(svConfig.DefaultQueryTimeout) * time.Second
+		}
+	}
+	return defaultQueryTimeout
+}
+
+// get the max count of transfer records in the query cache, 0 if the cache is disabled
+func GetQueryCacheMaxRecords() int {
+	if svConfig != nil && svConfig.QueryCacheMaxRecords != 0 {
+		if svConfig.QueryCacheMaxRecords < 0 {
+			return 0
+		}
+		return svConfig.QueryCacheMaxRecords
+	}
+	return defaultQueryCacheMaxRecords
+}
+
+// whether to mirror transfer records into the local store and serve the http api from it
+func IsLocalIndexerEnabled() bool {
+	return svConfig != nil && svConfig.LocalIndexer.Enable
+}
+
+// get the db config of the local store
+func GetLocalStoreDbConfig() *DbConfig {
+	if svConfig != nil {
+		return svConfig.LocalIndexer.Db.toDbConfig()
+	}
+	return &DbConfig{}
+}
+
+// get the max count of blocks synced by the local indexer in one round
+func GetLocalIndexerBatchBlocks() uint64 {
+	if svConfig != nil && svConfig.LocalIndexer.BatchBlocks > 0 {
+		return svConfig.LocalIndexer.BatchBlocks
+	}
+	return defaultLocalIndexerBatchBlocks
+}
+
+// get the interval of the local indexer after it catches up with the full node db
+func GetLocalIndexerInterval() time.Duration {
+	if svConfig != nil && svConfig.LocalIndexer.Interval > 0 {
+		return time.Duration(svConfig.LocalIndexer.Interval) * time.Second
+	}
+	return defaultLocalIndexerInterval
+}
+
+// get the db config of the acknowledged blocks of transfer subscriptions, it's a sqlite file in the working directory if it's not configured
+func GetSubscriptionAckDbConfig() *DbConfig {
+	if svConfig != nil && (svConfig.SubscriptionAckDb.FullNodeDbDriver != "" || svConfig.SubscriptionAckDb.FullNodeDbName != "") {
+		return svConfig.SubscriptionAckDb.toDbConfig()
+	}
+	return &DbConfig{Driver: DbDriverSqlite, DbName: defaultSubscriptionAckDbPath}
+}
+
+// get the receivers of transfers
+func GetWebhookTargets() []WebhookTarget {
+	if svConfig != nil {
+		return svConfig.Webhook.Targets
+	}
+	return nil
+}
+
+// get the max count of delivering a transfer to webhook
+func GetWebhookMaxAttempts() int {
+	if svConfig != nil && svConfig.Webhook.MaxAttempts > 0 {
+		return svConfig.Webhook.MaxAttempts
+	}
+	return defaultWebhookMaxAttempts
+}
+
+// get the interval before the first retry of webhook delivery
+func GetWebhookRetryInterval() time.Duration {
+	if svConfig != nil && svConfig.Webhook.RetryInterval > 0 {
+		return time.Duration(svConfig.Webhook.RetryInterval) * time.Second
+	}
+	return defaultWebhookRetryInterval
+}
+
+// get the max interval between two retries of webhook delivery
+func GetWebhookMaxRetryInterval() time.Duration {
+	if svConfig != nil && svConfig.Webhook.MaxRetryInterval > 0 {
+		return time.Duration(svConfig.Webhook.MaxRetryInterval) * time.Second
+	}
+	return defaultWebhookMaxRetryInterval
+}
+
+// get the timeout of a webhook delivery
+func GetWebhookTimeout() time.Duration {
+	if svConfig != nil && svConfig.Webhook.Timeout > 0 {
+		return time.Duration(svConfig.Webhook.Timeout) * time.Second
+	}
+	return defaultWebhookTimeout
+}
+
+// get the db config of the webhook outbox, it's a sqlite file in the working directory if it's not configured
+func GetWebhookOutboxDbConfig() *DbConfig {
+	if svConfig != nil && (svConfig.Webhook.Outbox.FullNodeDbDriver != "" || svConfig.Webhook.Outbox.FullNodeDbName != "") {
+		return svConfig.Webhook.Outbox.toDbConfig()
+	}
+	return &DbConfig{Driver: DbDriverSqlite, DbName: defaultWebhookOutboxPath}
+}
+
+// get how long the delivered notifications are kept in the webhook outbox
+func GetWebhookOutboxRetention() time.Duration {
+	if svConfig != nil && svConfig.Webhook.OutboxRetention > 0 {
+		return time.Duration(svConfig.Webhook.OutboxRetention) * 24 * time.Hour
+	}
+	return defaultWebhookOutboxRetention
+}
+
+// get the deposit accounts whose incoming transfers are tracked
+func GetDepositAccounts() []string {
+	if svConfig != nil {
+		return svConfig.Deposit.Accounts
+	}
+	return nil
+}
+
+// get the block from which the incoming transfers of deposit accounts are tracked, 0 if it's not configured
+func GetDepositStart() uint64 {
+	if svConfig != nil {
+		return svConfig.Deposit.Start
+	}
+	return 0
+}
+
+// get the count of indexed blocks for a deposit to be confirmed
+func GetDepositConfirmations() uint64 {
+	if svConfig != nil && svConfig.Deposit.Confirmations > 0 {
+		return svConfig.Deposit.Confirmations
+	}
+	return defaultDepositConfirmations
+}
+
+// get the max count of deposit state transitions kept in memory
+func GetDepositMaxEvents() int {
+	if svConfig != nil && svConfig.Deposit.MaxEvents > 0 {
+		return svConfig.Deposit.MaxEvents
+	}
+	return defaultDepositMaxEvents
+}
+
+// get the config of the db keeping the tracked deposits, it's a sqlite db in the working directory by default
+func GetDepositDbConfig() *DbConfig {
+	if svConfig != nil && (svConfig.Deposit.Db.FullNodeDbDriver != "" || svConfig.Deposit.Db.FullNodeDbName != "") {
+		return svConfig.Deposit.Db.toDbConfig()
+	}
+	return &DbConfig{Driver: DbDriverSqlite, DbName: defaultDepositDbPath}
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"sync"
+	"time"
+	"transfer_history/config"
+)
+
+// blockWatcher keeps the latest block height and lib seen by the db service,
+// and wakes up all the waiters when they change
+type blockWatcher struct {
+	lock sync.Mutex
+	height uint64
+	lib uint64
+	// closed and replaced on every change
+	changed chan struct{}
+}
+
+func newBlockWatcher() *blockWatcher {
+	return &blockWatcher{changed: make(chan struct{})}
+}
+
+// record the latest block height and lib, wake up the waiters if any of them changes
+func (w *blockWatcher) update(height, lib uint64) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if height == w.height && lib == w.lib {
+		return
+	}
+	w.height, w.lib = height, lib
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+// get a channel which is closed when the block height or lib changes next time
+func (w *blockWatcher) wait() <-chan struct{} {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.changed
+}
+
+// get a channel which is closed when repo finds new blocks.
+// if repo doesn't watch the new blocks, it's closed after the head poll interval
+func WaitBlockChange(repo TransferRepository) <-chan struct{} {
+	if notifier,ok := repo.(BlockNotifier); ok {
+		if ch := notifier.BlockChanged(); ch != nil {
+			return ch
+		}
+	}
+	ch := make(chan struct{})
+	time.AfterFunc(config.GetHeadPollInterval(), func() {
+		close(ch)
+	})
+	return ch
+}
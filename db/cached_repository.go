@@ -0,0 +1,228 @@
+package db
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+	"transfer_history/types"
+)
+
+// cachedRepository caches the query results of repo which only contain irreversible records.
+// records below lib never change, so the cached result of the same query is still valid,
+// only the lib and max block height in it are refreshed by a cheap query
+type cachedRepository struct {
+	TransferRepository
+	cache *queryCache
+}
+
+// wrap repo with a cache of at most maxRecords transfer records
+func NewCachedRepository(repo TransferRepository, maxRecords int) TransferRepository {
+	return &cachedRepository{
+		TransferRepository: repo,
+		cache: newQueryCache(maxRecords),
+	}
+}
+
+// get the node status of the wrapped repository
+func (repo *cachedRepository) GetNodeStatus() []*types.FullNodeStatus {
+	if provider,ok := repo.TransferRepository.(NodeStatusProvider); ok {
+		return provider.GetNodeStatus()
+	}
+	return nil
+}
+
+// get the block notification of the wrapped repository, nil if it doesn't watch the new blocks
+func (repo *cachedRepository) BlockChanged() <-chan struct{} {
+	if notifier,ok := repo.TransferRepository.(BlockNotifier); ok {
+		return notifier.BlockChanged()
+	}
+	return nil
+}
+
+// run fn with a repository pinned to one db of the wrapped repository, its queries are not cached
+func (repo *cachedRepository) PinNode(ctx context.Context, fn func(repo TransferRepository) error) error {
+	return WithPinnedNode(ctx, repo.TransferRepository, fn)
+}
+
+func (repo *cachedRepository) GetCacheStatus() *types.QueryCacheStatus {
+	return repo.cache.status()
+}
+
+// a page of transfer records is cached if there are more records after it and all its records are irreversible
+func (repo *cachedRepository) GetTransferRecord(ctx context.Context, query *types.TransferRecordQuery) *types.QueryTransferRecordModel {
+	if query.Limit <= 0 {
+		// all the records to the newest one, never irreversible
+		return repo.TransferRepository.GetTransferRecord(ctx, query)
+	}
+	key := transferRecordQueryKey(query)
+	if model := repo.getCached(ctx, key); model != nil {
+		return model
+	}
+	model := repo.TransferRepository.GetTransferRecord(ctx, query)
+	// decide by the lib of the db which answers the query, another db may be ahead of it
+	if model.Err == nil && model.HasMore && model.NextCursor != nil && model.NextCursor.BlockHeight <= model.Lib {
+		repo.cache.add(key, model, len(model.List))
+	}
+	return model
+}
+
+// the records of an irreversible block are cached
+func (repo *cachedRepository) GetUserTransferRecordByBlock(ctx context.Context, blkNum uint64, acct string, dir int, includeReversible bool) *types.QueryTransferRecordModel {
+	// the records of an irreversible block are the same whether the reversible ones are asked for or not
+	key := fmt.Sprintf("block|%v|%v|%v", blkNum, acct, dir)
+	if model := repo.getCached(ctx, key); model != nil {
+		return model
+	}
+	model := repo.TransferRepository.GetUserTransferRecordByBlock(ctx, blkNum, acct, dir, includeReversible)
+	// the block is empty on a db which hasn't processed it, so it's only cached if it's irreversible on the db which answers the query
+	if model.Err == nil && blkNum <= model.Lib && blkNum <= model.MaxQueryBlkNum {
+		repo.cache.add(key, model, len(model.List))
+	}
+	return model
+}
+
+// the found records are cached if they are all irreversible
+func (repo *cachedRepository) GetTransferRecordByOperation(ctx context.Context, id string, isTrxHash bool) *types.QueryTransferRecordModel {
+	key := fmt.Sprintf("operation|%v|%v", id, isTrxHash)
+	if model := repo.getCached(ctx, key); model != nil {
+		return model
+	}
+	model := repo.TransferRepository.GetTransferRecordByOperation(ctx, id, isTrxHash)
+	if model.Err == nil && len(model.List) > 0 && allIrreversible(model.List) {
+		repo.cache.add(key, model, len(model.List))
+	}
+	return model
+}
+
+// get the cached result of key with the lib and max block height of one db, which are read in one query.
+// return nil if it's not cached, or the db is behind the one which answered the cached query
+func (repo *cachedRepository) getCached(ctx context.Context, key string) *types.QueryTransferRecordModel {
+	cached,ok := repo.cache.get(key).(*types.QueryTransferRecordModel)
+	if !ok {
+		return nil
+	}
+	lib,maxBlkNum,err := repo.GetBlockStatus(ctx)
+	if err != nil || lib < cached.Lib {
+		return nil
+	}
+	model := *cached
+	model.Lib = lib
+	model.MaxQueryBlkNum = maxBlkNum
+	return &model
+}
+
+// whether all the records are at or below the lib of the query which gets them
+func allIrreversible(list []*types.TransferRecord) bool {
+	for _,rec := range list {
+		if !rec.Irreversible {
+			return false
+		}
+	}
+	return true
+}
+
+// get the cache key of all the params of a transfer record query
+func transferRecordQueryKey(query *types.TransferRecordQuery) string {
+	optUint := func(v *uint64) string {
+		if v == nil {
+			return ""
+		}
+		return fmt.Sprint(*v)
+	}
+	optTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return fmt.Sprint(t.Unix())
+	}
+	cursor := ""
+	if query.Cursor != nil {
+		cursor = fmt.Sprintf("%v:%v", query.Cursor.BlockHeight, query.Cursor.OperationId)
+	}
+	filter := query.Filter
+	return fmt.Sprintf("history|%v|%v|%v|%q|%v|%v|%q|%q|%v|%v|%v|%v|%v",
+		query.Account, query.Direction, query.Start,
+		filter.Counterparty, optUint(filter.MinAmount), optUint(filter.MaxAmount), filter.Memo, filter.MemoPrefix,
+		optTime(query.StartTime), optTime(query.EndTime),
+		query.Limit, cursor, query.IncludeReversible)
+}
+
+// an entry of query cache, its size is the count of records in value
+type queryCacheEntry struct {
+	key string
+	value interface{}
+	size int
+}
+
+// least recently used cache of query results bounded by the count of records in them
+type queryCache struct {
+	lock sync.Mutex
+	maxSize int
+	size int
+	entries map[string]*list.Element
+	order *list.List
+	hits uint64
+	misses uint64
+}
+
+func newQueryCache(maxSize int) *queryCache {
+	return &queryCache{
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+func (c *queryCache) get(key string) interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	elem,ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil
+	}
+	c.hits++
+	c.order.MoveToFront(elem)
+	return elem.Value.(*queryCacheEntry).value
+}
+
+// add a result, evict the least recently used ones if the cache is full
+func (c *queryCache) add(key string, value interface{}, size int) {
+	if size < 1 {
+		// an empty result still takes space
+		size = 1
+	}
+	if size > c.maxSize {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem,ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	for c.size + size > c.maxSize {
+		c.remove(c.order.Back())
+	}
+	c.entries[key] = c.order.PushFront(&queryCacheEntry{key: key, value: value, size: size})
+	c.size += size
+}
+
+func (c *queryCache) remove(elem *list.Element) {
+	entry := c.order.Remove(elem).(*queryCacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
+
+func (c *queryCache) status() *types.QueryCacheStatus {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return &types.QueryCacheStatus{
+		Hits: c.hits,
+		Misses: c.misses,
+		Entries: c.order.Len(),
+		Records: c.size,
+		MaxRecords: c.maxSize,
+	}
+}
@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"github.com/coschain/contentos-go/app/plugins"
+	"testing"
+	"transfer_history/types"
+)
+
+// countingMemoryRepository counts the queries which reach the memory repository
+type countingMemoryRepository struct {
+	*MemoryRepository
+	queries int
+}
+
+func (repo *countingMemoryRepository) GetTransferRecord(ctx context.Context, query *types.TransferRecordQuery) *types.QueryTransferRecordModel {
+	repo.queries++
+	return repo.MemoryRepository.GetTransferRecord(ctx, query)
+}
+
+func (repo *countingMemoryRepository) GetUserTransferRecordByBlock(ctx context.Context, blkNum uint64, acct string, dir int, includeReversible bool) *types.QueryTransferRecordModel {
+	repo.queries++
+	return repo.MemoryRepository.GetUserTransferRecordByBlock(ctx, blkNum, acct, dir, includeReversible)
+}
+
+func (repo *countingMemoryRepository) GetTransferRecordByOperation(ctx context.Context, id string, isTrxHash bool) *types.QueryTransferRecordModel {
+	repo.queries++
+	return repo.MemoryRepository.GetTransferRecordByOperation(ctx, id, isTrxHash)
+}
+
+func newTestCachedRepository(lib uint64, recs ...*plugins.TransferRecord) (*countingMemoryRepository,TransferRepository) {
+	memRepo := &countingMemoryRepository{MemoryRepository: NewMemoryRepository()}
+	memRepo.SetLib(lib)
+	memRepo.AddTransferRecord(recs...)
+	return memRepo,NewCachedRepository(memRepo, 100)
+}
+
+// a query result is cached only if it never changes
+func TestCachedRepositoryCachesIrreversibleResults(t *testing.T) {
+	recs := []*plugins.TransferRecord{
+		testTransfer(10, 0, "initminer", "alice1"),
+		testTransfer(11, 0, "initminer", "alice1"),
+		testTransfer(12, 0, "initminer", "alice1"),
+		testTransfer(13, 0, "initminer", "alice1"),
+	}
+	history := func(limit int, cursorBlkNum uint64) func(repo TransferRepository) *types.QueryTransferRecordModel {
+		return func(repo TransferRepository) *types.QueryTransferRecordModel {
+			query := &types.TransferRecordQuery{Account: "alice1", Direction: types.TxDirectionAll, Limit: limit}
+			if cursorBlkNum > 0 {
+				query.Cursor = &types.TransferCursor{BlockHeight: cursorBlkNum, OperationId: testTransfer(cursorBlkNum, 0, "", "").OperationId}
+			}
+			return repo.GetTransferRecord(context.Background(), query)
+		}
+	}
+	block := func(blkNum uint64) func(repo TransferRepository) *types.QueryTransferRecordModel {
+		return func(repo TransferRepository) *types.QueryTransferRecordModel {
+			return repo.GetUserTransferRecordByBlock(context.Background(), blkNum, "alice1", types.TxDirectionAll, false)
+		}
+	}
+	operation := func(blkNum uint64) func(repo TransferRepository) *types.QueryTransferRecordModel {
+		return func(repo TransferRepository) *types.QueryTransferRecordModel {
+			return repo.GetTransferRecordByOperation(context.Background(), testTransfer(blkNum, 0, "", "").OperationId, false)
+		}
+	}
+	for _,c := range []struct {
+		name string
+		lib uint64
+		query func(repo TransferRepository) *types.QueryTransferRecordModel
+		cached bool
+	}{
+		{"irreversible page", 12, history(2, 0), true},
+		{"last page", 20, history(2, 11), false},
+		{"next cursor above lib", 11, history(3, 0), false},
+		{"no limit", 20, history(0, 0), false},
+		{"irreversible block", 12, block(11), true},
+		{"block above lib", 12, block(13), false},
+		{"block above max block height", 30, block(20), false},
+		{"irreversible operation", 12, operation(12), true},
+		{"reversible operation", 12, operation(13), false},
+		{"unknown operation", 12, operation(20), false},
+	} {
+		memRepo,repo := newTestCachedRepository(c.lib, recs...)
+		first := c.query(repo)
+		second := c.query(repo)
+		if first.Err != nil || second.Err != nil {
+			t.Fatalf("%v: %v %v", c.name, first.Err, second.Err)
+		}
+		if len(first.List) != len(second.List) {
+			t.Fatalf("%v: got %v records then %v", c.name, len(first.List), len(second.List))
+		}
+		if cached := memRepo.queries == 1; cached != c.cached {
+			t.Fatalf("%v: %v queries reach the repository", c.name, memRepo.queries)
+		}
+	}
+}
+
+// a cached result is refreshed with the lib and max block height of the db, and rejected if the db is behind
+func TestCachedRepositoryRejectsLaggingDb(t *testing.T) {
+	memRepo,repo := newTestCachedRepository(12,
+		testTransfer(10, 0, "initminer", "alice1"),
+		testTransfer(11, 0, "initminer", "alice1"),
+		testTransfer(12, 0, "initminer", "alice1"),
+	)
+	query := &types.TransferRecordQuery{Account: "alice1", Direction: types.TxDirectionAll, Limit: 2}
+	repo.GetTransferRecord(context.Background(), query)
+
+	memRepo.AddTransferRecord(testTransfer(15, 0, "initminer", "alice1"))
+	memRepo.SetLib(14)
+	model := repo.GetTransferRecord(context.Background(), query)
+	if memRepo.queries != 1 || model.Lib != 14 || model.MaxQueryBlkNum != 15 || len(model.List) != 2 {
+		t.Fatalf("%v queries, lib %v, max block height %v", memRepo.queries, model.Lib, model.MaxQueryBlkNum)
+	}
+
+	// another db which hasn't reached the lib of the cached result answers
+	memRepo.SetLib(11)
+	model = repo.GetTransferRecord(context.Background(), query)
+	if memRepo.queries != 2 || model.Lib != 11 {
+		t.Fatalf("%v queries, lib %v", memRepo.queries, model.Lib)
+	}
+}
+
+func TestQueryCacheEvictsByRecordCount(t *testing.T) {
+	cache := newQueryCache(4)
+	cache.add("a", "a", 2)
+	cache.add("b", "b", 1)
+	cache.get("a")
+	// "b" is the least recently used one
+	cache.add("c", "c", 2)
+	if cache.get("b") != nil || cache.get("a") != "a" || cache.get("c") != "c" {
+		t.Fatal("the least recently used result isn't evicted")
+	}
+	// "a" and "c" are both evicted to take 3 records
+	cache.add("d", "d", 3)
+	if cache.get("a") != nil || cache.get("c") != nil || cache.get("d") != "d" {
+		t.Fatal("results aren't evicted to make room")
+	}
+	// a result larger than the cache is never cached
+	cache.add("e", "e", 5)
+	if cache.get("e") != nil || cache.get("d") != "d" {
+		t.Fatal("a result larger than the cache is cached")
+	}
+	st := cache.status()
+	if st.Entries != 1 || st.Records != 3 || st.MaxRecords != 4 {
+		t.Fatalf("status of cache is %+v", st)
+	}
+}
@@ -1,149 +1,81 @@
 package db
 
 import (
+	"context"
+	"database/sql"
 	"errors"
-	"fmt"
 	"github.com/coschain/contentos-go/app/plugins"
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/jinzhu/gorm"
-	"strconv"
-	"time"
+	_ "github.com/jinzhu/gorm/dialects/mysql"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
 	"transfer_history/config"
 	"transfer_history/logs"
 	"transfer_history/types"
 )
 
-var (
-	cosNodeDb *gorm.DB
-	cosNodeDbHost string
-	curBlockHeight uint64
-	checkInterval = 2 * time.Minute
-	stop  chan bool
-	isChecking bool
-)
+// the manager of the db service
+var defaultManager = NewManager()
 
+// open the cos observe node dbs and start checking them, also start the local indexer if it's enabled
 func StartDbService() error {
 	logger := logs.GetLogger()
 	logger.Debugln("Start db service")
-	nodeDb,err := getCosFullNodeDb()
-	if err != nil {
-		logger.Errorf("StartDbService: fail to get cos observe node db,the error is %v", err)
-		return err
-	}
-	cosNodeDb = nodeDb
-	checkCosNodeDbValid()
-    return nil
+	return defaultManager.Start()
 }
 
-func getCosFullNodeDb() (*gorm.DB, error) {
-	if cosNodeDb != nil {
-		return cosNodeDb,nil
-	}
-	logger := logs.GetLogger()
-	list,err := config.GetCosFullNodeDbConfigList()
-	if err != nil {
-		logger.Errorf("GetCosObserveNodeDb: fail to get cos observe node db config, the error is %v", err)
-		return nil, errors.New("open db: fail to get observe node db config")
-	}
-	var dbErr error
-	for _,cf := range list {
-		db,err := openDb(cf)
-		if err != nil {
-			logger.Errorf("GetCosObserveNodeDb: fail to open db, the error is %v", err)
-			dbErr = err
-		} else if db != nil {
-			cosNodeDbHost = cf.Host
-			cosNodeDb = db
-			return db,nil
-		}
-	}
-	return nil, dbErr
+// open a db of cfg which keeps the state of the service, like the acknowledged blocks of transfer subscriptions
+func OpenDb(dbCfg *config.DbConfig) (*gorm.DB, error) {
+	return openDb(dbCfg)
 }
 
 func openDb(dbCfg *config.DbConfig) (*gorm.DB, error) {
 	log := logs.GetLogger()
-	source := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local", dbCfg.User, dbCfg.Password, dbCfg.Host, dbCfg.Port,dbCfg.DbName)
-	db,err := gorm.Open(dbCfg.Driver, source)
+	driver,source,err := dataSourceOf(dbCfg)
+	if err != nil {
+		log.Errorf("openDb: fail to get data source of db: %v, the error is %v ", dbCfg.Host, err)
+		return nil,errors.New("fail to open db")
+	}
+	db,err := gorm.Open(driver, source)
 	if err != nil {
-		log.Errorf("openDb: fail to open db: %v, the error is %v ", dbCfg, err)
+		log.Errorf("openDb: fail to open db: %v, the error is %v ", dbCfg.Host, err)
 		return nil,errors.New("fail to open db")
 	}
 	return db,nil
 }
 
-// Timing check the database status regularly(check block height change)
-func checkCosNodeDbValid()  {
-	ticker := time.NewTicker(checkInterval)
-	go func() {
-		for {
-			select {
-			case <- ticker.C:
-				checkBlockStatus()
-			case <- stop:
-				ticker.Stop()
-			}
-
-		}
-	}()
+// run fn on a read only transaction of db which is bound to ctx, so the queries of fn read the same snapshot of db.
+// gorm v1 can't pass a context to a query, but the driver begins the transaction with ctx,
+// and the transaction is rolled back and its queries fail once ctx is done
+func queryWithContext(ctx context.Context, db *gorm.DB, fn func(db *gorm.DB) error) error {
+	tx := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
 }
 
-func checkBlockStatus()  {
+// probe every node's block height and connection
+func checkBlockStatus(pool *nodePool)  {
 	logger := logs.GetLogger()
-	if isChecking {
-		logger.Infoln("last round check block status not finish")
-		return
-	}
-	isChecking = true
-	defer func() {
-		isChecking = false
-	}()
 	logger.Infoln("start check block status")
-	if cosNodeDb != nil {
-		var process plugins.BlockLogProcess
-		err := cosNodeDb.Take(&process).Error
-		if err != nil {
-			logger.Errorf("checkBlockStatus: fail to get cos chain block process")
-		} else {
-			if  process.BlockHeight <= curBlockHeight {
-				logger.Infof("new block height is %v, cache block height is %v", process.BlockHeight, curBlockHeight)
-				logger.Infof("checkBlockStatus: Need to switch to another cos observe node db")
-				//need to switch full node db
-				list,err := config.GetCosFullNodeDbConfigList()
-				if err != nil {
-					logger.Errorf("checkBlockStatus: fail to get db config list, the error is %v", err)
-				} else {
-					for _,cf := range list {
-						if cf.Host != cosNodeDbHost {
-							db,err := openDb(cf)
-							if err == nil {
-								logger.Infof("checkBlockStatus: success to switch origin cos node db:%v to new db:%v", cosNodeDbHost, cf.Host)
-								cosNodeDb = db
-								cosNodeDbHost = cf.Host
-								break
-							} else {
-								logger.Errorf("checkBlockStatus: fail to switch new db, the error is %v", err)
-							}
-						}
-					}
-				}
-			}
-			curBlockHeight = process.BlockHeight
-		}
-
+	pool.probe()
+	for _,st := range pool.status() {
+		logger.Infof("checkBlockStatus: node %v, healthy:%v, lagging:%v, block height:%v, lib:%v, block lag:%v, lib lag:%v, error rate:%.2f, latency:%vms", st.Host, st.Healthy, st.Lagging, st.BlockHeight, st.Lib, st.BlockLag, st.LibLag, st.ErrorRate, st.LatencyMs)
 	}
 	logger.Infoln("finish this round check block status")
-
 }
 
+
+// stop the db service, the connections are closed after the running queries finish
 func CloseDbService() {
 	logger := logs.GetLogger()
 	logger.Infoln("Close my sql database")
-
-	if cosNodeDb != nil {
-		if err := cosNodeDb.Close(); err != nil {
-			logger.Errorf("Fail to close cos observe node db, the error is %v", err)
-		}
-	}
+	defaultManager.Close()
 }
 
 func getLib(db *gorm.DB) (uint64,error) {
@@ -161,128 +93,12 @@ func getLib(db *gorm.DB) (uint64,error) {
 }
 
 
-//get transfer record of account, if isSender = true, get send out record or get deposit record
-func GetTransferRecord(sBlkNum uint64, acct string, isSender bool) *types.QueryTransferRecordModel {
-	logger := logs.GetLogger()
-	cosDb, err := getCosFullNodeDb()
-	model := &types.QueryTransferRecordModel{
-		List: make([]*types.TransferRecord, 0),
-	}
-	if err != nil {
-		logger.Errorf("GetTransferRecord: fail to get cos full node db,the error is %v", err)
-		model.Err = errors.New("system error,fail to open full node db")
-		model.ErrCode = types.StatusIntervalError
-		return model
-	}
-	//1. get current lib
-	lib,err := getLib(cosDb)
-	if err != nil {
-		logger.Errorf("GetTransferRecord: fail to lib,the error is %v", err)
-		model.Err = errors.New("fail to get lib")
-		model.ErrCode = types.StatusGetLibError
-        return model
-	}
-	model.Lib = lib
-	var (
-		tList []*plugins.TransferRecord
-		recList []*types.TransferRecord
-		maxBlkNum uint64
-	)
-	//2. get max block height in transfer record db
-    err = cosDb.Model(plugins.TransferRecord{}).Select("max(block_height)").Row().Scan(&maxBlkNum)
-    if err != nil {
-    	if err != gorm.ErrRecordNotFound {
-			logger.Errorf("GetTransferRecord: fail to get max block height in transfer record,the error is %v", err)
-			model.Err = errors.New("fail to get head block height of transfer record")
-			model.ErrCode = types.StatusGetTransferRecordError
-			return model
-		}
-	}
-	if lib < maxBlkNum {
-		lib = maxBlkNum
-	}
-	model.MaxQueryBlkNum = maxBlkNum
-	if lib < sBlkNum  || maxBlkNum < sBlkNum{
-		return model
-	}
-	//3. get transfer record
-	filter := fmt.Sprintf("block_height >= %v AND block_height <= %v AND `from` = \"%v\"", sBlkNum, maxBlkNum, acct)
-	if !isSender {
-		//get deposit record
-		filter = fmt.Sprintf("block_height >= %v AND block_height <= %v AND `to` = \"%v\"", sBlkNum, maxBlkNum, acct)
-	}
-	err = cosNodeDb.Model(plugins.TransferRecord{}).Where(filter).Order("block_height ASC").Find(&tList).Error
-	if err != nil {
-		if err != gorm.ErrRecordNotFound {
-			// not found record
-			return model
-		}
-		model.Err = errors.New("fail to get transfer record")
-		model.ErrCode = types.StatusGetTransferRecordError
-		return model
+// get max block height in transfer record db, it's 0 if there is no record
+func getMaxBlockHeight(db *gorm.DB) (uint64,error) {
+	var maxBlkNum uint64
+	err := db.Model(plugins.TransferRecord{}).Select("COALESCE(max(block_height), 0)").Row().Scan(&maxBlkNum)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return 0,err
 	}
-	listLen := len(tList)
-	if listLen > 0 {
-		for _,rec := range tList {
-			rec := &types.TransferRecord{
-				OperationId: rec.OperationId,
-				From: rec.From,
-				To: rec.To,
-				Memo: rec.Memo,
-				Amount: strconv.FormatUint(rec.Amount, 10),
-				BlockHeight: strconv.FormatUint(rec.BlockHeight, 10),
-			}
-			recList = append(recList, rec)
-		}
-		model.List = recList
-	}
-	model.Lib = lib
-	return model
+	return maxBlkNum,nil
 }
-
-// get transfer record
-func GetUserTransferRecordByBlock(blkNum uint64, acct string, isSender bool) *types.QueryTransferRecordModel {
-	logger := logs.GetLogger()
-	cosDb, err := getCosFullNodeDb()
-	model := &types.QueryTransferRecordModel{
-		List: make([]*types.TransferRecord, 0),
-	}
-	if err != nil {
-		logger.Errorf("GetUserTransferRecordByBlock: fail to get cos full node db,the error is %v", err)
-		model.Err = errors.New("system error,fail to open full node db")
-		model.ErrCode = types.StatusIntervalError
-		return model
-	}
-	var (
-		tList []*plugins.TransferRecord
-		recList []*types.TransferRecord
-	)
-	filter := fmt.Sprintf("block_height = %v AND `from` = \"%v\" ", blkNum, acct)
-	if !isSender {
-		filter = fmt.Sprintf("block_height = %v AND `to` = \"%v\" ", blkNum, acct)
-	}
-	err = cosDb.Model(plugins.TransferRecord{}).Where(filter).Find(&tList).Error
-	if err != nil {
-		if err != gorm.ErrRecordNotFound {
-			logger.Errorf("GetTransferRecord: fail to get max block height in transfer record,the error is %v", err)
-			model.Err = errors.New("fail to get head block height of transfer record")
-			model.ErrCode = types.StatusGetTransferRecordError
-			return model
-		}
-	}
-	if len(tList) > 0 {
-		for _,rec := range tList {
-			rec := &types.TransferRecord{
-				OperationId: rec.OperationId,
-				From: rec.From,
-				To: rec.To,
-				Memo: rec.Memo,
-				Amount: strconv.FormatUint(rec.Amount, 10),
-				BlockHeight: strconv.FormatUint(rec.BlockHeight, 10),
-			}
-			recList = append(recList, rec)
-		}
-		model.List = recList
-	}
-	return model
-}
\ No newline at end of file
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/coschain/contentos-go/app/plugins"
+	"github.com/jinzhu/gorm"
+	"testing"
+	"transfer_history/types"
+)
+
+func TestQueryWithContext(t *testing.T) {
+	nodeDb := openTestNodeDb(t, 20, testTransfer(10, 0, "initminer", "alice1"))
+
+	// the queries of fn run on a transaction of the same db
+	var count int
+	err := queryWithContext(context.Background(), nodeDb, func(db *gorm.DB) error {
+		return db.Model(&plugins.TransferRecord{}).Count(&count).Error
+	})
+	if err != nil || count != 1 {
+		t.Fatalf("count is %v, the error is %v", count, err)
+	}
+	fnErr := errors.New("node fails")
+	if err := queryWithContext(context.Background(), nodeDb, func(db *gorm.DB) error { return fnErr }); err != fnErr {
+		t.Fatalf("the error of fn is %v", err)
+	}
+
+	// fn isn't run with a done ctx
+	ctx,cancel := context.WithCancel(context.Background())
+	cancel()
+	called := false
+	err = queryWithContext(ctx, nodeDb, func(db *gorm.DB) error {
+		called = true
+		return nil
+	})
+	if err == nil || called {
+		t.Fatalf("query with canceled ctx, the error is %v, fn is called: %v", err, called)
+	}
+
+	// the queries after ctx is done fail
+	ctx,cancel = context.WithCancel(context.Background())
+	err = queryWithContext(ctx, nodeDb, func(db *gorm.DB) error {
+		if _,err := getLib(db); err != nil {
+			return err
+		}
+		cancel()
+		_,err := getLib(db)
+		return err
+	})
+	if err == nil {
+		t.Fatal("query after ctx is canceled succeeds")
+	}
+
+	// the db still works after the canceled transactions
+	if lib,err := getLib(nodeDb); err != nil || lib != 20 {
+		t.Fatalf("lib is %v, the error is %v", lib, err)
+	}
+}
+
+// a query stopped by ctx fails with the timeout error, which doesn't count against the node
+func TestQueryCanceledByContext(t *testing.T) {
+	recs := []*plugins.TransferRecord{testTransfer(10, 0, "initminer", "alice1")}
+	forEachRepository(t, 20, recs, func(t *testing.T, repo TransferRepository) {
+		ctx,cancel := context.WithCancel(context.Background())
+		cancel()
+		model := repo.GetTransferRecord(ctx, &types.TransferRecordQuery{Account: "alice1"})
+		if model.ErrCode != types.StatusQueryTimeoutError || len(model.List) != 0 {
+			t.Fatalf("error code is %v, the error is %v", model.ErrCode, model.Err)
+		}
+		// the memory repository has no node
+		if st := defaultManager.NodeStatus(); st != nil && (!st[0].Healthy || st[0].ErrorRate != 0) {
+			t.Fatalf("status of node is %+v", st[0])
+		}
+		model = repo.GetTransferRecord(context.Background(), &types.TransferRecordQuery{Account: "alice1"})
+		if model.Err != nil || len(model.List) != 1 {
+			t.Fatalf("got %v records, the error is %v", len(model.List), model.Err)
+		}
+	})
+}
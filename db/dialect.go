@@ -0,0 +1,35 @@
+package db
+
+import (
+	"github.com/jinzhu/gorm"
+	"time"
+	"transfer_history/config"
+)
+
+// quote a column name by the dialect of db, like `from` in mysql or "from" in postgres and sqlite
+func column(db *gorm.DB, name string) string {
+	return db.Dialect().Quote(name)
+}
+
+// sql condition of column LIKE a pattern escaped by escapeLikePattern,
+// backslash is the default escape character except in sqlite
+func likeCondition(db *gorm.DB, col string) string {
+	if db.Dialect().GetName() == config.DbDriverSqlite {
+		return col + ` LIKE ? ESCAPE '\'`
+	}
+	return col + " LIKE ?"
+}
+
+// sql expression of the hour which the block time is in, as "2006-01-02 15:00:00", and the time zone of the hour
+func hourBucketExpr(db *gorm.DB) (string,*time.Location) {
+	switch db.Dialect().GetName() {
+	case config.DbDriverPostgres:
+		// the session time zone is UTC
+		return "to_char(block_time, 'YYYY-MM-DD HH24:00:00')",time.UTC
+	case config.DbDriverSqlite:
+		// strftime converts the time with offset to UTC
+		return "strftime('%Y-%m-%d %H:00:00', block_time)",time.UTC
+	}
+	// block time is stored in local time zone by mysql driver
+	return "DATE_FORMAT(block_time, '%Y-%m-%d %H:00:00')",time.Local
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"io/ioutil"
+	"net"
+	"strings"
+	"time"
+	"transfer_history/config"
+	"transfer_history/utils"
+)
+
+// get the driver and the data source name of a cos observe node db, the driver is mysql if it's not configured
+func dataSourceOf(dbCfg *config.DbConfig) (string,string,error) {
+	switch dbCfg.Driver {
+	case "", config.DbDriverMysql:
+		source,err := mysqlDataSource(dbCfg)
+		return config.DbDriverMysql,source,err
+	case config.DbDriverPostgres:
+		return config.DbDriverPostgres,postgresDataSource(dbCfg),nil
+	case config.DbDriverSqlite:
+		if !utils.CheckIsNotEmptyStr(dbCfg.DbName) {
+			return "","",errors.New("sqlite db file path is empty")
+		}
+		return config.DbDriverSqlite,dbCfg.DbName,nil
+	}
+	return "","",errors.New(fmt.Sprintf("unsupported db driver %v", dbCfg.Driver))
+}
+
+// get the data source name of mysql, the ca and client certificate are registered as a custom tls config if provided
+func mysqlDataSource(dbCfg *config.DbConfig) (string,error) {
+	cfg := mysql.NewConfig()
+	cfg.User = dbCfg.User
+	cfg.Passwd = dbCfg.Password
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(dbCfg.Host, dbCfg.Port)
+	cfg.DBName = dbCfg.DbName
+	cfg.ParseTime = true
+	cfg.Loc = time.Local
+	cfg.Params = map[string]string{"charset": "utf8mb4"}
+	switch dbCfg.Tls {
+	case "", "false":
+	case "true", "skip-verify":
+		cfg.TLSConfig = dbCfg.Tls
+		if utils.CheckIsNotEmptyStr(dbCfg.TlsCa) || utils.CheckIsNotEmptyStr(dbCfg.TlsCert) {
+			tlsCfg,err := loadTlsConfig(dbCfg)
+			if err != nil {
+				return "",err
+			}
+			tlsCfg.InsecureSkipVerify = dbCfg.Tls == "skip-verify"
+			name := "cos_node_" + cfg.Addr
+			if err := mysql.RegisterTLSConfig(name, tlsCfg); err != nil {
+				return "",err
+			}
+			cfg.TLSConfig = name
+		}
+	default:
+		return "",errors.New(fmt.Sprintf("unsupported mysql tls mode %v", dbCfg.Tls))
+	}
+	return cfg.FormatDSN(),nil
+}
+
+// load the ca and client certificate of tls
+func loadTlsConfig(dbCfg *config.DbConfig) (*tls.Config,error) {
+	tlsCfg := &tls.Config{ServerName: dbCfg.Host}
+	if utils.CheckIsNotEmptyStr(dbCfg.TlsCa) {
+		pem,err := ioutil.ReadFile(dbCfg.TlsCa)
+		if err != nil {
+			return nil,err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil,errors.New(fmt.Sprintf("fail to parse ca file %v", dbCfg.TlsCa))
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if utils.CheckIsNotEmptyStr(dbCfg.TlsCert) {
+		cert,err := tls.LoadX509KeyPair(dbCfg.TlsCert, dbCfg.TlsKey)
+		if err != nil {
+			return nil,err
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg,nil
+}
+
+// get the data source name of postgres, ssl is disabled if its mode is not configured.
+// the session time zone is UTC, so the block time formatted in sql is in UTC
+func postgresDataSource(dbCfg *config.DbConfig) string {
+	sslMode := dbCfg.Tls
+	if !utils.CheckIsNotEmptyStr(sslMode) {
+		sslMode = "disable"
+	}
+	params := [][2]string{
+		{"host", dbCfg.Host},
+		{"port", dbCfg.Port},
+		{"user", dbCfg.User},
+		{"password", dbCfg.Password},
+		{"dbname", dbCfg.DbName},
+		{"sslmode", sslMode},
+		{"sslrootcert", dbCfg.TlsCa},
+		{"sslcert", dbCfg.TlsCert},
+		{"sslkey", dbCfg.TlsKey},
+		{"timezone", "UTC"},
+	}
+	var list []string
+	for _,p := range params {
+		if utils.CheckIsNotEmptyStr(p[1]) {
+			list = append(list, p[0] + "=" + quotePostgresParam(p[1]))
+		}
+	}
+	return strings.Join(list, " ")
+}
+
+// quote the value of a postgres connection param
+func quotePostgresParam(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
@@ -0,0 +1,210 @@
+package db
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+	"transfer_history/config"
+)
+
+// passwords which need to be escaped or quoted in a data source name
+var testPasswords = []string{
+	"secret",
+	"it's",
+	`say "hi"`,
+	"with space",
+	`back\slash`,
+	`\'`,
+	"p@ss:w/rd?x=1&y",
+	"",
+}
+
+func TestMysqlDataSource(t *testing.T) {
+	for _,password := range testPasswords {
+		dbCfg := &config.DbConfig{User: "cos", Password: password, Host: "10.0.0.1", Port: "3306", DbName: "cosobserve"}
+		driver,source,err := dataSourceOf(dbCfg)
+		if err != nil || driver != config.DbDriverMysql {
+			t.Fatalf("password %q: driver is %v, the error is %v", password, driver, err)
+		}
+		cfg,err := mysql.ParseDSN(source)
+		if err != nil {
+			t.Fatalf("password %q: parse %v: %v", password, source, err)
+		}
+		if cfg.User != "cos" || cfg.Passwd != password || cfg.Addr != "10.0.0.1:3306" || cfg.DBName != "cosobserve" {
+			t.Errorf("password %q: %v is parsed as user %q, password %q, addr %v, db %v", password, source, cfg.User, cfg.Passwd, cfg.Addr, cfg.DBName)
+		}
+		// the block time is read in local time zone, which is the time zone of hour buckets in sql
+		if !cfg.ParseTime || cfg.Loc != time.Local || cfg.Params["charset"] != "utf8mb4" || cfg.TLSConfig != "" {
+			t.Errorf("password %q: %v has parse time %v, location %v, params %v, tls %q", password, source, cfg.ParseTime, cfg.Loc, cfg.Params, cfg.TLSConfig)
+		}
+	}
+}
+
+func TestPostgresDataSource(t *testing.T) {
+	for _,c := range []struct {
+		dbCfg *config.DbConfig
+		want string
+	}{
+		{
+			&config.DbConfig{Driver: config.DbDriverPostgres, User: "cos", Password: "secret", Host: "10.0.0.1", Port: "5432", DbName: "cosobserve"},
+			`host='10.0.0.1' port='5432' user='cos' password='secret' dbname='cosobserve' sslmode='disable' timezone='UTC'`,
+		},
+		{
+			&config.DbConfig{Driver: config.DbDriverPostgres, User: "cos", Password: `it's a \ pass`, Host: "db.local", DbName: "cos observe"},
+			`host='db.local' user='cos' password='it\'s a \\ pass' dbname='cos observe' sslmode='disable' timezone='UTC'`,
+		},
+		{
+			// empty password isn't in the data source name
+			&config.DbConfig{Driver: config.DbDriverPostgres, User: "cos", Host: "db.local", DbName: "cosobserve", Tls: "verify-full", TlsCa: "/etc/ca.pem", TlsCert: "/etc/c.pem", TlsKey: "/etc/k.pem"},
+			`host='db.local' user='cos' dbname='cosobserve' sslmode='verify-full' sslrootcert='/etc/ca.pem' sslcert='/etc/c.pem' sslkey='/etc/k.pem' timezone='UTC'`,
+		},
+	} {
+		driver,source,err := dataSourceOf(c.dbCfg)
+		if err != nil || driver != config.DbDriverPostgres {
+			t.Fatalf("driver is %v, the error is %v", driver, err)
+		}
+		if source != c.want {
+			t.Errorf("data source is %v, want %v", source, c.want)
+		}
+		if _,err := pq.NewConnector(source); err != nil {
+			t.Errorf("pq fails to parse %v: %v", source, err)
+		}
+	}
+	// every password is parsed by pq
+	for _,password := range testPasswords {
+		source := postgresDataSource(&config.DbConfig{User: "cos", Password: password, Host: "db.local", DbName: "cosobserve"})
+		if _,err := pq.NewConnector(source); err != nil {
+			t.Errorf("password %q: pq fails to parse %v: %v", password, source, err)
+		}
+	}
+}
+
+func TestQuotePostgresParam(t *testing.T) {
+	for value,want := range map[string]string{
+		"secret":        `'secret'`,
+		"":              `''`,
+		"with space":    `'with space'`,
+		"it's":          `'it\'s'`,
+		`back\slash`:    `'back\\slash'`,
+		`\'`:            `'\\\''`,
+		`say "hi"`:      `'say "hi"'`,
+	} {
+		if got := quotePostgresParam(value); got != want {
+			t.Errorf("quoted %q is %v, want %v", value, got, want)
+		}
+	}
+}
+
+func TestSqliteDataSource(t *testing.T) {
+	driver,source,err := dataSourceOf(&config.DbConfig{Driver: config.DbDriverSqlite, DbName: "/data/node.db"})
+	if err != nil || driver != config.DbDriverSqlite || source != "/data/node.db" {
+		t.Fatalf("driver is %v, data source is %v, the error is %v", driver, source, err)
+	}
+}
+
+// write a self-signed certificate and its key as pem files in dir
+func writeTestCertificate(t *testing.T, dir string) (string,string) {
+	t.Helper()
+	key,err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{CommonName: "db.local"},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour),
+		IsCA: true,
+		BasicConstraintsValid: true,
+	}
+	der,err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDer,err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("write certificate: %v", err)
+	}
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+	return certPath,keyPath
+}
+
+func TestMysqlTlsDataSource(t *testing.T) {
+	dir := t.TempDir()
+	certPath,keyPath := writeTestCertificate(t, dir)
+	badPem := filepath.Join(dir, "bad.pem")
+	if err := ioutil.WriteFile(badPem, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _,c := range []struct {
+		tls, ca, cert, key string
+		want string
+	}{
+		{"false", "", "", "", ""},
+		// the tls modes of the driver without a custom certificate
+		{"true", "", "", "", "true"},
+		{"skip-verify", "", "", "", "skip-verify"},
+		// the custom certificates are registered as a tls config named by the address
+		{"true", certPath, "", "", "cos_node_db.local:3306"},
+		{"skip-verify", certPath, certPath, keyPath, "cos_node_db.local:3306"},
+	} {
+		dbCfg := &config.DbConfig{Driver: config.DbDriverMysql, User: "cos", Password: "secret", Host: "db.local", Port: "3306", DbName: "cosobserve",
+			Tls: c.tls, TlsCa: c.ca, TlsCert: c.cert, TlsKey: c.key}
+		source,err := mysqlDataSource(dbCfg)
+		if err != nil {
+			t.Fatalf("tls %v: %v", c.tls, err)
+		}
+		// the registered tls config is found by the driver
+		cfg,err := mysql.ParseDSN(source)
+		if err != nil {
+			t.Fatalf("tls %v: parse %v: %v", c.tls, source, err)
+		}
+		if cfg.TLSConfig != c.want {
+			t.Errorf("tls %v: tls config of %v is %q, want %q", c.tls, source, cfg.TLSConfig, c.want)
+		}
+	}
+
+	for _,dbCfg := range []*config.DbConfig{
+		{Tls: "maybe"},
+		{Tls: "true", TlsCa: filepath.Join(dir, "missing.pem")},
+		{Tls: "true", TlsCa: badPem},
+		{Tls: "true", TlsCert: certPath},
+		{Tls: "true", TlsCert: certPath, TlsKey: badPem},
+	} {
+		dbCfg.Host, dbCfg.Port = "db.local", "3306"
+		if source,err := mysqlDataSource(dbCfg); err == nil {
+			t.Errorf("tls %v, ca %v, cert %v, key %v: data source is %v", dbCfg.Tls, dbCfg.TlsCa, dbCfg.TlsCert, dbCfg.TlsKey, source)
+		}
+	}
+}
+
+func TestInvalidDataSource(t *testing.T) {
+	for _,dbCfg := range []*config.DbConfig{
+		{Driver: "oracle", Host: "db.local"},
+		{Driver: config.DbDriverSqlite},
+		{Driver: config.DbDriverMysql, Tls: "maybe"},
+	} {
+		if driver,source,err := dataSourceOf(dbCfg); err == nil {
+			t.Errorf("driver %v: got driver %v and data source %v", dbCfg.Driver, driver, source)
+		}
+		if _,err := openDb(dbCfg); err == nil {
+			t.Errorf("driver %v: db is opened", dbCfg.Driver)
+		}
+	}
+}
@@ -0,0 +1,797 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/coschain/contentos-go/app/plugins"
+	"github.com/jinzhu/gorm"
+	"math/big"
+	"strings"
+	"time"
+	"transfer_history/logs"
+	"transfer_history/types"
+	"transfer_history/utils"
+)
+
+// transfer repository backed by the transfer_record table of a sql db.
+// every method runs all its queries(lib, max block height and records) in one call of query,
+// so the result is consistent
+type sqlRepository struct {
+	manager *Manager
+	// watches the new blocks of the db
+	watcher *blockWatcher
+	// run fn on a db bound to ctx, fn returns the error of the db
+	query func(ctx context.Context, fn func(cosDb *gorm.DB) error) error
+}
+
+// get the repository backed by cos observe node dbs of the db service.
+// all the queries of a method run on one node of the pool, and fail over to another node as a whole if the node fails
+func NewFullNodeRepository() TransferRepository {
+	return defaultManager.FullNodeRepository()
+}
+
+// get the repository backed by the local store synced by the local indexer of the db service
+func NewLocalStoreRepository() TransferRepository {
+	return defaultManager.LocalStoreRepository()
+}
+
+// get the health and block lag of all the cos observe node dbs
+func (repo *sqlRepository) GetNodeStatus() []*types.FullNodeStatus {
+	return repo.manager.NodeStatus()
+}
+
+// get a channel which is closed when the block height or lib of the db changes next time
+func (repo *sqlRepository) BlockChanged() <-chan struct{} {
+	return repo.watcher.wait()
+}
+
+// run fn with a repository whose queries all run on the db picked for fn, and fail over to another db as a whole
+func (repo *sqlRepository) PinNode(ctx context.Context, fn func(repo TransferRepository) error) error {
+	return repo.query(ctx, func(cosDb *gorm.DB) error {
+		pinned := &sqlRepository{manager: repo.manager, watcher: repo.watcher}
+		// cosDb is bound to ctx, so the queries stop when ctx is done
+		pinned.query = func(qCtx context.Context, f func(cosDb *gorm.DB) error) error {
+			if err := qCtx.Err(); err != nil {
+				return err
+			}
+			return f(cosDb)
+		}
+		return fn(pinned)
+	})
+}
+
+// get the error of model which means the node fails
+func nodeErrorOf(err error, code int) error {
+	if err != nil && isNodeErrorCode(code) {
+		return err
+	}
+	return nil
+}
+
+func (repo *sqlRepository) GetLib(ctx context.Context) (uint64,error) {
+	var lib uint64
+	err := repo.query(ctx, func(cosDb *gorm.DB) (err error) {
+		lib,err = getLib(cosDb)
+		return err
+	})
+	return lib,err
+}
+
+func (repo *sqlRepository) GetMaxBlockHeight(ctx context.Context) (uint64,error) {
+	var maxBlkNum uint64
+	err := repo.query(ctx, func(cosDb *gorm.DB) (err error) {
+		maxBlkNum,err = getMaxBlockHeight(cosDb)
+		return err
+	})
+	return maxBlkNum,err
+}
+
+func (repo *sqlRepository) GetBlockStatus(ctx context.Context) (uint64,uint64,error) {
+	var lib, maxBlkNum uint64
+	err := repo.query(ctx, func(cosDb *gorm.DB) (err error) {
+		if lib,err = getLib(cosDb); err != nil {
+			return err
+		}
+		maxBlkNum,err = getMaxBlockHeight(cosDb)
+		return err
+	})
+	return lib,maxBlkNum,err
+}
+
+func (repo *sqlRepository) GetTransferRecord(ctx context.Context, query *types.TransferRecordQuery) *types.QueryTransferRecordModel {
+	model := newNodeErrorRecordModel()
+	repo.query(ctx, func(cosDb *gorm.DB) error {
+		model = getTransferRecord(cosDb, query)
+		return nodeErrorOf(model.Err, model.ErrCode)
+	})
+	model.Err,model.ErrCode = contextErrorOf(ctx, model.Err, model.ErrCode)
+	return model
+}
+
+func (repo *sqlRepository) GetUserTransferRecordByBlock(ctx context.Context, blkNum uint64, acct string, dir int, includeReversible bool) *types.QueryTransferRecordModel {
+	model := newNodeErrorRecordModel()
+	repo.query(ctx, func(cosDb *gorm.DB) error {
+		model = getUserTransferRecordByBlock(cosDb, blkNum, acct, dir, includeReversible)
+		return nodeErrorOf(model.Err, model.ErrCode)
+	})
+	model.Err,model.ErrCode = contextErrorOf(ctx, model.Err, model.ErrCode)
+	return model
+}
+
+func (repo *sqlRepository) GetBatchTransferRecord(ctx context.Context, query *types.BatchTransferRecordQuery) *types.QueryBatchTransferRecordModel {
+	model := &types.QueryBatchTransferRecordModel{
+		Records: make(map[string][]*types.TransferRecord),
+		NextCursor: query.Cursor,
+		Err: errors.New("system error,fail to open full node db"),
+		ErrCode: types.StatusIntervalError,
+	}
+	repo.query(ctx, func(cosDb *gorm.DB) error {
+		model = getBatchTransferRecord(cosDb, query)
+		return nodeErrorOf(model.Err, model.ErrCode)
+	})
+	model.Err,model.ErrCode = contextErrorOf(ctx, model.Err, model.ErrCode)
+	return model
+}
+
+func (repo *sqlRepository) GetTransferSummary(ctx context.Context, query *types.TransferSummaryQuery) *types.QueryTransferSummaryModel {
+	model := &types.QueryTransferSummaryModel{
+		Err: errors.New("system error,fail to open full node db"),
+		ErrCode: types.StatusIntervalError,
+	}
+	repo.query(ctx, func(cosDb *gorm.DB) error {
+		model = getTransferSummary(cosDb, query)
+		return nodeErrorOf(model.Err, model.ErrCode)
+	})
+	model.Err,model.ErrCode = contextErrorOf(ctx, model.Err, model.ErrCode)
+	return model
+}
+
+func (repo *sqlRepository) GetTransferStatistics(ctx context.Context, query *types.TransferStatisticsQuery) *types.QueryTransferStatisticsModel {
+	model := &types.QueryTransferStatisticsModel{
+		Buckets: make([]*types.TransferStatisticsBucket, 0),
+		Err: errors.New("system error,fail to open full node db"),
+		ErrCode: types.StatusIntervalError,
+	}
+	repo.query(ctx, func(cosDb *gorm.DB) error {
+		model = getTransferStatistics(cosDb, query)
+		return nodeErrorOf(model.Err, model.ErrCode)
+	})
+	model.Err,model.ErrCode = contextErrorOf(ctx, model.Err, model.ErrCode)
+	return model
+}
+
+func (repo *sqlRepository) GetTransferRecordByOperation(ctx context.Context, id string, isTrxHash bool) *types.QueryTransferRecordModel {
+	model := newNodeErrorRecordModel()
+	repo.query(ctx, func(cosDb *gorm.DB) error {
+		model = getTransferRecordByOperation(cosDb, id, isTrxHash)
+		return nodeErrorOf(model.Err, model.ErrCode)
+	})
+	model.Err,model.ErrCode = contextErrorOf(ctx, model.Err, model.ErrCode)
+	return model
+}
+
+// the result when no node can serve the query
+func newNodeErrorRecordModel() *types.QueryTransferRecordModel {
+	return &types.QueryTransferRecordModel{
+		List: make([]*types.TransferRecord, 0),
+		Err: errors.New("system error,fail to open full node db"),
+		ErrCode: types.StatusIntervalError,
+	}
+}
+
+// get the filter and args of the query on transfer record by account and direction
+func accountFilter(db *gorm.DB, acct string, dir int) (string, []interface{}) {
+	from, to := column(db, "from"), column(db, "to")
+	switch dir {
+	case types.TxDirectionSend:
+		return from + " = ?", []interface{}{acct}
+	case types.TxDirectionReceive:
+		return to + " = ?", []interface{}{acct}
+	default:
+		// a transfer to itself matches both conditions, but it's still only one row
+		return "(" + from + " = ? OR " + to + " = ?)", []interface{}{acct, acct}
+	}
+}
+
+// escape the wildcard characters of LIKE pattern
+func escapeLikePattern(str string) string {
+	return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(str)
+}
+
+// add the optional filters of transfer record to sql
+func applyTransferFilter(sql *gorm.DB, dir int, filter *types.TransferRecordFilter) *gorm.DB {
+	if utils.CheckIsNotEmptyStr(filter.Counterparty) {
+		// the counterparty is on the opposite side of the queried account
+		from, to := column(sql, "from"), column(sql, "to")
+		switch dir {
+		case types.TxDirectionSend:
+			sql = sql.Where(to + " = ?", filter.Counterparty)
+		case types.TxDirectionReceive:
+			sql = sql.Where(from + " = ?", filter.Counterparty)
+		default:
+			sql = sql.Where("(" + from + " = ? OR " + to + " = ?)", filter.Counterparty, filter.Counterparty)
+		}
+	}
+	if filter.MinAmount != nil {
+		sql = sql.Where("amount >= ?", *filter.MinAmount)
+	}
+	if filter.MaxAmount != nil {
+		sql = sql.Where("amount <= ?", *filter.MaxAmount)
+	}
+	if utils.CheckIsNotEmptyStr(filter.Memo) {
+		sql = sql.Where("memo = ?", filter.Memo)
+	}
+	if utils.CheckIsNotEmptyStr(filter.MemoPrefix) {
+		sql = sql.Where(likeCondition(sql, "memo"), escapeLikePattern(filter.MemoPrefix) + "%")
+	}
+	return sql
+}
+
+//get transfer record of account, query.Direction decides to get send out record, deposit record or both
+//records are ordered by (block_height, operation_id), query.Cursor and query.Limit are used to page through them
+func getTransferRecord(cosDb *gorm.DB, query *types.TransferRecordQuery) *types.QueryTransferRecordModel {
+	logger := logs.GetLogger()
+	model := &types.QueryTransferRecordModel{
+		List: make([]*types.TransferRecord, 0),
+	}
+	sBlkNum := query.Start
+	if err := checkAccountName(query.Account); err != nil {
+		logger.Errorf("GetTransferRecord: %v", err)
+		model.Err = err
+		model.ErrCode = types.StatusParamInvalidError
+		return model
+	}
+	if cp := query.Filter.Counterparty; utils.CheckIsNotEmptyStr(cp) {
+		if err := checkAccountName(cp); err != nil {
+			logger.Errorf("GetTransferRecord: %v", err)
+			model.Err = err
+			model.ErrCode = types.StatusParamInvalidError
+			return model
+		}
+	}
+	//1. get current lib
+	lib,err := getLib(cosDb)
+	if err != nil {
+		logger.Errorf("GetTransferRecord: fail to lib,the error is %v", err)
+		model.Err = errors.New("fail to get lib")
+		model.ErrCode = types.StatusGetLibError
+        return model
+	}
+	model.Lib = lib
+	model.NextCursor = query.Cursor
+	var (
+		tList []*plugins.TransferRecord
+		recList []*types.TransferRecord
+	)
+	//2. get max block height in transfer record db
+	maxBlkNum,err := getMaxBlockHeight(cosDb)
+	if err != nil {
+		logger.Errorf("GetTransferRecord: fail to get max block height in transfer record,the error is %v", err)
+		model.Err = errors.New("fail to get head block height of transfer record")
+		model.ErrCode = types.StatusGetTransferRecordError
+		return model
+	}
+	model.MaxQueryBlkNum = maxBlkNum
+	//3. convert time range to block height range, which ends at lib unless the reversible records are asked for
+	eBlkNum := queryEndBlock(lib, maxBlkNum, query.IncludeReversible)
+	sBlkNum,eBlkNum,ok,err := narrowBlockRangeByTime(maxBlockHeightBeforeOf(cosDb), sBlkNum, eBlkNum, query.StartTime, query.EndTime)
+	if err != nil {
+		logger.Errorf("GetTransferRecord: fail to convert time range to block height range,the error is %v", err)
+		model.Err = errors.New("fail to get block height range of time range")
+		model.ErrCode = types.StatusGetTransferRecordError
+		return model
+	}
+	if !ok {
+		return model
+	}
+	if eBlkNum < sBlkNum {
+		return model
+	}
+	//4. get transfer record
+	acctFilter, acctArgs := accountFilter(cosDb, query.Account, query.Direction)
+	sql := cosDb.Model(plugins.TransferRecord{}).
+		Where("block_height >= ? AND block_height <= ?", sBlkNum, eBlkNum).
+		Where(acctFilter, acctArgs...)
+	sql = applyTransferFilter(sql, query.Direction, &query.Filter)
+	if query.Cursor != nil {
+		// continue from the last record of previous page
+		sql = sql.Where("block_height > ? OR (block_height = ? AND operation_id > ?)",
+			query.Cursor.BlockHeight, query.Cursor.BlockHeight, query.Cursor.OperationId)
+	}
+	sql = sql.Order("block_height ASC").Order("operation_id ASC")
+	if query.Limit > 0 {
+		// get one more record to know whether there are more records
+		sql = sql.Limit(query.Limit + 1)
+	}
+	err = sql.Find(&tList).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			// not found record
+			return model
+		}
+		logger.Errorf("GetTransferRecord: fail to get transfer record,the error is %v", err)
+		model.Err = errors.New("fail to get transfer record")
+		model.ErrCode = types.StatusGetTransferRecordError
+		return model
+	}
+	if query.Limit > 0 && len(tList) > query.Limit {
+		tList = tList[:query.Limit]
+		model.HasMore = true
+	}
+	listLen := len(tList)
+	if listLen > 0 {
+		for _,rec := range tList {
+			recList = append(recList, convertTransferRecord(rec, query.Account, lib, maxBlkNum))
+		}
+		model.List = recList
+		last := tList[listLen-1]
+		model.NextCursor = &types.TransferCursor{BlockHeight: last.BlockHeight, OperationId: last.OperationId}
+	}
+	model.Lib = lib
+	return model
+}
+
+// get transfer record of account in a block, dir decides to get send out record, deposit record or both
+func getUserTransferRecordByBlock(cosDb *gorm.DB, blkNum uint64, acct string, dir int, includeReversible bool) *types.QueryTransferRecordModel {
+	logger := logs.GetLogger()
+	model := &types.QueryTransferRecordModel{
+		List: make([]*types.TransferRecord, 0),
+	}
+	if err := checkAccountName(acct); err != nil {
+		logger.Errorf("GetUserTransferRecordByBlock: %v", err)
+		model.Err = err
+		model.ErrCode = types.StatusParamInvalidError
+		return model
+	}
+	lib,err := getLib(cosDb)
+	if err != nil {
+		logger.Errorf("GetUserTransferRecordByBlock: fail to lib,the error is %v", err)
+		model.Err = errors.New("fail to get lib")
+		model.ErrCode = types.StatusGetLibError
+		return model
+	}
+	model.Lib = lib
+	maxBlkNum,err := getMaxBlockHeight(cosDb)
+	if err != nil {
+		logger.Errorf("GetUserTransferRecordByBlock: fail to get max block height in transfer record,the error is %v", err)
+		model.Err = errors.New("fail to get head block height of transfer record")
+		model.ErrCode = types.StatusGetTransferRecordError
+		return model
+	}
+	model.MaxQueryBlkNum = maxBlkNum
+	if blkNum > queryEndBlock(lib, maxBlkNum, includeReversible) {
+		return model
+	}
+	var (
+		tList []*plugins.TransferRecord
+		recList []*types.TransferRecord
+	)
+	acctFilter, acctArgs := accountFilter(cosDb, acct, dir)
+	err = cosDb.Model(plugins.TransferRecord{}).
+		Where("block_height = ?", blkNum).
+		Where(acctFilter, acctArgs...).
+		Order("operation_id ASC").
+		Find(&tList).Error
+	if err != nil {
+		if err != gorm.ErrRecordNotFound {
+			logger.Errorf("GetUserTransferRecordByBlock: fail to get transfer record,the error is %v", err)
+			model.Err = errors.New("fail to get transfer record")
+			model.ErrCode = types.StatusGetTransferRecordError
+			return model
+		}
+	}
+	if len(tList) > 0 {
+		for _,rec := range tList {
+			recList = append(recList, convertTransferRecord(rec, acct, lib, maxBlkNum))
+		}
+		model.List = recList
+	}
+	return model
+}
+
+// get transfer record of several accounts in one query, every account has its own start block height.
+// the records of each account are ordered by (block_height, operation_id) and tagged with direction relative to the account
+func getBatchTransferRecord(cosDb *gorm.DB, query *types.BatchTransferRecordQuery) *types.QueryBatchTransferRecordModel {
+	logger := logs.GetLogger()
+	model := &types.QueryBatchTransferRecordModel{
+		Records: make(map[string][]*types.TransferRecord),
+		NextCursor: query.Cursor,
+	}
+	accounts, dir := query.Accounts, query.Direction
+	for _,acct := range accounts {
+		if err := checkAccountName(acct.Account); err != nil {
+			logger.Errorf("GetBatchTransferRecord: %v", err)
+			model.Err = err
+			model.ErrCode = types.StatusParamInvalidError
+			return model
+		}
+		model.Records[acct.Account] = make([]*types.TransferRecord, 0)
+	}
+	lib,err := getLib(cosDb)
+	if err != nil {
+		logger.Errorf("GetBatchTransferRecord: fail to lib,the error is %v", err)
+		model.Err = errors.New("fail to get lib")
+		model.ErrCode = types.StatusGetLibError
+		return model
+	}
+	maxBlkNum,err := getMaxBlockHeight(cosDb)
+	if err != nil {
+		logger.Errorf("GetBatchTransferRecord: fail to get max block height in transfer record,the error is %v", err)
+		model.Err = errors.New("fail to get head block height of transfer record")
+		model.ErrCode = types.StatusGetTransferRecordError
+		return model
+	}
+	model.Lib = lib
+	model.MaxQueryBlkNum = maxBlkNum
+	eBlkNum := queryEndBlock(lib, maxBlkNum, query.IncludeReversible)
+	// one condition per account, so all the accounts are fetched in one round trip
+	var (
+		conditions []string
+		args []interface{}
+	)
+	for _,acct := range accounts {
+		if acct.Start > eBlkNum {
+			continue
+		}
+		acctFilter, acctArgs := accountFilter(cosDb, acct.Account, dir)
+		conditions = append(conditions, "(block_height >= ? AND " + acctFilter + ")")
+		args = append(args, acct.Start)
+		args = append(args, acctArgs...)
+	}
+	if len(conditions) == 0 {
+		return model
+	}
+	var tList []*plugins.TransferRecord
+	sql := cosDb.Model(plugins.TransferRecord{}).
+		Where("block_height <= ?", eBlkNum).
+		Where(strings.Join(conditions, " OR "), args...)
+	if query.Cursor != nil {
+		// continue from the last record of previous page
+		sql = sql.Where("block_height > ? OR (block_height = ? AND operation_id > ?)",
+			query.Cursor.BlockHeight, query.Cursor.BlockHeight, query.Cursor.OperationId)
+	}
+	sql = sql.Order("block_height ASC").Order("operation_id ASC")
+	if query.Limit > 0 {
+		// get one more record to know whether there are more records
+		sql = sql.Limit(query.Limit + 1)
+	}
+	err = sql.Find(&tList).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		logger.Errorf("GetBatchTransferRecord: fail to get transfer record,the error is %v", err)
+		model.Err = errors.New("fail to get transfer record")
+		model.ErrCode = types.StatusGetTransferRecordError
+		return model
+	}
+	if query.Limit > 0 && len(tList) > query.Limit {
+		tList = tList[:query.Limit]
+		model.HasMore = true
+	}
+	if len(tList) > 0 {
+		last := tList[len(tList)-1]
+		model.NextCursor = &types.TransferCursor{BlockHeight: last.BlockHeight, OperationId: last.OperationId}
+	}
+	// a transfer between two queried accounts belongs to both of them
+	for _,rec := range tList {
+		for _,acct := range accounts {
+			if rec.BlockHeight < acct.Start {
+				continue
+			}
+			isFrom := rec.From == acct.Account && dir != types.TxDirectionReceive
+			isTo := rec.To == acct.Account && dir != types.TxDirectionSend
+			if isFrom || isTo {
+				model.Records[acct.Account] = append(model.Records[acct.Account], convertTransferRecord(rec, acct.Account, lib, maxBlkNum))
+			}
+		}
+	}
+	return model
+}
+
+// get the totals of an account's transfers in a block or time range.
+// the sums are computed in sql, which doesn't overflow on uint64 amounts(mysql sums them as DECIMAL),
+// and are scanned as strings into big.Int
+func getTransferSummary(cosDb *gorm.DB, query *types.TransferSummaryQuery) *types.QueryTransferSummaryModel {
+	logger := logs.GetLogger()
+	model := &types.QueryTransferSummaryModel{}
+	acct := query.Account
+	if err := checkAccountName(acct); err != nil {
+		logger.Errorf("GetTransferSummary: %v", err)
+		model.Err = err
+		model.ErrCode = types.StatusParamInvalidError
+		return model
+	}
+	lib,err := getLib(cosDb)
+	if err != nil {
+		logger.Errorf("GetTransferSummary: fail to lib,the error is %v", err)
+		model.Err = errors.New("fail to get lib")
+		model.ErrCode = types.StatusGetLibError
+		return model
+	}
+	model.Lib = lib
+	maxBlkNum,err := getMaxBlockHeight(cosDb)
+	if err != nil {
+		logger.Errorf("GetTransferSummary: fail to get max block height in transfer record,the error is %v", err)
+		model.Err = errors.New("fail to get head block height of transfer record")
+		model.ErrCode = types.StatusGetTransferRecordError
+		return model
+	}
+	model.MaxQueryBlkNum = maxBlkNum
+	// only count the irreversible records, so the totals don't change after they are returned
+	eBlkNum := queryEndBlock(lib, maxBlkNum, false)
+	if query.End > 0 && query.End < eBlkNum {
+		eBlkNum = query.End
+	}
+	sBlkNum,eBlkNum,ok,err := narrowBlockRangeByTime(maxBlockHeightBeforeOf(cosDb), query.Start, eBlkNum, query.StartTime, query.EndTime)
+	if err != nil {
+		logger.Errorf("GetTransferSummary: fail to convert time range to block height range,the error is %v", err)
+		model.Err = errors.New("fail to get block height range of time range")
+		model.ErrCode = types.StatusGetTransferRecordError
+		return model
+	}
+	var (
+		inSum, outSum string
+		inCount, outCount uint64
+	)
+	if ok {
+		model.StartBlkNum = sBlkNum
+		model.EndBlkNum = eBlkNum
+		from, to := column(cosDb, "from"), column(cosDb, "to")
+		err = cosDb.Model(plugins.TransferRecord{}).
+			Select("COALESCE(SUM(CASE WHEN " + to + " = ? THEN amount ELSE 0 END), 0), " +
+				"COALESCE(SUM(CASE WHEN " + from + " = ? THEN amount ELSE 0 END), 0), " +
+				"COUNT(CASE WHEN " + to + " = ? THEN 1 END), " +
+				"COUNT(CASE WHEN " + from + " = ? THEN 1 END)", acct, acct, acct, acct).
+			Where("block_height >= ? AND block_height <= ?", sBlkNum, eBlkNum).
+			Where("(" + from + " = ? OR " + to + " = ?)", acct, acct).
+			Row().Scan(&inSum, &outSum, &inCount, &outCount)
+		if err != nil {
+			logger.Errorf("GetTransferSummary: fail to get transfer summary,the error is %v", err)
+			model.Err = errors.New("fail to get transfer summary")
+			model.ErrCode = types.StatusGetTransferRecordError
+			return model
+		}
+	} else {
+		inSum, outSum = "0", "0"
+	}
+	inAmount, inOk := new(big.Int).SetString(inSum, 10)
+	outAmount, outOk := new(big.Int).SetString(outSum, 10)
+	if !inOk || !outOk {
+		logger.Errorf("GetTransferSummary: fail to parse transfer sum %v and %v", inSum, outSum)
+		model.Err = errors.New("fail to get transfer summary")
+		model.ErrCode = types.StatusGetTransferRecordError
+		return model
+	}
+	netAmount := new(big.Int).Sub(inAmount, outAmount)
+	model.Summary = &types.TransferSummary{
+		Account: acct,
+		InAmount: inAmount.String(),
+		OutAmount: outAmount.String(),
+		NetAmount: netAmount.String(),
+		InAmountCos: utils.FormatCosAmount(inAmount),
+		OutAmountCos: utils.FormatCosAmount(outAmount),
+		NetAmountCos: utils.FormatCosAmount(netAmount),
+		InCount: inCount,
+		OutCount: outCount,
+	}
+	return model
+}
+
+// get the time bucketed transfer count and volume of an account, or of the whole chain if account is empty.
+// the records are grouped by hour in sql, then merged to UTC aligned buckets of query.Interval
+func getTransferStatistics(cosDb *gorm.DB, query *types.TransferStatisticsQuery) *types.QueryTransferStatisticsModel {
+	logger := logs.GetLogger()
+	model := &types.QueryTransferStatisticsModel{
+		Buckets: make([]*types.TransferStatisticsBucket, 0),
+	}
+	if utils.CheckIsNotEmptyStr(query.Account) {
+		if err := checkAccountName(query.Account); err != nil {
+			logger.Errorf("GetTransferStatistics: %v", err)
+			model.Err = err
+			model.ErrCode = types.StatusParamInvalidError
+			return model
+		}
+	}
+	if err := checkStatisticsQuery(query); err != nil {
+		model.Err = err
+		model.ErrCode = types.StatusParamInvalidError
+		return model
+	}
+	lib,err := getLib(cosDb)
+	if err != nil {
+		logger.Errorf("GetTransferStatistics: fail to lib,the error is %v", err)
+		model.Err = errors.New("fail to get lib")
+		model.ErrCode = types.StatusGetLibError
+		return model
+	}
+	model.Lib = lib
+	maxBlkNum,err := getMaxBlockHeight(cosDb)
+	if err != nil {
+		logger.Errorf("GetTransferStatistics: fail to get max block height in transfer record,the error is %v", err)
+		model.Err = errors.New("fail to get head block height of transfer record")
+		model.ErrCode = types.StatusGetTransferRecordError
+		return model
+	}
+	model.MaxQueryBlkNum = maxBlkNum
+	// bucket start time -> count and amount
+	firstBucket := query.StartTime.Truncate(query.Interval)
+	counts := make(map[int64]uint64)
+	amounts := make(map[int64]*big.Int)
+	// use block height range, so the query is bounded by the block_height index
+	// only count the irreversible records, so the buckets don't change after they are returned
+	sBlkNum,eBlkNum,ok,err := narrowBlockRangeByTime(maxBlockHeightBeforeOf(cosDb), 0, queryEndBlock(lib, maxBlkNum, false), &query.StartTime, &query.EndTime)
+	if err != nil {
+		logger.Errorf("GetTransferStatistics: fail to convert time range to block height range,the error is %v", err)
+		model.Err = errors.New("fail to get block height range of time range")
+		model.ErrCode = types.StatusGetTransferRecordError
+		return model
+	}
+	if ok {
+		bucketExpr, bucketLoc := hourBucketExpr(cosDb)
+		sql := cosDb.Model(plugins.TransferRecord{}).
+			Select(bucketExpr + " AS bucket, COUNT(*), COALESCE(SUM(amount), 0)").
+			Where("block_height >= ? AND block_height <= ?", sBlkNum, eBlkNum)
+		if utils.CheckIsNotEmptyStr(query.Account) {
+			acctFilter, acctArgs := accountFilter(cosDb, query.Account, query.Direction)
+			sql = sql.Where(acctFilter, acctArgs...)
+		}
+		rows,err := sql.Group("bucket").Rows()
+		if err != nil {
+			logger.Errorf("GetTransferStatistics: fail to get transfer statistics,the error is %v", err)
+			model.Err = errors.New("fail to get transfer statistics")
+			model.ErrCode = types.StatusGetTransferRecordError
+			return model
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var (
+				hour, sum string
+				count uint64
+			)
+			if err := rows.Scan(&hour, &count, &sum); err != nil {
+				logger.Errorf("GetTransferStatistics: fail to scan transfer statistics,the error is %v", err)
+				model.Err = errors.New("fail to get transfer statistics")
+				model.ErrCode = types.StatusGetTransferRecordError
+				return model
+			}
+			t,err := time.ParseInLocation("2006-01-02 15:04:05", hour, bucketLoc)
+			amount,amountOk := new(big.Int).SetString(sum, 10)
+			if err != nil || !amountOk {
+				logger.Errorf("GetTransferStatistics: fail to parse transfer statistics of hour %v and sum %v", hour, sum)
+				model.Err = errors.New("fail to get transfer statistics")
+				model.ErrCode = types.StatusGetTransferRecordError
+				return model
+			}
+			key := t.Truncate(query.Interval).Unix()
+			counts[key] += count
+			if amounts[key] == nil {
+				amounts[key] = new(big.Int)
+			}
+			amounts[key].Add(amounts[key], amount)
+		}
+		if err := rows.Err(); err != nil {
+			logger.Errorf("GetTransferStatistics: fail to read transfer statistics,the error is %v", err)
+			model.Err = errors.New("fail to get transfer statistics")
+			model.ErrCode = types.StatusGetTransferRecordError
+			return model
+		}
+	}
+	// fill every bucket in the time range, including the empty ones
+	for t := firstBucket; t.Before(query.EndTime); t = t.Add(query.Interval) {
+		amount := amounts[t.Unix()]
+		if amount == nil {
+			amount = new(big.Int)
+		}
+		model.Buckets = append(model.Buckets, &types.TransferStatisticsBucket{
+			StartTime: t.UTC().Format(time.RFC3339),
+			Count: counts[t.Unix()],
+			Amount: amount.String(),
+			AmountCos: utils.FormatCosAmount(amount),
+		})
+	}
+	return model
+}
+
+// get transfer record by operation id, if isTrxHash = true, id is a trx hash and get all the transfer operations of the trx.
+// MaxQueryBlkNum of the result is the block height of the records
+func getTransferRecordByOperation(cosDb *gorm.DB, id string, isTrxHash bool) *types.QueryTransferRecordModel {
+	logger := logs.GetLogger()
+	model := &types.QueryTransferRecordModel{
+		List: make([]*types.TransferRecord, 0),
+	}
+	var validErr error
+	if isTrxHash {
+		validErr = checkTrxHash(id)
+	} else {
+		validErr = checkOperationId(id)
+	}
+	if validErr != nil {
+		logger.Errorf("GetTransferRecordByOperation: %v", validErr)
+		model.Err = validErr
+		model.ErrCode = types.StatusParamInvalidError
+		return model
+	}
+	lib,err := getLib(cosDb)
+	if err != nil {
+		logger.Errorf("GetTransferRecordByOperation: fail to lib,the error is %v", err)
+		model.Err = errors.New("fail to get lib")
+		model.ErrCode = types.StatusGetLibError
+		return model
+	}
+	model.Lib = lib
+	maxBlkNum,err := getMaxBlockHeight(cosDb)
+	if err != nil {
+		logger.Errorf("GetTransferRecordByOperation: fail to get max block height in transfer record,the error is %v", err)
+		model.Err = errors.New("fail to get head block height of transfer record")
+		model.ErrCode = types.StatusGetTransferRecordError
+		return model
+	}
+	var tList []*plugins.TransferRecord
+	sql := cosDb.Model(plugins.TransferRecord{})
+	if isTrxHash {
+		// operation id is trx hash + "_" + operation index
+		sql = sql.Where(likeCondition(sql, "operation_id"), escapeLikePattern(id + "_") + "%")
+	} else {
+		sql = sql.Where("operation_id = ?", id)
+	}
+	err = sql.Order("operation_id ASC").Find(&tList).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		logger.Errorf("GetTransferRecordByOperation: fail to get transfer record,the error is %v", err)
+		model.Err = errors.New("fail to get transfer record")
+		model.ErrCode = types.StatusGetTransferRecordError
+		return model
+	}
+	model.MaxQueryBlkNum = maxBlkNum
+	for _,rec := range tList {
+		model.List = append(model.List, convertTransferRecord(rec, "", lib, maxBlkNum))
+	}
+	return model
+}
+
+// get the function to find the max block height of transfer records whose block time is before a time
+func maxBlockHeightBeforeOf(db *gorm.DB) func(time.Time) (uint64,bool,error) {
+	return func(t time.Time) (uint64,bool,error) {
+		return getMaxBlockHeightBefore(db, t)
+	}
+}
+
+// get the max block height of transfer records whose block time is before t.
+// there is no index on block_time, but block time grows with block height,
+// so binary search the block heights by the block_height index, a probe reads the newest record at or below a height
+func getMaxBlockHeightBefore(db *gorm.DB, t time.Time) (uint64,bool,error) {
+	var bounds struct {
+		Low uint64
+		High uint64
+	}
+	err := db.Model(plugins.TransferRecord{}).Select("COALESCE(MIN(block_height), 0) AS low, COALESCE(MAX(block_height), 0) AS high").Scan(&bounds).Error
+	if err != nil {
+		return 0,false,err
+	}
+	var (
+		maxBlkNum uint64
+		found bool
+	)
+	// the newest record first, it's before t in the recent time ranges queried most
+	for low, high, mid := bounds.Low, bounds.High, bounds.High; low <= high; mid = low + (high - low) / 2 {
+		var rec plugins.TransferRecord
+		err := db.Model(plugins.TransferRecord{}).Select("block_height, block_time").
+			Where("block_height >= ? AND block_height <= ?", low, mid).
+			Order("block_height DESC").Limit(1).Take(&rec).Error
+		if err == gorm.ErrRecordNotFound {
+			low = mid + 1
+			continue
+		}
+		if err != nil {
+			return 0,false,err
+		}
+		if rec.BlockTime.Before(t) {
+			// no record in (rec.BlockHeight, mid]
+			maxBlkNum, found = rec.BlockHeight, true
+			low = mid + 1
+		} else if rec.BlockHeight == 0 {
+			break
+		} else {
+			// the records in [rec.BlockHeight, mid] are not before t
+			high = rec.BlockHeight - 1
+		}
+	}
+	return maxBlkNum,found,nil
+}
@@ -0,0 +1,641 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/coschain/contentos-go/app/plugins"
+	"github.com/jinzhu/gorm"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"transfer_history/config"
+	"transfer_history/types"
+)
+
+// open a sqlite db with the lib and transfer records as an observe node db, it's closed when the test finishes
+func openTestNodeDb(t *testing.T, lib uint64, recs ...*plugins.TransferRecord) *gorm.DB {
+	t.Helper()
+	return openTestNodeDbAt(t, filepath.Join(t.TempDir(), "node.db"), lib, recs...)
+}
+
+// open the sqlite observe node db at path with the lib and transfer records, it's closed when the test finishes
+func openTestNodeDbAt(t *testing.T, path string, lib uint64, recs ...*plugins.TransferRecord) *gorm.DB {
+	t.Helper()
+	nodeDb,err := openDb(&config.DbConfig{Driver: config.DbDriverSqlite, DbName: path})
+	if err != nil {
+		t.Fatalf("open test db: %v", err)
+	}
+	if err := nodeDb.AutoMigrate(&plugins.TransferRecord{}).Error; err != nil {
+		t.Fatalf("create transfer record table: %v", err)
+	}
+	if err := nodeDb.Table(types.LibTableName).AutoMigrate(&types.LibInfo{}).Error; err != nil {
+		t.Fatalf("create lib table: %v", err)
+	}
+	if err := nodeDb.Table(types.LibTableName).Create(&types.LibInfo{Lib: lib}).Error; err != nil {
+		t.Fatalf("set lib: %v", err)
+	}
+	for _,rec := range recs {
+		if err := nodeDb.Create(rec).Error; err != nil {
+			t.Fatalf("add record: %v", err)
+		}
+	}
+	t.Cleanup(func() {
+		nodeDb.Close()
+	})
+	return nodeDb
+}
+
+// use the dbs as the nodes of the observe node pool of the default manager with the load balance policy during a test
+func useTestNodePool(t *testing.T, policy string, dbs ...*gorm.DB) *nodePool {
+	pool := &nodePool{policy: policy}
+	for i,nodeDb := range dbs {
+		host := fmt.Sprintf("node%v", i)
+		pool.nodes = append(pool.nodes, &fullNode{cfg: &config.DbConfig{Host: host}, conn: newDbConn(nodeDb, host), healthy: true})
+	}
+	defaultManager.lock.Lock()
+	defaultManager.pool = pool
+	defaultManager.lock.Unlock()
+	t.Cleanup(func() {
+		defaultManager.lock.Lock()
+		defaultManager.pool = nil
+		defaultManager.lock.Unlock()
+	})
+	return pool
+}
+
+// use a sqlite db with the lib and transfer records as the only observe node db during a test
+func useTestNodeDb(t *testing.T, lib uint64, recs ...*plugins.TransferRecord) *gorm.DB {
+	t.Helper()
+	nodeDb := openTestNodeDb(t, lib, recs...)
+	useTestNodePool(t, config.LoadBalanceRoundRobin, nodeDb)
+	return nodeDb
+}
+
+// run the test on the repository of a sqlite observe node db and the memory repository with the same lib and records
+func forEachRepository(t *testing.T, lib uint64, recs []*plugins.TransferRecord, test func(t *testing.T, repo TransferRepository)) {
+	t.Run("sql", func(t *testing.T) {
+		useTestNodeDb(t, lib, recs...)
+		test(t, NewFullNodeRepository())
+	})
+	t.Run("memory", func(t *testing.T) {
+		mem := NewMemoryRepository()
+		mem.SetLib(lib)
+		mem.AddTransferRecord(recs...)
+		test(t, mem)
+	})
+}
+
+// the n-th transfer operation of the trx in block blkNum
+func testTransfer(blkNum uint64, n int, from, to string) *plugins.TransferRecord {
+	return &plugins.TransferRecord{
+		BlockHeight: blkNum,
+		OperationId: fmt.Sprintf("%064x_%d", blkNum, n),
+		From: from,
+		To: to,
+		Amount: 1,
+		BlockTime: time.Unix(int64(blkNum) * 3, 0),
+	}
+}
+
+// the account is checked before any query, no db is opened in the test
+func TestQueriesRejectInvalidAccount(t *testing.T) {
+	forEachRepository(t, 0, nil, func(t *testing.T, repo TransferRepository) {
+		for _,acct := range []string{"", "alice1' OR '1'='1", "alice%", "alic_1", "Alice1", "aaaaaaaaaaaaaaaaa"} {
+			if model := repo.GetTransferRecord(context.Background(), &types.TransferRecordQuery{Account: acct}); model.ErrCode != types.StatusParamInvalidError {
+				t.Errorf("GetTransferRecord of %q: error code is %v, the error is %v", acct, model.ErrCode, model.Err)
+			}
+			if model := repo.GetUserTransferRecordByBlock(context.Background(), 10, acct, types.TxDirectionSend, false); model.ErrCode != types.StatusParamInvalidError {
+				t.Errorf("GetUserTransferRecordByBlock of %q: error code is %v, the error is %v", acct, model.ErrCode, model.Err)
+			}
+		}
+	})
+}
+
+// a page may end in the middle of a block, the next page continues from the operation after its cursor
+func TestCursorInMiddleOfBlock(t *testing.T) {
+	recs := []*plugins.TransferRecord{
+		testTransfer(10, 2, "initminer", "alice1"),
+		testTransfer(10, 0, "initminer", "alice1"),
+		testTransfer(10, 1, "initminer", "bobbob1"),
+		testTransfer(10, 3, "initminer", "alice1"),
+		testTransfer(12, 0, "initminer", "alice1"),
+	}
+	forEachRepository(t, 20, recs, func(t *testing.T, repo TransferRepository) {
+		query := &types.TransferRecordQuery{Account: "alice1", Limit: 2}
+		var pages [][]string
+		for i := 0; i < 3; i++ {
+			model := repo.GetTransferRecord(context.Background(), query)
+			if model.Err != nil {
+				t.Fatalf("page %v: %v", i, model.Err)
+			}
+			var ops []string
+			for _,rec := range model.List {
+				ops = append(ops, rec.OperationId)
+			}
+			pages = append(pages, ops)
+			if !model.HasMore {
+				break
+			}
+			query.Cursor = model.NextCursor
+		}
+		want := [][]string{
+			{testTransfer(10, 0, "", "").OperationId, testTransfer(10, 2, "", "").OperationId},
+			{testTransfer(10, 3, "", "").OperationId, testTransfer(12, 0, "", "").OperationId},
+		}
+		if fmt.Sprint(pages) != fmt.Sprint(want) {
+			t.Fatalf("pages are %v, want %v", pages, want)
+		}
+
+		// the cursor of the last page is kept when there are no more records
+		last := &types.TransferCursor{BlockHeight: 12, OperationId: testTransfer(12, 0, "", "").OperationId}
+		model := repo.GetTransferRecord(context.Background(), &types.TransferRecordQuery{Account: "alice1", Limit: 2, Cursor: last})
+		if model.Err != nil || len(model.List) != 0 || model.HasMore || *model.NextCursor != *last {
+			t.Fatalf("page after the last one: %v records, has more %v, next cursor %+v, error %v", len(model.List), model.HasMore, model.NextCursor, model.Err)
+		}
+	})
+}
+
+func TestTransferRecordDirectionTag(t *testing.T) {
+	for _,c := range []struct {
+		from, to string
+		dir int
+	}{
+		{"alice1", "bobbob1", types.TxDirectionSend},
+		{"bobbob1", "alice1", types.TxDirectionReceive},
+		{"alice1", "alice1", types.TxDirectionAll},
+	} {
+		if got := convertTransferRecord(testTransfer(10, 0, c.from, c.to), "alice1", 10, 10).Direction; got != c.dir {
+			t.Errorf("transfer from %v to %v: direction of alice1 is %v, want %v", c.from, c.to, got, c.dir)
+		}
+	}
+}
+
+// direction 3 gets the records of both directions, a transfer to itself is only returned once
+func TestQueryBothDirections(t *testing.T) {
+	recs := []*plugins.TransferRecord{
+		testTransfer(10, 0, "initminer", "alice1"),
+		testTransfer(11, 0, "alice1", "bobbob1"),
+		testTransfer(12, 0, "alice1", "alice1"),
+		testTransfer(13, 0, "initminer", "bobbob1"),
+		testTransfer(13, 1, "bobbob1", "alice1"),
+	}
+	forEachRepository(t, 20, recs, func(t *testing.T, repo TransferRepository) {
+		for dir,want := range map[int][]int{
+			types.TxDirectionSend: {types.TxDirectionSend, types.TxDirectionAll},
+			types.TxDirectionReceive: {types.TxDirectionReceive, types.TxDirectionAll, types.TxDirectionReceive},
+			types.TxDirectionAll: {types.TxDirectionReceive, types.TxDirectionSend, types.TxDirectionAll, types.TxDirectionReceive},
+		} {
+			model := repo.GetTransferRecord(context.Background(), &types.TransferRecordQuery{Account: "alice1", Direction: dir})
+			if model.Err != nil {
+				t.Fatalf("direction %v: %v", dir, model.Err)
+			}
+			var got []int
+			for _,rec := range model.List {
+				got = append(got, rec.Direction)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("direction %v: records are tagged %v, want %v", dir, got, want)
+			}
+		}
+		model := repo.GetUserTransferRecordByBlock(context.Background(), 13, "alice1", types.TxDirectionAll, true)
+		if model.Err != nil || len(model.List) != 1 || model.List[0].Direction != types.TxDirectionReceive {
+			t.Fatalf("block 13 of both directions: %v records, the error is %v", len(model.List), model.Err)
+		}
+	})
+}
+
+func TestMaxBlockHeightBefore(t *testing.T) {
+	var recs []*plugins.TransferRecord
+	// blocks are 3 seconds apart with gaps, and a block may have several records
+	for i,blkNum := range []uint64{5, 6, 6, 9, 20, 21, 40, 41, 42, 100} {
+		recs = append(recs, testTransfer(blkNum, i, "initminer", "alice1"))
+	}
+	nodeDb := openTestNodeDb(t, 100, recs...)
+	for sec := int64(0); sec <= 310; sec++ {
+		at := time.Unix(sec, 0)
+		var want uint64
+		wantFound := false
+		for _,rec := range recs {
+			if rec.BlockTime.Before(at) {
+				want, wantFound = rec.BlockHeight, true
+			}
+		}
+		got,found,err := getMaxBlockHeightBefore(nodeDb, at)
+		if err != nil {
+			t.Fatalf("max block height before %v: %v", sec, err)
+		}
+		if got != want || found != wantFound {
+			t.Fatalf("max block height before %v is %v(%v), want %v(%v)", sec, got, found, want, wantFound)
+		}
+	}
+}
+
+func TestMaxBlockHeightBeforeInEmptyDb(t *testing.T) {
+	nodeDb := openTestNodeDb(t, 0)
+	if _,found,err := getMaxBlockHeightBefore(nodeDb, time.Now()); err != nil || found {
+		t.Fatalf("found a block in empty db, the error is %v", err)
+	}
+}
+
+// the time range [StartTime, EndTime) is converted to the blocks produced in it
+func TestQueryTimeRange(t *testing.T) {
+	// block n is produced at 3n seconds
+	recs := []*plugins.TransferRecord{
+		testTransfer(10, 0, "initminer", "alice1"),
+		testTransfer(20, 0, "initminer", "alice1"),
+		testTransfer(20, 1, "initminer", "alice1"),
+		testTransfer(30, 0, "initminer", "alice1"),
+		testTransfer(40, 0, "initminer", "alice1"),
+	}
+	forEachRepository(t, 100, recs, func(t *testing.T, repo TransferRepository) {
+		at := func(sec int64) *time.Time {
+			t := time.Unix(sec, 0)
+			return &t
+		}
+		for _,c := range []struct {
+			start uint64
+			startTime, endTime *time.Time
+			want []uint64
+		}{
+			{0, at(60), at(90), []uint64{20, 20}},
+			{0, at(59), at(91), []uint64{20, 20, 30}},
+			{0, at(61), nil, []uint64{30, 40}},
+			{0, nil, at(60), []uint64{10}},
+			{0, nil, at(30), nil},
+			{0, at(121), nil, nil},
+			// the start block height and start time are both applied
+			{35, at(60), nil, []uint64{40}},
+		} {
+			model := repo.GetTransferRecord(context.Background(), &types.TransferRecordQuery{Start: c.start, Account: "alice1", Direction: types.TxDirectionAll, StartTime: c.startTime, EndTime: c.endTime})
+			if model.Err != nil {
+				t.Fatalf("time range [%v, %v): %v", c.startTime, c.endTime, model.Err)
+			}
+			var got []uint64
+			for _,rec := range model.List {
+				blkNum,_ := strconv.ParseUint(rec.BlockHeight, 10, 64)
+				got = append(got, blkNum)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(c.want) {
+				t.Errorf("start %v, time range [%v, %v): blocks are %v, want %v", c.start, c.startTime, c.endTime, got, c.want)
+			}
+		}
+	})
+}
+
+func TestEscapeLikePattern(t *testing.T) {
+	for str,want := range map[string]string{
+		"order":       "order",
+		"50%":         `50\%`,
+		"a_b":         `a\_b`,
+		`c:\tmp`:      `c:\\tmp`,
+		`%_\`:         `\%\_\\`,
+	} {
+		if got := escapeLikePattern(str); got != want {
+			t.Errorf("escaped %q is %q, want %q", str, got, want)
+		}
+	}
+}
+
+func TestTransferRecordFilter(t *testing.T) {
+	transfer := func(blkNum uint64, from, to string, amount uint64, memo string) *plugins.TransferRecord {
+		rec := testTransfer(blkNum, 0, from, to)
+		rec.Amount = amount
+		rec.Memo = memo
+		return rec
+	}
+	recs := []*plugins.TransferRecord{
+		transfer(10, "initminer", "alice1", 100, "order 1001"),
+		transfer(11, "bobbob1", "alice1", 200, "order 1002"),
+		transfer(12, "alice1", "bobbob1", 300, "refund 1001"),
+		transfer(13, "alice1", "carol1", 400, "order"),
+		transfer(14, "carol1", "alice1", 500, ""),
+		transfer(15, "alice1", "daniel1", 1, "50% off"),
+		transfer(16, "alice1", "daniel1", 1, "500 off"),
+		transfer(17, "alice1", "daniel1", 1, "a_b"),
+		transfer(18, "alice1", "daniel1", 1, "axb"),
+		transfer(19, "alice1", "daniel1", 1, `c:\tmp`),
+	}
+	forEachRepository(t, 100, recs, func(t *testing.T, repo TransferRepository) {
+		amount := func(n uint64) *uint64 {
+			return &n
+		}
+		for _,c := range []struct {
+			dir int
+			filter types.TransferRecordFilter
+			want []uint64
+		}{
+			// the counterparty is the other side of the direction
+			{types.TxDirectionReceive, types.TransferRecordFilter{Counterparty: "bobbob1"}, []uint64{11}},
+			{types.TxDirectionSend, types.TransferRecordFilter{Counterparty: "bobbob1"}, []uint64{12}},
+			{types.TxDirectionAll, types.TransferRecordFilter{Counterparty: "bobbob1"}, []uint64{11, 12}},
+			{types.TxDirectionSend, types.TransferRecordFilter{Counterparty: "initminer"}, nil},
+			// the amount range is inclusive
+			{types.TxDirectionAll, types.TransferRecordFilter{MinAmount: amount(200), MaxAmount: amount(400)}, []uint64{11, 12, 13}},
+			{types.TxDirectionAll, types.TransferRecordFilter{MinAmount: amount(450)}, []uint64{14}},
+			{types.TxDirectionReceive, types.TransferRecordFilter{MaxAmount: amount(100)}, []uint64{10}},
+			{types.TxDirectionAll, types.TransferRecordFilter{Memo: "order"}, []uint64{13}},
+			{types.TxDirectionAll, types.TransferRecordFilter{MemoPrefix: "order"}, []uint64{10, 11, 13}},
+			{types.TxDirectionAll, types.TransferRecordFilter{MemoPrefix: "order 100", MinAmount: amount(150)}, []uint64{11}},
+			{types.TxDirectionAll, types.TransferRecordFilter{MemoPrefix: "1001"}, nil},
+			// the wildcards of LIKE in the memo prefix match themselves only
+			{types.TxDirectionAll, types.TransferRecordFilter{MemoPrefix: "5"}, []uint64{15, 16}},
+			{types.TxDirectionAll, types.TransferRecordFilter{MemoPrefix: "50%"}, []uint64{15}},
+			{types.TxDirectionAll, types.TransferRecordFilter{MemoPrefix: "a_"}, []uint64{17}},
+			{types.TxDirectionAll, types.TransferRecordFilter{MemoPrefix: "%"}, nil},
+			{types.TxDirectionAll, types.TransferRecordFilter{MemoPrefix: `c:\`}, []uint64{19}},
+			{types.TxDirectionSend, types.TransferRecordFilter{Counterparty: "daniel1", MemoPrefix: "_"}, nil},
+		} {
+			model := repo.GetTransferRecord(context.Background(), &types.TransferRecordQuery{Account: "alice1", Direction: c.dir, Filter: c.filter})
+			if model.Err != nil {
+				t.Fatalf("direction %v, filter %+v: %v", c.dir, c.filter, model.Err)
+			}
+			var got []uint64
+			for _,rec := range model.List {
+				blkNum,_ := strconv.ParseUint(rec.BlockHeight, 10, 64)
+				got = append(got, blkNum)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(c.want) {
+				t.Errorf("direction %v, filter %+v: blocks are %v, want %v", c.dir, c.filter, got, c.want)
+			}
+		}
+		model := repo.GetTransferRecord(context.Background(), &types.TransferRecordQuery{Account: "alice1", Filter: types.TransferRecordFilter{Counterparty: "bob' OR '1'='1"}})
+		if model.ErrCode != types.StatusParamInvalidError {
+			t.Fatalf("invalid counterparty: error code is %v, the error is %v", model.ErrCode, model.Err)
+		}
+	})
+}
+
+func TestTransferRecordByOperationId(t *testing.T) {
+	recs := []*plugins.TransferRecord{
+		testTransfer(10, 0, "initminer", "alice1"),
+		testTransfer(10, 1, "alice1", "bobbob1"),
+		testTransfer(12, 0, "initminer", "alice1"),
+	}
+	forEachRepository(t, 10, recs, func(t *testing.T, repo TransferRepository) {
+		for _,c := range []struct {
+			id string
+			want []uint64
+		}{
+			{testTransfer(10, 1, "", "").OperationId, []uint64{10}},
+			{testTransfer(12, 0, "", "").OperationId, []uint64{12}},
+			{testTransfer(11, 0, "", "").OperationId, nil},
+		} {
+			model := repo.GetTransferRecordByOperation(context.Background(), c.id, false)
+			if model.Err != nil {
+				t.Fatalf("operation %v: %v", c.id, model.Err)
+			}
+			var got []uint64
+			for _,rec := range model.List {
+				blkNum,_ := strconv.ParseUint(rec.BlockHeight, 10, 64)
+				got = append(got, blkNum)
+				if rec.Direction != 0 {
+					t.Errorf("operation %v: direction is %v without account", c.id, rec.Direction)
+				}
+				// the block height of the record decides whether it's irreversible
+				if rec.Irreversible != (blkNum <= model.Lib) {
+					t.Errorf("operation %v: record in block %v is irreversible %v, lib is %v", c.id, blkNum, rec.Irreversible, model.Lib)
+				}
+			}
+			if fmt.Sprint(got) != fmt.Sprint(c.want) {
+				t.Errorf("operation %v: blocks are %v, want %v", c.id, got, c.want)
+			}
+		}
+		// all the operations of a trx are found by its hash
+		for _,c := range []struct {
+			hash string
+			want []string
+		}{
+			{fmt.Sprintf("%064x", 10), []string{recs[0].OperationId, recs[1].OperationId}},
+			{fmt.Sprintf("%064x", 12), []string{recs[2].OperationId}},
+			{fmt.Sprintf("%064x", 11), nil},
+		} {
+			model := repo.GetTransferRecordByOperation(context.Background(), c.hash, true)
+			if model.Err != nil {
+				t.Fatalf("trx %v: %v", c.hash, model.Err)
+			}
+			var got []string
+			for _,rec := range model.List {
+				got = append(got, rec.OperationId)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(c.want) {
+				t.Errorf("trx %v: operations are %v, want %v", c.hash, got, c.want)
+			}
+		}
+		for _,id := range []string{"", "op10_0", testTransfer(10, 0, "", "").OperationId + "%", "%", fmt.Sprintf("%064x", 10)} {
+			if model := repo.GetTransferRecordByOperation(context.Background(), id, false); model.ErrCode != types.StatusParamInvalidError {
+				t.Errorf("invalid operation id %q: error code is %v, the error is %v", id, model.ErrCode, model.Err)
+			}
+		}
+		for _,hash := range []string{"", fmt.Sprintf("%064x_0", 10), "%", strings.Repeat("_", 64)} {
+			if model := repo.GetTransferRecordByOperation(context.Background(), hash, true); model.ErrCode != types.StatusParamInvalidError {
+				t.Errorf("invalid trx hash %q: error code is %v, the error is %v", hash, model.ErrCode, model.Err)
+			}
+		}
+	})
+}
+
+// the records of all the accounts are paged together, a transfer between two of them is counted once
+func TestBatchTransferRecordPaging(t *testing.T) {
+	recs := []*plugins.TransferRecord{
+		testTransfer(10, 0, "initminer", "alice1"),
+		testTransfer(11, 0, "initminer", "bobbob1"),
+		testTransfer(12, 0, "alice1", "bobbob1"),
+		testTransfer(13, 0, "initminer", "carol1"),
+		testTransfer(14, 0, "initminer", "alice1"),
+	}
+	forEachRepository(t, 20, recs, func(t *testing.T, repo TransferRepository) {
+		query := &types.BatchTransferRecordQuery{
+			Accounts: []*types.AccountStartBlock{{Account: "alice1"}, {Account: "bobbob1"}},
+			Direction: types.TxDirectionAll,
+			Limit: 2,
+		}
+		first := repo.GetBatchTransferRecord(context.Background(), query)
+		if first.Err != nil {
+			t.Fatalf("first page: %v", first.Err)
+		}
+		if !first.HasMore || first.NextCursor == nil || first.NextCursor.BlockHeight != 11 {
+			t.Fatalf("first page ends at %+v, has more %v", first.NextCursor, first.HasMore)
+		}
+		if len(first.Records["alice1"]) != 1 || len(first.Records["bobbob1"]) != 1 {
+			t.Fatalf("first page has %v and %v records", len(first.Records["alice1"]), len(first.Records["bobbob1"]))
+		}
+		query.Cursor = first.NextCursor
+		second := repo.GetBatchTransferRecord(context.Background(), query)
+		if second.Err != nil {
+			t.Fatalf("second page: %v", second.Err)
+		}
+		if second.HasMore || second.NextCursor == nil || second.NextCursor.BlockHeight != 14 {
+			t.Fatalf("second page ends at %+v, has more %v", second.NextCursor, second.HasMore)
+		}
+		if len(second.Records["alice1"]) != 2 || len(second.Records["bobbob1"]) != 1 {
+			t.Fatalf("second page has %v and %v records", len(second.Records["alice1"]), len(second.Records["bobbob1"]))
+		}
+		if rec := second.Records["bobbob1"][0]; rec.BlockHeight != "12" || rec.Direction != types.TxDirectionReceive {
+			t.Fatalf("second page of bobbob1 is block %v of direction %v", rec.BlockHeight, rec.Direction)
+		}
+
+		// every account has its own start block
+		query = &types.BatchTransferRecordQuery{
+			Accounts: []*types.AccountStartBlock{{Account: "alice1", Start: 11}, {Account: "bobbob1", Start: 12}},
+			Direction: types.TxDirectionReceive,
+		}
+		model := repo.GetBatchTransferRecord(context.Background(), query)
+		if model.Err != nil {
+			t.Fatalf("start blocks: %v", model.Err)
+		}
+		if len(model.Records["alice1"]) != 1 || model.Records["alice1"][0].BlockHeight != "14" ||
+			len(model.Records["bobbob1"]) != 1 || model.Records["bobbob1"][0].BlockHeight != "12" {
+			t.Fatalf("start blocks: records are %v and %v", len(model.Records["alice1"]), len(model.Records["bobbob1"]))
+		}
+	})
+}
+
+func TestTransferSummary(t *testing.T) {
+	transfer := func(blkNum uint64, n int, from, to string, amount uint64) *plugins.TransferRecord {
+		rec := testTransfer(blkNum, n, from, to)
+		rec.Amount = amount
+		return rec
+	}
+	// block n is produced at 3n seconds
+	recs := []*plugins.TransferRecord{
+		transfer(10, 0, "initminer", "alice1", 3000000),
+		transfer(10, 1, "initminer", "bobbob1", 7000000),
+		transfer(20, 0, "alice1", "bobbob1", 1500000),
+		transfer(30, 0, "alice1", "alice1", 200000),
+		transfer(40, 0, "initminer", "alice1", 4611686018427387904),
+	}
+	forEachRepository(t, 100, recs, func(t *testing.T, repo TransferRepository) {
+		at := func(sec int64) *time.Time {
+			t := time.Unix(sec, 0)
+			return &t
+		}
+		for _,c := range []struct {
+			query types.TransferSummaryQuery
+			want types.TransferSummary
+			start, end uint64
+		}{
+			{types.TransferSummaryQuery{End: 30},
+				types.TransferSummary{InAmount: "3200000", OutAmount: "1700000", NetAmount: "1500000", InAmountCos: "3.200000", OutAmountCos: "1.700000", NetAmountCos: "1.500000", InCount: 2, OutCount: 2},
+				0, 30},
+			{types.TransferSummaryQuery{Start: 15, End: 20},
+				types.TransferSummary{InAmount: "0", OutAmount: "1500000", NetAmount: "-1500000", InAmountCos: "0.000000", OutAmountCos: "1.500000", NetAmountCos: "-1.500000", InCount: 0, OutCount: 1},
+				15, 20},
+			// the end block is limited by the max block height
+			{types.TransferSummaryQuery{Start: 40, End: 1000},
+				types.TransferSummary{InAmount: "4611686018427387904", OutAmount: "0", NetAmount: "4611686018427387904", InAmountCos: "4611686018427.387904", OutAmountCos: "0.000000", NetAmountCos: "4611686018427.387904", InCount: 1, OutCount: 0},
+				40, 40},
+			{types.TransferSummaryQuery{StartTime: at(60), EndTime: at(91)},
+				types.TransferSummary{InAmount: "200000", OutAmount: "1700000", NetAmount: "-1500000", InAmountCos: "0.200000", OutAmountCos: "1.700000", NetAmountCos: "-1.500000", InCount: 1, OutCount: 2},
+				11, 30},
+			// no block in the time range
+			{types.TransferSummaryQuery{EndTime: at(30)},
+				types.TransferSummary{InAmount: "0", OutAmount: "0", NetAmount: "0", InAmountCos: "0.000000", OutAmountCos: "0.000000", NetAmountCos: "0.000000"},
+				0, 0},
+		} {
+			c.query.Account = "alice1"
+			model := repo.GetTransferSummary(context.Background(), &c.query)
+			if model.Err != nil {
+				t.Fatalf("summary of %+v: %v", c.query, model.Err)
+			}
+			c.want.Account = "alice1"
+			if *model.Summary != c.want || model.StartBlkNum != c.start || model.EndBlkNum != c.end {
+				t.Errorf("summary of %+v is %+v in [%v, %v], want %+v in [%v, %v]", c.query, *model.Summary, model.StartBlkNum, model.EndBlkNum, c.want, c.start, c.end)
+			}
+		}
+		if model := repo.GetTransferSummary(context.Background(), &types.TransferSummaryQuery{Account: "alice%"}); model.ErrCode != types.StatusParamInvalidError {
+			t.Fatalf("invalid account: error code is %v, the error is %v", model.ErrCode, model.Err)
+		}
+	})
+}
+
+// the records are bucketed by UTC hour and day, the same in sql and memory
+func TestTransferStatistics(t *testing.T) {
+	transfer := func(blkNum uint64, from, to string, amount uint64, blockTime string) *plugins.TransferRecord {
+		rec := testTransfer(blkNum, 0, from, to)
+		rec.Amount = amount
+		rec.BlockTime,_ = time.Parse(time.RFC3339, blockTime)
+		return rec
+	}
+	recs := []*plugins.TransferRecord{
+		transfer(100, "initminer", "alice1", 1, "2026-10-01T22:59:59Z"),
+		transfer(101, "alice1", "bobbob1", 2, "2026-10-01T23:00:00Z"),
+		transfer(102, "initminer", "alice1", 4, "2026-10-01T23:59:59Z"),
+		transfer(103, "initminer", "bobbob1", 8, "2026-10-02T00:00:00Z"),
+		transfer(104, "alice1", "bobbob1", 16, "2026-10-02T00:00:01Z"),
+	}
+	forEachRepository(t, 200, recs, func(t *testing.T, repo TransferRepository) {
+		for _,c := range []struct {
+			acct string
+			dir int
+			interval time.Duration
+			start, end string
+			want string
+		}{
+			{"", 0, time.Hour, "2026-10-01T22:00:00Z", "2026-10-02T01:00:00Z",
+				"2026-10-01T22:00:00Z:1:1 2026-10-01T23:00:00Z:2:6 2026-10-02T00:00:00Z:2:24"},
+			{"", 0, 24 * time.Hour, "2026-10-01T00:00:00Z", "2026-10-03T00:00:00Z",
+				"2026-10-01T00:00:00Z:3:7 2026-10-02T00:00:00Z:2:24"},
+			{"alice1", types.TxDirectionSend, time.Hour, "2026-10-01T23:00:00Z", "2026-10-02T01:00:00Z",
+				"2026-10-01T23:00:00Z:1:2 2026-10-02T00:00:00Z:1:16"},
+			{"alice1", types.TxDirectionReceive, 24 * time.Hour, "2026-10-01T00:00:00Z", "2026-10-03T00:00:00Z",
+				"2026-10-01T00:00:00Z:2:5"},
+			// the records outside the time range aren't counted
+			{"", 0, time.Hour, "2026-10-01T23:00:00Z", "2026-10-02T00:00:00Z",
+				"2026-10-01T23:00:00Z:2:6"},
+		} {
+			query := &types.TransferStatisticsQuery{Account: c.acct, Direction: c.dir, Interval: c.interval}
+			query.StartTime,_ = time.Parse(time.RFC3339, c.start)
+			query.EndTime,_ = time.Parse(time.RFC3339, c.end)
+			model := repo.GetTransferStatistics(context.Background(), query)
+			if model.Err != nil {
+				t.Fatalf("statistics of %q in [%v, %v): %v", c.acct, c.start, c.end, model.Err)
+			}
+			var got []string
+			for _,b := range model.Buckets {
+				if b.Count > 0 {
+					got = append(got, fmt.Sprintf("%v:%v:%v", b.StartTime, b.Count, b.Amount))
+				}
+			}
+			if strings.Join(got, " ") != c.want {
+				t.Errorf("statistics of %q in [%v, %v) by %v: buckets are %v, want %v", c.acct, c.start, c.end, c.interval, got, c.want)
+			}
+		}
+	})
+}
+
+func TestPinNode(t *testing.T) {
+	m := newTestManager(t, config.LoadBalanceRoundRobin, createTestNodeDb(t, 10, 10), createTestNodeDb(t, 20, 20))
+	repo := m.FullNodeRepository()
+	libs := make(map[uint64]bool)
+	for i := 0; i < 4; i++ {
+		lib,err := repo.GetLib(context.Background())
+		if err != nil {
+			t.Fatalf("get lib: %v", err)
+		}
+		libs[lib] = true
+	}
+	if len(libs) != 2 {
+		t.Fatalf("queries are not spread across the nodes: %v", libs)
+	}
+	for i := 0; i < 2; i++ {
+		libs = make(map[uint64]bool)
+		err := WithPinnedNode(context.Background(), repo, func(pinned TransferRepository) error {
+			for j := 0; j < 4; j++ {
+				lib,err := pinned.GetLib(context.Background())
+				if err != nil {
+					return err
+				}
+				libs[lib] = true
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("pin node: %v", err)
+		}
+		if len(libs) != 1 {
+			t.Fatalf("queries of a pinned repository run on several nodes: %v", libs)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/coschain/contentos-go/app/plugins"
+	"github.com/jinzhu/gorm"
+	"time"
+	"transfer_history/config"
+	"transfer_history/logs"
+	"transfer_history/types"
+)
+
+var errNoLocalStore = errors.New("local store is not opened")
+
+// transfer records of full node db fetched in one round of sync
+type transferBatch struct {
+	// lib of full node db, at most head
+	lib uint64
+	// processed block height of full node db
+	head uint64
+	// the irreversible records are synced up to this height
+	end uint64
+	records []*plugins.TransferRecord
+	// the records above end, they are replaced in every round until they become irreversible
+	reversible []*plugins.TransferRecord
+}
+
+// transferIndexer tails the transfer records of full node db by block height and writes them into the local store.
+// the irreversible records are synced once, the reversible ones are replaced in every round after it catches up
+type transferIndexer struct {
+	store *gorm.DB
+	// run fn on a full node db
+	source func(ctx context.Context, fn func(cosDb *gorm.DB) error) error
+	// wake up the waiters of new blocks of the local store
+	watcher *blockWatcher
+	batchBlocks uint64
+	interval time.Duration
+	// cancel the running sync and stop
+	ctx context.Context
+	cancel context.CancelFunc
+	done chan struct{}
+}
+
+// open the local store, create its tables and indexes, and start syncing from the full node dbs of the manager
+func (m *Manager) startLocalIndexer() error {
+	logger := logs.GetLogger()
+	cfg := config.GetLocalStoreDbConfig()
+	store,err := openDb(cfg)
+	if err != nil {
+		logger.Errorf("startLocalIndexer: fail to open local store, the error is %v", err)
+		return err
+	}
+	if err := migrateLocalStore(store); err != nil {
+		logger.Errorf("startLocalIndexer: fail to create tables of local store, the error is %v", err)
+		store.Close()
+		return err
+	}
+	ctx,cancel := context.WithCancel(context.Background())
+	idx := &transferIndexer{
+		store: store,
+		source: m.queryFullNode,
+		watcher: m.storeWatcher,
+		batchBlocks: config.GetLocalIndexerBatchBlocks(),
+		interval: config.GetLocalIndexerInterval(),
+		ctx: ctx,
+		cancel: cancel,
+		done: make(chan struct{}),
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.pool == nil {
+		// the manager is closed while opening the local store
+		cancel()
+		store.Close()
+		return errNoAvailableNode
+	}
+	m.store = newDbConn(store, cfg.Host)
+	m.indexer = idx
+	go idx.run()
+	return nil
+}
+
+// stop syncing and wait for the running round to return
+func (idx *transferIndexer) close() {
+	idx.cancel()
+	<-idx.done
+}
+
+// create the tables of local store, transfer records are indexed for the queries by account and direction
+func migrateLocalStore(store *gorm.DB) error {
+	if err := store.AutoMigrate(&plugins.TransferRecord{}, &types.IndexerProgress{}).Error; err != nil {
+		return err
+	}
+	if err := store.Table(types.LibTableName).AutoMigrate(&types.LibInfo{}).Error; err != nil {
+		return err
+	}
+	model := store.Model(&plugins.TransferRecord{})
+	if err := model.AddIndex("idx_transfer_from_height", "from", "block_height", "operation_id").Error; err != nil {
+		return err
+	}
+	return model.AddIndex("idx_transfer_to_height", "to", "block_height", "operation_id").Error
+}
+
+func (idx *transferIndexer) run() {
+	logger := logs.GetLogger()
+	defer close(idx.done)
+	for {
+		caughtUp,err := idx.sync()
+		if idx.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger.Errorf("transferIndexer: fail to sync transfer records, the error is %v", err)
+		}
+		wait := idx.interval
+		if err == nil && !caughtUp {
+			wait = 0
+		}
+		select {
+		case <-idx.ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// sync one batch of records, return true if the local store catches up with the lib of full node db
+func (idx *transferIndexer) sync() (bool,error) {
+	logger := logs.GetLogger()
+	progress := &types.IndexerProgress{}
+	if err := idx.store.FirstOrInit(progress, types.IndexerProgress{ID: 1}).Error; err != nil {
+		return false,err
+	}
+	var batch *transferBatch
+	err := idx.source(idx.ctx, func(cosDb *gorm.DB) (err error) {
+		batch,err = fetchTransferBatch(cosDb, progress.SyncHeight, idx.batchBlocks)
+		return err
+	})
+	if err != nil {
+		return false,err
+	}
+	tx := idx.store.Begin()
+	if err := tx.Error; err != nil {
+		return false,err
+	}
+	defer tx.RollbackUnlessCommitted()
+	// remove the reversible records of last round
+	if err := tx.Where("block_height > ?", progress.SyncHeight).Delete(plugins.TransferRecord{}).Error; err != nil {
+		return false,err
+	}
+	for _,list := range [][]*plugins.TransferRecord{batch.records, batch.reversible} {
+		for _,rec := range list {
+			rec.ID = 0
+			if err := tx.Create(rec).Error; err != nil {
+				return false,err
+			}
+		}
+	}
+	progress.SyncHeight = batch.end
+	progress.UpstreamLib = batch.lib
+	progress.UpstreamHeight = batch.head
+	if err := tx.Save(progress).Error; err != nil {
+		return false,err
+	}
+	// the lib of local store is its sync height
+	if err := tx.Table(types.LibTableName).Delete(types.LibInfo{}).Error; err != nil {
+		return false,err
+	}
+	libInfo := &types.LibInfo{Lib: batch.end, LastCheckTime: uint32(time.Now().Unix())}
+	if err := tx.Table(types.LibTableName).Create(libInfo).Error; err != nil {
+		return false,err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return false,err
+	}
+	idx.watcher.update(batch.head, batch.end)
+	logger.Debugf("transferIndexer: sync %v irreversible and %v reversible records, sync height is %v, lib of full node is %v", len(batch.records), len(batch.reversible), batch.end, batch.lib)
+	return batch.end >= batch.lib,nil
+}
+
+// fetch the irreversible records in (syncHeight, syncHeight + batchBlocks] and below lib,
+// and the reversible records up to the processed block height if the irreversible ones are all fetched
+func fetchTransferBatch(cosDb *gorm.DB, syncHeight, batchBlocks uint64) (*transferBatch,error) {
+	lib,err := getLib(cosDb)
+	if err != nil {
+		return nil,err
+	}
+	var process plugins.BlockLogProcess
+	if err := cosDb.Take(&process).Error; err != nil {
+		return nil,err
+	}
+	if lib > process.BlockHeight {
+		// the records above the processed block are not written yet
+		lib = process.BlockHeight
+	}
+	batch := &transferBatch{lib: lib, head: process.BlockHeight, end: syncHeight}
+	if lib > syncHeight {
+		batch.end = syncHeight + batchBlocks
+		if batch.end > lib {
+			batch.end = lib
+		}
+		err := cosDb.Where("block_height > ? AND block_height <= ?", syncHeight, batch.end).
+			Order("block_height ASC").Order("operation_id ASC").
+			Find(&batch.records).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return nil,err
+		}
+	}
+	if batch.end >= lib && batch.head > batch.end {
+		err := cosDb.Where("block_height > ? AND block_height <= ?", batch.end, batch.head).
+			Order("block_height ASC").Order("operation_id ASC").
+			Find(&batch.reversible).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return nil,err
+		}
+	}
+	return batch,nil
+}
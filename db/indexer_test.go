@@ -0,0 +1,165 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/coschain/contentos-go/app/plugins"
+	"github.com/jinzhu/gorm"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"transfer_history/config"
+	"transfer_history/types"
+)
+
+// start an indexer syncing from nodeDb into a new sqlite local store, the store is the local store of the default manager during a test
+func newTestIndexer(t *testing.T, nodeDb *gorm.DB, batchBlocks uint64) *transferIndexer {
+	t.Helper()
+	useTestNodePool(t, config.LoadBalanceRoundRobin, nodeDb)
+	store,err := openDb(&config.DbConfig{Driver: config.DbDriverSqlite, DbName: filepath.Join(t.TempDir(), "local.db")})
+	if err != nil {
+		t.Fatalf("open local store: %v", err)
+	}
+	if err := migrateLocalStore(store); err != nil {
+		t.Fatalf("create tables of local store: %v", err)
+	}
+	defaultManager.lock.Lock()
+	defaultManager.store = newDbConn(store, "local")
+	defaultManager.lock.Unlock()
+	t.Cleanup(func() {
+		defaultManager.lock.Lock()
+		defaultManager.store = nil
+		defaultManager.lock.Unlock()
+		store.Close()
+	})
+	return &transferIndexer{store: store, source: defaultManager.queryFullNode, watcher: defaultManager.storeWatcher, batchBlocks: batchBlocks, ctx: context.Background()}
+}
+
+// sync one round and check whether the indexer catches up and the lib of local store
+func syncTestIndexer(t *testing.T, idx *transferIndexer, wantCaughtUp bool, wantLib uint64) {
+	t.Helper()
+	caughtUp,err := idx.sync()
+	if err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	lib,err := NewLocalStoreRepository().GetLib(context.Background())
+	if err != nil {
+		t.Fatalf("get lib of local store: %v", err)
+	}
+	if caughtUp != wantCaughtUp || lib != wantLib {
+		t.Fatalf("caught up is %v and lib is %v, want %v and %v", caughtUp, lib, wantCaughtUp, wantLib)
+	}
+}
+
+// get the block heights and irreversible flags of the records of alice1 in local store
+func localTestRecords(t *testing.T) ([]uint64,[]bool) {
+	t.Helper()
+	model := NewLocalStoreRepository().GetTransferRecord(context.Background(), &types.TransferRecordQuery{Account: "alice1", Direction: types.TxDirectionAll, IncludeReversible: true})
+	if model.Err != nil {
+		t.Fatalf("query local store: %v", model.Err)
+	}
+	var (
+		heights []uint64
+		irreversible []bool
+	)
+	for _,rec := range model.List {
+		blkNum,_ := strconv.ParseUint(rec.BlockHeight, 10, 64)
+		heights = append(heights, blkNum)
+		irreversible = append(irreversible, rec.Irreversible)
+	}
+	return heights,irreversible
+}
+
+func TestIndexerSyncsInBatches(t *testing.T) {
+	var recs []*plugins.TransferRecord
+	for blkNum := uint64(5); blkNum <= 30; blkNum += 5 {
+		recs = append(recs, testTransfer(blkNum, 0, "initminer", "alice1"))
+	}
+	nodeDb := openTestNodeDb(t, 30, recs...)
+	setTestBlockProcess(t, nodeDb, 30)
+	idx := newTestIndexer(t, nodeDb, 10)
+
+	syncTestIndexer(t, idx, false, 10)
+	if heights,_ := localTestRecords(t); fmt.Sprint(heights) != fmt.Sprint([]uint64{5, 10}) {
+		t.Fatalf("records after the first batch are in blocks %v", heights)
+	}
+	syncTestIndexer(t, idx, false, 20)
+	syncTestIndexer(t, idx, true, 30)
+	if heights,_ := localTestRecords(t); fmt.Sprint(heights) != fmt.Sprint([]uint64{5, 10, 15, 20, 25, 30}) {
+		t.Fatalf("records after all the batches are in blocks %v", heights)
+	}
+	// nothing changes after the indexer catches up
+	syncTestIndexer(t, idx, true, 30)
+	if heights,_ := localTestRecords(t); len(heights) != 6 {
+		t.Fatalf("records after catching up are in blocks %v", heights)
+	}
+}
+
+// the reversible records are replaced in every round, so a record dropped by a fork disappears from the local store
+func TestIndexerReplacesReversibleRecords(t *testing.T) {
+	forked := testTransfer(14, 0, "initminer", "alice1")
+	nodeDb := openTestNodeDb(t, 12,
+		testTransfer(10, 0, "initminer", "alice1"),
+		testTransfer(13, 0, "initminer", "alice1"),
+		forked,
+	)
+	setTestBlockProcess(t, nodeDb, 14)
+	idx := newTestIndexer(t, nodeDb, 100)
+
+	syncTestIndexer(t, idx, true, 12)
+	heights,irreversible := localTestRecords(t)
+	if fmt.Sprint(heights) != fmt.Sprint([]uint64{10, 13, 14}) || !irreversible[0] || irreversible[1] || irreversible[2] {
+		t.Fatalf("records are in blocks %v, irreversible %v", heights, irreversible)
+	}
+
+	// block 14 is replaced by a fork, and block 15 is produced
+	if err := nodeDb.Delete(forked).Error; err != nil {
+		t.Fatalf("remove the forked record: %v", err)
+	}
+	for _,rec := range []*plugins.TransferRecord{testTransfer(14, 1, "alice1", "bobbob1"), testTransfer(15, 0, "initminer", "alice1")} {
+		if err := nodeDb.Create(rec).Error; err != nil {
+			t.Fatalf("add record: %v", err)
+		}
+	}
+	if err := nodeDb.Model(&plugins.BlockLogProcess{}).Where("1 = 1").Update("block_height", 15).Error; err != nil {
+		t.Fatalf("update block process: %v", err)
+	}
+	syncTestIndexer(t, idx, true, 12)
+	model := NewLocalStoreRepository().GetUserTransferRecordByBlock(context.Background(), 14, "alice1", types.TxDirectionAll, true)
+	if model.Err != nil || len(model.List) != 1 || model.List[0].OperationId != testTransfer(14, 1, "", "").OperationId {
+		t.Fatalf("records of the forked block are %+v, the error is %v", model.List, model.Err)
+	}
+
+	// the reversible records become irreversible
+	if err := nodeDb.Table(types.LibTableName).Update("lib", 15).Error; err != nil {
+		t.Fatalf("update lib: %v", err)
+	}
+	syncTestIndexer(t, idx, true, 15)
+	if heights,irreversible := localTestRecords(t); fmt.Sprint(heights) != fmt.Sprint([]uint64{10, 13, 14, 15}) || !irreversible[3] {
+		t.Fatalf("records are in blocks %v, irreversible %v", heights, irreversible)
+	}
+}
+
+// the lib of local store is at most the lib of the node, and never above the block the node has processed
+func TestIndexerLibNeverPassesNodeLib(t *testing.T) {
+	nodeDb := openTestNodeDb(t, 20,
+		testTransfer(10, 0, "initminer", "alice1"),
+		testTransfer(15, 0, "initminer", "alice1"),
+	)
+	// the node hasn't written the records above block 15
+	setTestBlockProcess(t, nodeDb, 15)
+	idx := newTestIndexer(t, nodeDb, 100)
+	syncTestIndexer(t, idx, true, 15)
+
+	if err := nodeDb.Model(&plugins.BlockLogProcess{}).Where("1 = 1").Update("block_height", 40).Error; err != nil {
+		t.Fatalf("update block process: %v", err)
+	}
+	syncTestIndexer(t, idx, true, 20)
+	var progress types.IndexerProgress
+	if err := idx.store.Take(&progress).Error; err != nil {
+		t.Fatalf("get progress: %v", err)
+	}
+	if progress.SyncHeight != 20 || progress.UpstreamLib != 20 || progress.UpstreamHeight != 40 {
+		t.Fatalf("progress is %+v", progress)
+	}
+}
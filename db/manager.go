@@ -0,0 +1,222 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/jinzhu/gorm"
+	"sync"
+	"time"
+	"transfer_history/config"
+	"transfer_history/logs"
+	"transfer_history/types"
+)
+
+// a db connection shared by concurrent queries.
+// it's closed after it's retired and all the queries running on it finish
+type dbConn struct {
+	db *gorm.DB
+	host string
+	lock sync.Mutex
+	// count of running queries
+	refs int
+	retired bool
+}
+
+func newDbConn(db *gorm.DB, host string) *dbConn {
+	return &dbConn{db: db, host: host}
+}
+
+// hold the connection for a query, return false if it's retired
+func (conn *dbConn) acquire() bool {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	if conn.retired {
+		return false
+	}
+	conn.refs++
+	return true
+}
+
+// release the connection held by acquire, close it if it's the last query on the retired connection
+func (conn *dbConn) release() {
+	conn.lock.Lock()
+	conn.refs--
+	closing := conn.retired && conn.refs == 0
+	conn.lock.Unlock()
+	if closing {
+		conn.close()
+	}
+}
+
+// stop handing out the connection, and close it once the running queries finish
+func (conn *dbConn) retire() {
+	conn.lock.Lock()
+	if conn.retired {
+		conn.lock.Unlock()
+		return
+	}
+	conn.retired = true
+	closing := conn.refs == 0
+	conn.lock.Unlock()
+	if closing {
+		conn.close()
+	}
+}
+
+func (conn *dbConn) close() {
+	logger := logs.GetLogger()
+	if err := conn.db.Close(); err != nil {
+		logger.Errorf("dbConn: fail to close db of %v, the error is %v", conn.host, err)
+	}
+}
+
+// Manager owns the connections of cos observe node dbs and the local store,
+// and the goroutines which check the nodes and sync the local store.
+// all its methods are safe for concurrent use
+type Manager struct {
+	lock sync.RWMutex
+	pool *nodePool
+	// the local store synced by the local indexer, nil if the indexer is disabled
+	store *dbConn
+	indexer *transferIndexer
+	// the best block height and lib of the pool, updated by the checker every head poll
+	nodeWatcher *blockWatcher
+	// the block height and lib of the local store, updated by the local indexer
+	storeWatcher *blockWatcher
+	// stop the checker and wait for it to return
+	stop chan struct{}
+	done chan struct{}
+}
+
+// get a manager which is not started, it probes the cos observe node dbs every block check interval after it starts
+func NewManager() *Manager {
+	return &Manager{nodeWatcher: newBlockWatcher(), storeWatcher: newBlockWatcher()}
+}
+
+// open the configured cos observe node dbs and start checking them regularly,
+// also start the local indexer if it's enabled
+func (m *Manager) Start() error {
+	logger := logs.GetLogger()
+	list,err := config.GetCosFullNodeDbConfigList()
+	if err != nil {
+		logger.Errorf("Manager: fail to get cos observe node db config, the error is %v", err)
+		return errors.New("open db: fail to get observe node db config")
+	}
+	pool := newNodePool(list, config.GetFullNodeLoadBalance(), config.GetFullNodeMaxBlockLag())
+	if err := pool.open(); err != nil {
+		logger.Errorf("Manager: fail to get cos observe node db,the error is %v", err)
+		return err
+	}
+	m.lock.Lock()
+	if m.pool != nil {
+		m.lock.Unlock()
+		pool.close()
+		return errors.New("open db: db manager is already started")
+	}
+	m.pool = pool
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	go m.checkNodes(pool, config.GetBlockCheckInterval(), config.GetHeadPollInterval(), m.stop, m.done)
+	m.lock.Unlock()
+	if config.IsLocalIndexerEnabled() {
+		if err := m.startLocalIndexer(); err != nil {
+			m.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+// probe every node of pool at once and then every interval until stop is closed,
+// read the block heights of the healthy nodes every pollInterval between the probes,
+// and wake up the waiters of new blocks
+func (m *Manager) checkNodes(pool *nodePool, interval, pollInterval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	poller := time.NewTicker(pollInterval)
+	defer poller.Stop()
+	defer close(done)
+	checkBlockStatus(pool)
+	for {
+		m.nodeWatcher.update(pool.best())
+		select {
+		case <-ticker.C:
+			checkBlockStatus(pool)
+		case <-poller.C:
+			pool.pollHeads()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// stop the checker and the local indexer, then close all the connections once their running queries finish
+func (m *Manager) Close() {
+	m.lock.Lock()
+	pool, store, idx, stop, done := m.pool, m.store, m.indexer, m.stop, m.done
+	m.pool, m.store, m.indexer, m.stop, m.done = nil, nil, nil, nil, nil
+	if idx != nil {
+		// stop the running sync before it finds the pool is gone
+		idx.cancel()
+	}
+	m.lock.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+	if idx != nil {
+		idx.close()
+	}
+	if pool != nil {
+		pool.close()
+	}
+	if store != nil {
+		store.retire()
+	}
+}
+
+// run fn on a node of the pool with the db bound to ctx, fn returns the error of the node which makes it fail over to another node
+func (m *Manager) queryFullNode(ctx context.Context, fn func(cosDb *gorm.DB) error) error {
+	m.lock.RLock()
+	pool := m.pool
+	m.lock.RUnlock()
+	if pool == nil {
+		return errNoAvailableNode
+	}
+	return pool.query(ctx, fn)
+}
+
+// run fn on the local store with the db bound to ctx
+func (m *Manager) queryLocalStore(ctx context.Context, fn func(cosDb *gorm.DB) error) error {
+	m.lock.RLock()
+	store := m.store
+	held := store != nil && store.acquire()
+	m.lock.RUnlock()
+	if !held {
+		return errNoLocalStore
+	}
+	defer store.release()
+	return queryWithContext(ctx, store.db, fn)
+}
+
+// get the health and block lag of all the cos observe node dbs, nil if the manager is not started
+func (m *Manager) NodeStatus() []*types.FullNodeStatus {
+	m.lock.RLock()
+	pool := m.pool
+	m.lock.RUnlock()
+	if pool == nil {
+		return nil
+	}
+	return pool.status()
+}
+
+// get the repository backed by the cos observe node dbs of the manager.
+// all the queries of a method run on one node of the pool, and fail over to another node as a whole if the node fails
+func (m *Manager) FullNodeRepository() TransferRepository {
+	return &sqlRepository{manager: m, watcher: m.nodeWatcher, query: m.queryFullNode}
+}
+
+// get the repository backed by the local store of the manager
+func (m *Manager) LocalStoreRepository() TransferRepository {
+	return &sqlRepository{manager: m, watcher: m.storeWatcher, query: m.queryLocalStore}
+}
@@ -0,0 +1,285 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	"transfer_history/config"
+)
+
+// the observe node db and local store of the config, they're created by the test starting the db service
+var testNodeDbPath, testStoreDbPath string
+
+// the config is loaded once in a process, so it has one sqlite observe node db and a sqlite local store in a temp dir
+func TestMain(m *testing.M) {
+	dir,err := ioutil.TempDir("", "db")
+	if err != nil {
+		panic(err)
+	}
+	testNodeDbPath, testStoreDbPath = filepath.Join(dir, "node.db"), filepath.Join(dir, "store.db")
+	cfg := fmt.Sprintf(`{"dev": {
+		"fullNodeDbList": [{"fullNodeDbDriver": "sqlite3", "fullNodeDbName": %q, "fullNodeDbHost": "node"}],
+		"localIndexer": {"enable": true, "db": {"fullNodeDbDriver": "sqlite3", "fullNodeDbName": %q, "fullNodeDbHost": "store"}, "interval": 1}
+	}}`, testNodeDbPath, testStoreDbPath)
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(cfg), 0600); err != nil {
+		panic(err)
+	}
+	config.SetConfigEnv(config.EnvDev)
+	if err := config.LoadExchangeTransferHistoryConfig(path); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// create a sqlite observe node db in a temp dir with the lib and block process, get its config
+func createTestNodeDb(t *testing.T, lib, blkNum uint64) *config.DbConfig {
+	t.Helper()
+	cfg := &config.DbConfig{Driver: config.DbDriverSqlite, DbName: filepath.Join(t.TempDir(), "node.db"), Host: "node"}
+	setTestBlockProcess(t, openTestNodeDbAt(t, cfg.DbName, lib), blkNum)
+	return cfg
+}
+
+// get a manager of the observe node dbs in list, which is not checking them
+func newTestManager(t *testing.T, policy string, list ...*config.DbConfig) *Manager {
+	t.Helper()
+	m := NewManager()
+	pool := newNodePool(list, policy, 1000)
+	if err := pool.open(); err != nil {
+		t.Fatalf("open pool: %v", err)
+	}
+	m.pool = pool
+	t.Cleanup(m.Close)
+	return m
+}
+
+// whether the db of conn is closed
+func isConnClosed(conn *dbConn) bool {
+	return conn.db.DB().Ping() != nil
+}
+
+func TestConnClosedAfterRelease(t *testing.T) {
+	cfg := createTestNodeDb(t, 10, 12)
+	testDb,err := openDb(cfg)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	conn := newDbConn(testDb, cfg.Host)
+	if !conn.acquire() || !conn.acquire() {
+		t.Fatal("fail to acquire conn")
+	}
+	conn.retire()
+	if conn.acquire() {
+		t.Fatal("retired conn is acquired")
+	}
+	conn.release()
+	if isConnClosed(conn) {
+		t.Fatal("conn is closed while a query is running on it")
+	}
+	conn.release()
+	if !isConnClosed(conn) {
+		t.Fatal("retired conn is not closed after the queries finish")
+	}
+}
+
+func TestReplaceConnWhileQueryInFlight(t *testing.T) {
+	cfg := createTestNodeDb(t, 10, 12)
+	m := newTestManager(t, config.LoadBalanceRoundRobin, cfg)
+	node := m.pool.nodes[0]
+	old := node.conn
+	started, resume := make(chan struct{}), make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		result <- m.pool.query(context.Background(), func(cosDb *gorm.DB) error {
+			close(started)
+			<-resume
+			_,err := getLib(cosDb)
+			return err
+		})
+	}()
+	<-started
+	// what the probe does to a node which fails it
+	replaced,err := openDb(cfg)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	m.pool.replaceConn(node, old, newDbConn(replaced, cfg.Host))
+	if isConnClosed(old) {
+		t.Fatal("old conn is closed while a query is running on it")
+	}
+	lib,err := m.FullNodeRepository().GetLib(context.Background())
+	if err != nil || lib != 10 {
+		t.Fatalf("lib on the new conn is %v, the error is %v", lib, err)
+	}
+	close(resume)
+	if err := <-result; err != nil {
+		t.Fatalf("query on the old conn: %v", err)
+	}
+	if !isConnClosed(old) {
+		t.Fatal("old conn is not closed after the query finishes")
+	}
+	if isConnClosed(node.conn) {
+		t.Fatal("new conn is closed")
+	}
+}
+
+func TestRetireWhileQueriesRun(t *testing.T) {
+	cfg := createTestNodeDb(t, 10, 12)
+	m := newTestManager(t, config.LoadBalanceRoundRobin, cfg)
+	node := m.pool.nodes[0]
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				m.pool.query(context.Background(), func(cosDb *gorm.DB) error {
+					_,err := getLib(cosDb)
+					return err
+				})
+			}
+		}()
+	}
+	// retire the conn of the node again and again while the queries run on it
+	for i := 0; i < 20; i++ {
+		m.pool.lock.RLock()
+		old := node.conn
+		m.pool.lock.RUnlock()
+		replaced,err := openDb(cfg)
+		if err != nil {
+			t.Fatalf("open db: %v", err)
+		}
+		m.pool.replaceConn(node, old, newDbConn(replaced, cfg.Host))
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	wg.Wait()
+	lib,err := m.FullNodeRepository().GetLib(context.Background())
+	if err != nil || lib != 10 {
+		t.Fatalf("lib after retiring conns is %v, the error is %v", lib, err)
+	}
+}
+
+func TestCloseDbServiceStopsGoroutines(t *testing.T) {
+	os.Remove(testNodeDbPath)
+	os.Remove(testStoreDbPath)
+	setTestBlockProcess(t, openTestNodeDbAt(t, testNodeDbPath, 10, testTransfer(5, 0, "initminer", "alice1")), 12)
+	if err := StartDbService(); err != nil {
+		t.Fatalf("start db service: %v", err)
+	}
+	defaultManager.lock.RLock()
+	pool, store, done, idxDone := defaultManager.pool, defaultManager.store, defaultManager.done, defaultManager.indexer.done
+	defaultManager.lock.RUnlock()
+	pool.lock.RLock()
+	conn := pool.nodes[0].conn
+	pool.lock.RUnlock()
+	// the local indexer syncs the store up to the lib of the node
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if lib,err := NewLocalStoreRepository().GetLib(context.Background()); err == nil && lib == 10 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("local store is not synced")
+		}
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for _,repo := range []TransferRepository{NewFullNodeRepository(), NewLocalStoreRepository()} {
+		wg.Add(1)
+		go func(repo TransferRepository) {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				repo.GetLib(context.Background())
+			}
+		}(repo)
+	}
+	time.Sleep(10 * time.Millisecond)
+	CloseDbService()
+	for name,ch := range map[string]chan struct{}{"node checker": done, "local indexer": idxDone} {
+		select {
+		case <-ch:
+		default:
+			t.Errorf("%v is still running after the db service is closed", name)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	if pool.nodes[0].conn != nil || !isConnClosed(conn) {
+		t.Error("conn of the node is not closed")
+	}
+	if !isConnClosed(store) {
+		t.Error("local store is not closed")
+	}
+	if _,err := NewFullNodeRepository().GetLib(context.Background()); err == nil {
+		t.Error("query succeeds after the db service is closed")
+	}
+}
+
+// set the block process and lib of the sqlite observe node db of cfg
+func setTestNodeBlock(t *testing.T, cfg *config.DbConfig, lib, blkNum uint64) {
+	t.Helper()
+	nodeDb,err := openDb(cfg)
+	if err != nil {
+		t.Fatalf("open db: %v", err)
+	}
+	defer nodeDb.Close()
+	if err := nodeDb.Exec("UPDATE blocklog_process SET block_height = ?", blkNum).Error; err != nil {
+		t.Fatalf("set block process: %v", err)
+	}
+	if err := nodeDb.Exec("UPDATE libinfo SET lib = ?", lib).Error; err != nil {
+		t.Fatalf("set lib: %v", err)
+	}
+}
+
+func TestHeadPollWakesWaiters(t *testing.T) {
+	cfg := createTestNodeDb(t, 10, 12)
+	m := newTestManager(t, config.LoadBalanceRoundRobin, cfg)
+	stop, done := make(chan struct{}), make(chan struct{})
+	// the probe never runs again in the test, only the head poll finds the new blocks
+	go m.checkNodes(m.pool, time.Hour, 10 * time.Millisecond, stop, done)
+	defer func() {
+		close(stop)
+		<-done
+	}()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		m.nodeWatcher.lock.Lock()
+		height, lib := m.nodeWatcher.height, m.nodeWatcher.lib
+		m.nodeWatcher.lock.Unlock()
+		if height == 12 && lib == 10 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("nodes are not probed")
+		}
+	}
+	changed := m.nodeWatcher.wait()
+	setTestNodeBlock(t, cfg, 11, 13)
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiters are not woken up by new blocks")
+	}
+	if height,lib := m.pool.best(); height != 13 || lib != 11 {
+		t.Fatalf("best block is %v and lib is %v after head poll, want 13 and 11", height, lib)
+	}
+}
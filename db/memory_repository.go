@@ -0,0 +1,447 @@
+package db
+
+import (
+	"context"
+	"github.com/coschain/contentos-go/app/plugins"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"transfer_history/types"
+	"transfer_history/utils"
+)
+
+// MemoryRepository is a transfer repository keeping all the transfer records in memory,
+// it has the same query semantics as the repository of observe node db and is used to run the http api without mysql
+type MemoryRepository struct {
+	lock    sync.RWMutex
+	lib     uint64
+	// ordered by (BlockHeight, OperationId)
+	records []*plugins.TransferRecord
+	watcher *blockWatcher
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{watcher: newBlockWatcher()}
+}
+
+// set the latest irreversible block height
+func (repo *MemoryRepository) SetLib(lib uint64) {
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+	repo.lib = lib
+	repo.watcher.update(repo.maxBlockHeight(), repo.lib)
+}
+
+// add transfer records, the record with an existing operation id replaces the old one
+func (repo *MemoryRepository) AddTransferRecord(recs ...*plugins.TransferRecord) {
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+	for _,rec := range recs {
+		repo.removeRecord(rec.OperationId)
+		repo.records = append(repo.records, rec)
+	}
+	sort.Slice(repo.records, func(i, j int) bool {
+		return compareRecordPosition(repo.records[i], repo.records[j].BlockHeight, repo.records[j].OperationId) < 0
+	})
+	repo.watcher.update(repo.maxBlockHeight(), repo.lib)
+}
+
+// remove the transfer record of operation id, return false if it doesn't exist
+func (repo *MemoryRepository) RemoveTransferRecord(opId string) bool {
+	repo.lock.Lock()
+	defer repo.lock.Unlock()
+	removed := repo.removeRecord(opId)
+	repo.watcher.update(repo.maxBlockHeight(), repo.lib)
+	return removed
+}
+
+// get a channel which is closed when the max block height or lib changes next time
+func (repo *MemoryRepository) BlockChanged() <-chan struct{} {
+	return repo.watcher.wait()
+}
+
+func (repo *MemoryRepository) removeRecord(opId string) bool {
+	for i,rec := range repo.records {
+		if rec.OperationId == opId {
+			repo.records = append(repo.records[:i], repo.records[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (repo *MemoryRepository) GetLib(ctx context.Context) (uint64,error) {
+	if err := ctx.Err(); err != nil {
+		return 0,err
+	}
+	repo.lock.RLock()
+	defer repo.lock.RUnlock()
+	return repo.lib,nil
+}
+
+func (repo *MemoryRepository) GetMaxBlockHeight(ctx context.Context) (uint64,error) {
+	if err := ctx.Err(); err != nil {
+		return 0,err
+	}
+	repo.lock.RLock()
+	defer repo.lock.RUnlock()
+	return repo.maxBlockHeight(),nil
+}
+
+func (repo *MemoryRepository) GetBlockStatus(ctx context.Context) (uint64,uint64,error) {
+	if err := ctx.Err(); err != nil {
+		return 0,0,err
+	}
+	repo.lock.RLock()
+	defer repo.lock.RUnlock()
+	return repo.lib,repo.maxBlockHeight(),nil
+}
+
+func (repo *MemoryRepository) maxBlockHeight() uint64 {
+	if len(repo.records) == 0 {
+		return 0
+	}
+	return repo.records[len(repo.records)-1].BlockHeight
+}
+
+func (repo *MemoryRepository) maxBlockHeightBefore(t time.Time) (uint64,bool,error) {
+	for i := len(repo.records) - 1; i >= 0; i-- {
+		if repo.records[i].BlockTime.Before(t) {
+			return repo.records[i].BlockHeight,true,nil
+		}
+	}
+	return 0,false,nil
+}
+
+func (repo *MemoryRepository) GetTransferRecord(ctx context.Context, query *types.TransferRecordQuery) *types.QueryTransferRecordModel {
+	model := &types.QueryTransferRecordModel{
+		List: make([]*types.TransferRecord, 0),
+	}
+	if model.Err,model.ErrCode = contextErrorOf(ctx, ctx.Err(), 0); model.Err != nil {
+		return model
+	}
+	if err := checkAccountName(query.Account); err != nil {
+		model.Err = err
+		model.ErrCode = types.StatusParamInvalidError
+		return model
+	}
+	if cp := query.Filter.Counterparty; utils.CheckIsNotEmptyStr(cp) {
+		if err := checkAccountName(cp); err != nil {
+			model.Err = err
+			model.ErrCode = types.StatusParamInvalidError
+			return model
+		}
+	}
+	repo.lock.RLock()
+	defer repo.lock.RUnlock()
+	lib := repo.lib
+	model.Lib = lib
+	model.NextCursor = query.Cursor
+	maxBlkNum := repo.maxBlockHeight()
+	model.MaxQueryBlkNum = maxBlkNum
+	eBlkNum := queryEndBlock(lib, maxBlkNum, query.IncludeReversible)
+	sBlkNum,eBlkNum,ok,_ := narrowBlockRangeByTime(repo.maxBlockHeightBefore, query.Start, eBlkNum, query.StartTime, query.EndTime)
+	if !ok || eBlkNum < sBlkNum {
+		return model
+	}
+	var tList []*plugins.TransferRecord
+	for _,rec := range repo.records {
+		if rec.BlockHeight < sBlkNum || rec.BlockHeight > eBlkNum ||
+			!matchAccount(rec, query.Account, query.Direction) ||
+			!matchFilter(rec, query.Direction, &query.Filter) {
+			continue
+		}
+		if query.Cursor != nil && compareRecordPosition(rec, query.Cursor.BlockHeight, query.Cursor.OperationId) <= 0 {
+			continue
+		}
+		if query.Limit > 0 && len(tList) == query.Limit {
+			model.HasMore = true
+			break
+		}
+		tList = append(tList, rec)
+	}
+	for _,rec := range tList {
+		model.List = append(model.List, convertTransferRecord(rec, query.Account, lib, maxBlkNum))
+	}
+	if listLen := len(tList); listLen > 0 {
+		last := tList[listLen-1]
+		model.NextCursor = &types.TransferCursor{BlockHeight: last.BlockHeight, OperationId: last.OperationId}
+	}
+	return model
+}
+
+func (repo *MemoryRepository) GetUserTransferRecordByBlock(ctx context.Context, blkNum uint64, acct string, dir int, includeReversible bool) *types.QueryTransferRecordModel {
+	model := &types.QueryTransferRecordModel{
+		List: make([]*types.TransferRecord, 0),
+	}
+	if model.Err,model.ErrCode = contextErrorOf(ctx, ctx.Err(), 0); model.Err != nil {
+		return model
+	}
+	if err := checkAccountName(acct); err != nil {
+		model.Err = err
+		model.ErrCode = types.StatusParamInvalidError
+		return model
+	}
+	repo.lock.RLock()
+	defer repo.lock.RUnlock()
+	model.Lib = repo.lib
+	model.MaxQueryBlkNum = repo.maxBlockHeight()
+	if blkNum > queryEndBlock(model.Lib, model.MaxQueryBlkNum, includeReversible) {
+		return model
+	}
+	for _,rec := range repo.records {
+		if rec.BlockHeight == blkNum && matchAccount(rec, acct, dir) {
+			model.List = append(model.List, convertTransferRecord(rec, acct, model.Lib, model.MaxQueryBlkNum))
+		}
+	}
+	return model
+}
+
+func (repo *MemoryRepository) GetBatchTransferRecord(ctx context.Context, query *types.BatchTransferRecordQuery) *types.QueryBatchTransferRecordModel {
+	model := &types.QueryBatchTransferRecordModel{
+		Records: make(map[string][]*types.TransferRecord),
+		NextCursor: query.Cursor,
+	}
+	if model.Err,model.ErrCode = contextErrorOf(ctx, ctx.Err(), 0); model.Err != nil {
+		return model
+	}
+	for _,acct := range query.Accounts {
+		if err := checkAccountName(acct.Account); err != nil {
+			model.Err = err
+			model.ErrCode = types.StatusParamInvalidError
+			return model
+		}
+		model.Records[acct.Account] = make([]*types.TransferRecord, 0)
+	}
+	repo.lock.RLock()
+	defer repo.lock.RUnlock()
+	maxBlkNum := repo.maxBlockHeight()
+	model.Lib = repo.lib
+	model.MaxQueryBlkNum = maxBlkNum
+	eBlkNum := queryEndBlock(model.Lib, maxBlkNum, query.IncludeReversible)
+	count := 0
+	for _,rec := range repo.records {
+		if rec.BlockHeight > eBlkNum {
+			break
+		}
+		if query.Cursor != nil && compareRecordPosition(rec, query.Cursor.BlockHeight, query.Cursor.OperationId) <= 0 {
+			continue
+		}
+		matched := false
+		for _,acct := range query.Accounts {
+			if rec.BlockHeight >= acct.Start && matchAccount(rec, acct.Account, query.Direction) {
+				matched = true
+			}
+		}
+		if !matched {
+			continue
+		}
+		if query.Limit > 0 && count >= query.Limit {
+			model.HasMore = true
+			break
+		}
+		count++
+		for _,acct := range query.Accounts {
+			if rec.BlockHeight >= acct.Start && matchAccount(rec, acct.Account, query.Direction) {
+				model.Records[acct.Account] = append(model.Records[acct.Account], convertTransferRecord(rec, acct.Account, model.Lib, maxBlkNum))
+			}
+		}
+		model.NextCursor = &types.TransferCursor{BlockHeight: rec.BlockHeight, OperationId: rec.OperationId}
+	}
+	return model
+}
+
+func (repo *MemoryRepository) GetTransferSummary(ctx context.Context, query *types.TransferSummaryQuery) *types.QueryTransferSummaryModel {
+	model := &types.QueryTransferSummaryModel{}
+	if model.Err,model.ErrCode = contextErrorOf(ctx, ctx.Err(), 0); model.Err != nil {
+		return model
+	}
+	acct := query.Account
+	if err := checkAccountName(acct); err != nil {
+		model.Err = err
+		model.ErrCode = types.StatusParamInvalidError
+		return model
+	}
+	repo.lock.RLock()
+	defer repo.lock.RUnlock()
+	model.Lib = repo.lib
+	maxBlkNum := repo.maxBlockHeight()
+	model.MaxQueryBlkNum = maxBlkNum
+	eBlkNum := queryEndBlock(repo.lib, maxBlkNum, false)
+	if query.End > 0 && query.End < eBlkNum {
+		eBlkNum = query.End
+	}
+	inAmount, outAmount := new(big.Int), new(big.Int)
+	summary := &types.TransferSummary{Account: acct}
+	sBlkNum,eBlkNum,ok,_ := narrowBlockRangeByTime(repo.maxBlockHeightBefore, query.Start, eBlkNum, query.StartTime, query.EndTime)
+	if ok {
+		model.StartBlkNum = sBlkNum
+		model.EndBlkNum = eBlkNum
+		for _,rec := range repo.records {
+			if rec.BlockHeight < sBlkNum || rec.BlockHeight > eBlkNum {
+				continue
+			}
+			amount := new(big.Int).SetUint64(rec.Amount)
+			if rec.To == acct {
+				inAmount.Add(inAmount, amount)
+				summary.InCount++
+			}
+			if rec.From == acct {
+				outAmount.Add(outAmount, amount)
+				summary.OutCount++
+			}
+		}
+	}
+	netAmount := new(big.Int).Sub(inAmount, outAmount)
+	summary.InAmount = inAmount.String()
+	summary.OutAmount = outAmount.String()
+	summary.NetAmount = netAmount.String()
+	summary.InAmountCos = utils.FormatCosAmount(inAmount)
+	summary.OutAmountCos = utils.FormatCosAmount(outAmount)
+	summary.NetAmountCos = utils.FormatCosAmount(netAmount)
+	model.Summary = summary
+	return model
+}
+
+func (repo *MemoryRepository) GetTransferStatistics(ctx context.Context, query *types.TransferStatisticsQuery) *types.QueryTransferStatisticsModel {
+	model := &types.QueryTransferStatisticsModel{
+		Buckets: make([]*types.TransferStatisticsBucket, 0),
+	}
+	if model.Err,model.ErrCode = contextErrorOf(ctx, ctx.Err(), 0); model.Err != nil {
+		return model
+	}
+	if utils.CheckIsNotEmptyStr(query.Account) {
+		if err := checkAccountName(query.Account); err != nil {
+			model.Err = err
+			model.ErrCode = types.StatusParamInvalidError
+			return model
+		}
+	}
+	if err := checkStatisticsQuery(query); err != nil {
+		model.Err = err
+		model.ErrCode = types.StatusParamInvalidError
+		return model
+	}
+	repo.lock.RLock()
+	defer repo.lock.RUnlock()
+	model.Lib = repo.lib
+	model.MaxQueryBlkNum = repo.maxBlockHeight()
+	eBlkNum := queryEndBlock(repo.lib, model.MaxQueryBlkNum, false)
+	counts := make(map[int64]uint64)
+	amounts := make(map[int64]*big.Int)
+	for _,rec := range repo.records {
+		if rec.BlockHeight > eBlkNum || rec.BlockTime.Before(query.StartTime) || !rec.BlockTime.Before(query.EndTime) {
+			continue
+		}
+		if utils.CheckIsNotEmptyStr(query.Account) && !matchAccount(rec, query.Account, query.Direction) {
+			continue
+		}
+		key := rec.BlockTime.Truncate(query.Interval).Unix()
+		counts[key]++
+		if amounts[key] == nil {
+			amounts[key] = new(big.Int)
+		}
+		amounts[key].Add(amounts[key], new(big.Int).SetUint64(rec.Amount))
+	}
+	for t := query.StartTime.Truncate(query.Interval); t.Before(query.EndTime); t = t.Add(query.Interval) {
+		amount := amounts[t.Unix()]
+		if amount == nil {
+			amount = new(big.Int)
+		}
+		model.Buckets = append(model.Buckets, &types.TransferStatisticsBucket{
+			StartTime: t.UTC().Format(time.RFC3339),
+			Count: counts[t.Unix()],
+			Amount: amount.String(),
+			AmountCos: utils.FormatCosAmount(amount),
+		})
+	}
+	return model
+}
+
+func (repo *MemoryRepository) GetTransferRecordByOperation(ctx context.Context, id string, isTrxHash bool) *types.QueryTransferRecordModel {
+	model := &types.QueryTransferRecordModel{
+		List: make([]*types.TransferRecord, 0),
+	}
+	if model.Err,model.ErrCode = contextErrorOf(ctx, ctx.Err(), 0); model.Err != nil {
+		return model
+	}
+	var validErr error
+	if isTrxHash {
+		validErr = checkTrxHash(id)
+	} else {
+		validErr = checkOperationId(id)
+	}
+	if validErr != nil {
+		model.Err = validErr
+		model.ErrCode = types.StatusParamInvalidError
+		return model
+	}
+	repo.lock.RLock()
+	defer repo.lock.RUnlock()
+	model.Lib = repo.lib
+	model.MaxQueryBlkNum = repo.maxBlockHeight()
+	for _,rec := range repo.records {
+		if rec.OperationId == id || (isTrxHash && strings.HasPrefix(rec.OperationId, id + "_")) {
+			model.List = append(model.List, convertTransferRecord(rec, "", model.Lib, model.MaxQueryBlkNum))
+		}
+	}
+	return model
+}
+
+// compare the position of record with (blkNum, opId) in the order of (block_height, operation_id)
+func compareRecordPosition(rec *plugins.TransferRecord, blkNum uint64, opId string) int {
+	if rec.BlockHeight != blkNum {
+		if rec.BlockHeight < blkNum {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(rec.OperationId, opId)
+}
+
+// the same as accountFilter of sql
+func matchAccount(rec *plugins.TransferRecord, acct string, dir int) bool {
+	switch dir {
+	case types.TxDirectionSend:
+		return rec.From == acct
+	case types.TxDirectionReceive:
+		return rec.To == acct
+	default:
+		return rec.From == acct || rec.To == acct
+	}
+}
+
+// the same as applyTransferFilter of sql
+func matchFilter(rec *plugins.TransferRecord, dir int, filter *types.TransferRecordFilter) bool {
+	if cp := filter.Counterparty; utils.CheckIsNotEmptyStr(cp) {
+		switch dir {
+		case types.TxDirectionSend:
+			if rec.To != cp {
+				return false
+			}
+		case types.TxDirectionReceive:
+			if rec.From != cp {
+				return false
+			}
+		default:
+			if rec.From != cp && rec.To != cp {
+				return false
+			}
+		}
+	}
+	if filter.MinAmount != nil && rec.Amount < *filter.MinAmount {
+		return false
+	}
+	if filter.MaxAmount != nil && rec.Amount > *filter.MaxAmount {
+		return false
+	}
+	if utils.CheckIsNotEmptyStr(filter.Memo) && rec.Memo != filter.Memo {
+		return false
+	}
+	if utils.CheckIsNotEmptyStr(filter.MemoPrefix) && !strings.HasPrefix(rec.Memo, filter.MemoPrefix) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,409 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/coschain/contentos-go/app/plugins"
+	"github.com/jinzhu/gorm"
+	"sort"
+	"sync"
+	"time"
+	"transfer_history/config"
+	"transfer_history/logs"
+	"transfer_history/types"
+)
+
+const (
+	// a node is stalled if its block height doesn't change in this duration
+	nodeStallTimeout = 2 * time.Minute
+	// a node is removed from the pool after this count of consecutive errors
+	maxNodeConsecutiveErrors = 3
+	// weight of the latest sample in the moving average of error rate and latency
+	nodeHealthSampleWeight = 0.2
+)
+
+var errNoAvailableNode = errors.New("no available cos observe node db")
+
+// a cos observe node db and its health
+type fullNode struct {
+	cfg *config.DbConfig
+	// nil if the node is not opened
+	conn *dbConn
+	healthy bool
+	consecutiveErrors int
+	// moving average of query error rate and latency
+	errorRate float64
+	latency time.Duration
+	blockHeight uint64
+	lib uint64
+	// behind the freshest node more than max block lag
+	lagging bool
+	lastHeightChange time.Time
+	lastErr error
+}
+
+// whether the node can serve queries
+func (node *fullNode) usable() bool {
+	return node.healthy && !node.lagging
+}
+
+// pool of all the configured cos observe node dbs.
+// queries are spread across the healthy nodes by the load balance policy and fail over to the next healthy node on error,
+// unhealthy nodes are re-admitted after a probe succeeds
+type nodePool struct {
+	lock sync.RWMutex
+	nodes []*fullNode
+	policy string
+	maxBlockLag uint64
+	// the node to start with, it's the last working node in failover policy and the next node in round robin policy
+	current int
+	closed bool
+}
+
+func newNodePool(list []*config.DbConfig, policy string, maxBlockLag uint64) *nodePool {
+	pool := &nodePool{policy: policy, maxBlockLag: maxBlockLag}
+	for _,cf := range list {
+		pool.nodes = append(pool.nodes, &fullNode{cfg: cf})
+	}
+	return pool
+}
+
+// open all the nodes, return error if none of them can be opened
+func (pool *nodePool) open() error {
+	logger := logs.GetLogger()
+	if len(pool.nodes) == 0 {
+		return errors.New("open db: empty observe node db config")
+	}
+	var dbErr error
+	opened := false
+	for i,node := range pool.nodes {
+		db,err := openDb(node.cfg)
+		pool.lock.Lock()
+		if err != nil {
+			logger.Errorf("nodePool: fail to open db of %v, the error is %v", node.cfg.Host, err)
+			node.lastErr = err
+			dbErr = err
+		} else {
+			node.conn = newDbConn(db, node.cfg.Host)
+			node.healthy = true
+			node.lastHeightChange = time.Now()
+			if !opened {
+				pool.current = i
+			}
+			opened = true
+		}
+		pool.lock.Unlock()
+	}
+	if !opened {
+		return dbErr
+	}
+	return nil
+}
+
+// pick the node to serve a query by the load balance policy, skip the tried nodes.
+// if there is no healthy node which keeps up with the freshest node, try the opened nodes anyway.
+// the connection of the node is held until it's released
+func (pool *nodePool) pick(tried map[*fullNode]bool) (*fullNode,*dbConn) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	count := len(pool.nodes)
+	if count == 0 {
+		return nil,nil
+	}
+	order := make([]*fullNode, 0, count)
+	for i := 0; i < count; i++ {
+		order = append(order, pool.nodes[(pool.current + i) % count])
+	}
+	switch pool.policy {
+	case config.LoadBalanceRoundRobin:
+		pool.current = (pool.current + 1) % count
+	case config.LoadBalanceLeastLatency:
+		sort.SliceStable(order, func(i, j int) bool {
+			return order[i].latency < order[j].latency
+		})
+	}
+	for _,needUsable := range []bool{true, false} {
+		for _,node := range order {
+			if node.conn == nil || tried[node] || (needUsable && !node.usable()) {
+				continue
+			}
+			if node.conn.acquire() {
+				return node,node.conn
+			}
+		}
+	}
+	return nil,nil
+}
+
+// run fn on a node in a transaction bound to ctx, fail over to the next node immediately if fn returns error.
+// stop when ctx is done, which is not counted as an error of the node
+func (pool *nodePool) query(ctx context.Context, fn func(db *gorm.DB) error) error {
+	logger := logs.GetLogger()
+	tried := make(map[*fullNode]bool)
+	lastErr := errNoAvailableNode
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		node,conn := pool.pick(tried)
+		if node == nil {
+			return lastErr
+		}
+		tried[node] = true
+		start := time.Now()
+		err := queryWithContext(ctx, conn.db, fn)
+		conn.release()
+		if err != nil && ctx.Err() != nil {
+			logger.Errorf("nodePool: query on %v is stopped, the error is %v", node.cfg.Host, ctx.Err())
+			return ctx.Err()
+		}
+		pool.recordResult(node, err, time.Since(start))
+		if err == nil {
+			return nil
+		}
+		logger.Errorf("nodePool: query on %v failed, try next node, the error is %v", node.cfg.Host, err)
+		lastErr = err
+	}
+}
+
+func (pool *nodePool) recordResult(node *fullNode, err error, latency time.Duration) {
+	logger := logs.GetLogger()
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	node.latency = time.Duration((1 - nodeHealthSampleWeight) * float64(node.latency) + nodeHealthSampleWeight * float64(latency))
+	sample := 0.0
+	if err != nil {
+		sample = 1
+		node.lastErr = err
+		node.consecutiveErrors++
+		if node.healthy && node.consecutiveErrors >= maxNodeConsecutiveErrors {
+			logger.Errorf("nodePool: remove %v from pool after %v consecutive errors", node.cfg.Host, node.consecutiveErrors)
+			node.healthy = false
+		}
+	} else {
+		node.consecutiveErrors = 0
+		if pool.policy == config.LoadBalanceFailover {
+			// stick to the node which works
+			for i,n := range pool.nodes {
+				if n == node && n.usable() {
+					pool.current = i
+				}
+			}
+		}
+	}
+	node.errorRate = (1 - nodeHealthSampleWeight) * node.errorRate + nodeHealthSampleWeight * sample
+}
+
+// probe every node by its block process and lib, re-admit the recovered nodes,
+// remove the stalled ones and the ones behind the freshest node too much.
+// the connection of a node which fails the probe is replaced by a new one,
+// the old connection is closed after the queries running on it finish
+func (pool *nodePool) probe() {
+	logger := logs.GetLogger()
+	for _,node := range pool.nodes {
+		pool.lock.RLock()
+		conn := node.conn
+		held := conn != nil && conn.acquire()
+		pool.lock.RUnlock()
+		var (
+			process plugins.BlockLogProcess
+			lib uint64
+			err error
+		)
+		start := time.Now()
+		if held {
+			process,lib,err = probeDb(conn.db)
+			conn.release()
+		}
+		if !held || err != nil {
+			if held {
+				logger.Errorf("nodePool: fail to probe %v, reconnect it, the error is %v", node.cfg.Host, err)
+			}
+			start = time.Now()
+			var db *gorm.DB
+			if db,err = openDb(node.cfg); err == nil {
+				pool.replaceConn(node, conn, newDbConn(db, node.cfg.Host))
+				process,lib,err = probeDb(db)
+			}
+		}
+		latency := time.Since(start)
+
+		pool.lock.Lock()
+		now := time.Now()
+		if err != nil {
+			logger.Errorf("nodePool: fail to probe %v, the error is %v", node.cfg.Host, err)
+			node.lastErr = err
+			node.healthy = false
+		} else {
+			node.latency = time.Duration((1 - nodeHealthSampleWeight) * float64(node.latency) + nodeHealthSampleWeight * float64(latency))
+			node.lib = lib
+			if process.BlockHeight != node.blockHeight {
+				node.blockHeight = process.BlockHeight
+				node.lastHeightChange = now
+			}
+			if now.Sub(node.lastHeightChange) >= nodeStallTimeout {
+				logger.Infof("nodePool: block height of %v stops at %v, remove it from pool", node.cfg.Host, node.blockHeight)
+				node.healthy = false
+			} else if !node.healthy {
+				logger.Infof("nodePool: %v recovers, re-admit it to pool", node.cfg.Host)
+				node.healthy = true
+				node.consecutiveErrors = 0
+				node.errorRate = 0
+			}
+		}
+		pool.lock.Unlock()
+	}
+	pool.updateLag()
+}
+
+// read the block process and lib of the healthy nodes, it's much cheaper than probe.
+// a node failing the read is left to the next probe
+func (pool *nodePool) pollHeads() {
+	logger := logs.GetLogger()
+	for _,node := range pool.nodes {
+		pool.lock.RLock()
+		conn := node.conn
+		held := node.healthy && conn != nil && conn.acquire()
+		pool.lock.RUnlock()
+		if !held {
+			continue
+		}
+		process,lib,err := probeDb(conn.db)
+		conn.release()
+		if err != nil {
+			logger.Errorf("nodePool: fail to read block height of %v, the error is %v", node.cfg.Host, err)
+			continue
+		}
+		pool.lock.Lock()
+		node.lib = lib
+		if process.BlockHeight != node.blockHeight {
+			node.blockHeight = process.BlockHeight
+			node.lastHeightChange = time.Now()
+		}
+		pool.lock.Unlock()
+	}
+	pool.updateLag()
+}
+
+// get the block process and lib of a node
+func probeDb(db *gorm.DB) (plugins.BlockLogProcess,uint64,error) {
+	var process plugins.BlockLogProcess
+	if err := db.Take(&process).Error; err != nil {
+		return process,0,err
+	}
+	lib,err := getLib(db)
+	return process,lib,err
+}
+
+// replace the connection old of node by conn, and retire old.
+// conn is closed if the pool is closed or old is already replaced
+func (pool *nodePool) replaceConn(node *fullNode, old *dbConn, conn *dbConn) {
+	pool.lock.Lock()
+	if pool.closed || node.conn != old {
+		pool.lock.Unlock()
+		conn.retire()
+		return
+	}
+	node.conn = conn
+	pool.lock.Unlock()
+	if old != nil {
+		old.retire()
+	}
+}
+
+// get the freshest block height and lib of the healthy nodes
+func (pool *nodePool) best() (uint64,uint64) {
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+	return pool.bestHeight()
+}
+
+// get the freshest block height and lib of the healthy nodes, the caller holds the lock
+func (pool *nodePool) bestHeight() (uint64,uint64) {
+	var bestHeight, bestLib uint64
+	for _,node := range pool.nodes {
+		if !node.healthy {
+			continue
+		}
+		if node.blockHeight > bestHeight {
+			bestHeight = node.blockHeight
+		}
+		if node.lib > bestLib {
+			bestLib = node.lib
+		}
+	}
+	return bestHeight,bestLib
+}
+
+// get how many blocks height is behind best
+func blockLag(best, height uint64) uint64 {
+	if height >= best {
+		return 0
+	}
+	return best - height
+}
+
+// mark the nodes whose block height or lib is behind the freshest node more than max block lag
+func (pool *nodePool) updateLag() {
+	logger := logs.GetLogger()
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	bestHeight, bestLib := pool.bestHeight()
+	for _,node := range pool.nodes {
+		heightLag, libLag := blockLag(bestHeight, node.blockHeight), blockLag(bestLib, node.lib)
+		lagging := heightLag > pool.maxBlockLag || libLag > pool.maxBlockLag
+		if lagging != node.lagging {
+			if lagging {
+				logger.Infof("nodePool: %v is %v blocks and %v libs behind the freshest node, stop using it", node.cfg.Host, heightLag, libLag)
+			} else {
+				logger.Infof("nodePool: %v catches up with the freshest node, use it again", node.cfg.Host)
+			}
+		}
+		node.lagging = lagging
+	}
+}
+
+// get the health of all the nodes
+func (pool *nodePool) status() []*types.FullNodeStatus {
+	pool.lock.RLock()
+	defer pool.lock.RUnlock()
+	bestHeight, bestLib := pool.bestHeight()
+	var list []*types.FullNodeStatus
+	for _,node := range pool.nodes {
+		st := &types.FullNodeStatus{
+			Host: node.cfg.Host,
+			Healthy: node.healthy,
+			Lagging: node.lagging,
+			ErrorRate: node.errorRate,
+			LatencyMs: node.latency.Milliseconds(),
+			BlockHeight: node.blockHeight,
+			Lib: node.lib,
+			BlockLag: blockLag(bestHeight, node.blockHeight),
+			LibLag: blockLag(bestLib, node.lib),
+		}
+		if node.lastErr != nil {
+			st.LastError = node.lastErr.Error()
+		}
+		list = append(list, st)
+	}
+	return list
+}
+
+// close the connections of all the nodes once the queries running on them finish
+func (pool *nodePool) close() {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	pool.closed = true
+	for _,node := range pool.nodes {
+		if node.conn != nil {
+			node.conn.retire()
+			node.conn = nil
+		}
+		node.healthy = false
+	}
+}
+
+// whether the error code of query result means the node fails, which should fail over to another node
+func isNodeErrorCode(code int) bool {
+	return code == types.StatusIntervalError || code == types.StatusGetLibError || code == types.StatusGetTransferRecordError
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"context"
+	"github.com/coschain/contentos-go/app/plugins"
+	"github.com/jinzhu/gorm"
+	"testing"
+	"time"
+	"transfer_history/config"
+	"transfer_history/types"
+)
+
+// a query fails over to the next node when the current node fails, and sticks to the node which works
+func TestNodePoolFailover(t *testing.T) {
+	broken := openTestNodeDb(t, 10)
+	good := openTestNodeDb(t, 20, testTransfer(20, 0, "initminer", "alice1"))
+	broken.Close()
+	pool := useTestNodePool(t, config.LoadBalanceFailover, broken, good)
+	repo := NewFullNodeRepository()
+
+	for i := 0; i < maxNodeConsecutiveErrors; i++ {
+		if lib,err := repo.GetLib(context.Background()); err != nil || lib != 20 {
+			t.Fatalf("lib is %v, the error is %v", lib, err)
+		}
+		// make the broken node current again
+		pool.current = 0
+	}
+	st := pool.status()
+	if st[0].Healthy || st[0].LastError == "" || !st[1].Healthy {
+		t.Fatalf("status of nodes are %+v %+v", st[0], st[1])
+	}
+	model := repo.GetTransferRecord(context.Background(), &types.TransferRecordQuery{Account: "alice1", Direction: types.TxDirectionAll})
+	if model.Err != nil || len(model.List) != 1 || pool.current != 1 {
+		t.Fatalf("got %v records on node %v, the error is %v", len(model.List), pool.current, model.Err)
+	}
+
+	// a param error doesn't fail over
+	model = repo.GetTransferRecord(context.Background(), &types.TransferRecordQuery{Account: "alice", Direction: types.TxDirectionAll})
+	if model.ErrCode != types.StatusParamInvalidError || st[1].ErrorRate != 0 {
+		t.Fatalf("invalid account: error code is %v", model.ErrCode)
+	}
+
+	// no node works
+	good.Close()
+	if _,err := repo.GetLib(context.Background()); err == nil {
+		t.Fatal("get lib without any working node")
+	}
+}
+
+func TestNodePoolLoadBalance(t *testing.T) {
+	dbs := []*gorm.DB{openTestNodeDb(t, 10), openTestNodeDb(t, 20), openTestNodeDb(t, 30)}
+	repo := NewFullNodeRepository()
+
+	// round robin spreads the queries across the nodes in turn
+	useTestNodePool(t, config.LoadBalanceRoundRobin, dbs...)
+	var libs []uint64
+	for i := 0; i < 4; i++ {
+		lib,err := repo.GetLib(context.Background())
+		if err != nil {
+			t.Fatalf("get lib: %v", err)
+		}
+		libs = append(libs, lib)
+	}
+	if libs[0] != 10 || libs[1] != 20 || libs[2] != 30 || libs[3] != 10 {
+		t.Fatalf("libs of round robin are %v", libs)
+	}
+
+	// least latency prefers the fastest healthy node
+	pool := useTestNodePool(t, config.LoadBalanceLeastLatency, dbs...)
+	pool.nodes[0].latency = 3 * time.Second
+	pool.nodes[1].latency = time.Second
+	pool.nodes[2].latency = 2 * time.Second
+	if lib,err := repo.GetLib(context.Background()); err != nil || lib != 20 {
+		t.Fatalf("lib of least latency is %v, the error is %v", lib, err)
+	}
+	pool.nodes[1].healthy = false
+	if lib,err := repo.GetLib(context.Background()); err != nil || lib != 30 {
+		t.Fatalf("lib without the fastest node is %v, the error is %v", lib, err)
+	}
+}
+
+// set the block process of a test node db
+func setTestBlockProcess(t *testing.T, nodeDb *gorm.DB, blkNum uint64) {
+	t.Helper()
+	if err := nodeDb.AutoMigrate(&plugins.BlockLogProcess{}).Error; err != nil {
+		t.Fatalf("create block process table: %v", err)
+	}
+	if err := nodeDb.Create(&plugins.BlockLogProcess{BlockHeight: blkNum, FinishAt: time.Now()}).Error; err != nil {
+		t.Fatalf("set block process: %v", err)
+	}
+}
+
+// the node whose block height or lib is behind the freshest node too much isn't used until it catches up
+func TestNodePoolExcludesLaggingNode(t *testing.T) {
+	fresh, slow, stale := openTestNodeDb(t, 990), openTestNodeDb(t, 980), openTestNodeDb(t, 700)
+	setTestBlockProcess(t, fresh, 1000)
+	setTestBlockProcess(t, slow, 950)
+	setTestBlockProcess(t, stale, 1000)
+	pool := useTestNodePool(t, config.LoadBalanceRoundRobin, stale, slow, fresh)
+	pool.maxBlockLag = 100
+	pool.probe()
+
+	st := pool.status()
+	if st[0].LibLag != 290 || !st[0].Lagging || st[1].BlockLag != 50 || st[1].Lagging || st[2].Lagging {
+		t.Fatalf("status of nodes are %+v %+v %+v", st[0], st[1], st[2])
+	}
+	repo := NewFullNodeRepository()
+	for i := 0; i < 4; i++ {
+		if lib,err := repo.GetLib(context.Background()); err != nil || lib == 700 {
+			t.Fatalf("lib is %v, the error is %v", lib, err)
+		}
+	}
+
+	// the stale node catches up
+	if err := stale.Table(types.LibTableName).Update("lib", 1000).Error; err != nil {
+		t.Fatalf("update lib: %v", err)
+	}
+	pool.probe()
+	if st := pool.status(); st[0].Lagging || st[0].LibLag != 0 || st[2].LibLag != 10 {
+		t.Fatalf("status of nodes are %+v %+v %+v", st[0], st[1], st[2])
+	}
+}
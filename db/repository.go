@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/coschain/contentos-go/app/plugins"
+	"strconv"
+	"time"
+	"transfer_history/types"
+	"transfer_history/utils"
+)
+
+// TransferRepository is the storage of transfer records used by the http handlers
+type TransferRepository interface {
+	// get the latest irreversible block height
+	GetLib(ctx context.Context) (uint64,error)
+	// get the max block height of transfer records, 0 if there is no record
+	GetMaxBlockHeight(ctx context.Context) (uint64,error)
+	// get the lib and the max block height of transfer records from the same db
+	GetBlockStatus(ctx context.Context) (uint64,uint64,error)
+	GetTransferRecord(ctx context.Context, query *types.TransferRecordQuery) *types.QueryTransferRecordModel
+	// the records above lib are only returned if includeReversible is true
+	GetUserTransferRecordByBlock(ctx context.Context, blkNum uint64, acct string, dir int, includeReversible bool) *types.QueryTransferRecordModel
+	// the records of all the accounts are paged together in (block height, operation id) order
+	GetBatchTransferRecord(ctx context.Context, query *types.BatchTransferRecordQuery) *types.QueryBatchTransferRecordModel
+	GetTransferSummary(ctx context.Context, query *types.TransferSummaryQuery) *types.QueryTransferSummaryModel
+	GetTransferStatistics(ctx context.Context, query *types.TransferStatisticsQuery) *types.QueryTransferStatisticsModel
+	GetTransferRecordByOperation(ctx context.Context, id string, isTrxHash bool) *types.QueryTransferRecordModel
+}
+
+// NodeStatusProvider is implemented by the repository backed by cos observe node dbs
+type NodeStatusProvider interface {
+	GetNodeStatus() []*types.FullNodeStatus
+}
+
+// CacheStatusProvider is implemented by the repository with query cache
+type CacheStatusProvider interface {
+	GetCacheStatus() *types.QueryCacheStatus
+}
+
+// NodePinner is implemented by the repository which spreads the queries across several dbs
+type NodePinner interface {
+	// run fn with a repository whose queries all run on one db bound to ctx,
+	// fn is run again on another db if it returns error
+	PinNode(ctx context.Context, fn func(repo TransferRepository) error) error
+}
+
+// run fn with a repository whose queries all run on one db, it's repo itself if repo can't pin a db
+func WithPinnedNode(ctx context.Context, repo TransferRepository, fn func(repo TransferRepository) error) error {
+	if pinner,ok := repo.(NodePinner); ok {
+		return pinner.PinNode(ctx, fn)
+	}
+	return fn(repo)
+}
+
+// BlockNotifier is implemented by the repository which watches the new blocks
+type BlockNotifier interface {
+	// get a channel which is closed when the block height or lib changes next time
+	BlockChanged() <-chan struct{}
+}
+
+// replace the error of a query stopped by ctx with the timeout error, keep err and code if ctx is not done
+func contextErrorOf(ctx context.Context, err error, code int) (error,int) {
+	if err == nil {
+		return nil,code
+	}
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return errors.New("query timeout"),types.StatusQueryTimeoutError
+	case context.Canceled:
+		return errors.New("query is canceled"),types.StatusQueryTimeoutError
+	}
+	return err,code
+}
+
+// check the account name before it is used in any query
+func checkAccountName(acct string) error {
+	if !utils.CheckIsValidAccountName(acct) {
+		return errors.New(fmt.Sprintf("invalid account name %q", acct))
+	}
+	return nil
+}
+
+// check the trx hash before it is used in any query
+func checkTrxHash(hash string) error {
+	if !utils.CheckIsValidTrxHash(hash) {
+		return errors.New(fmt.Sprintf("invalid trx hash %q", hash))
+	}
+	return nil
+}
+
+// check the operation id before it is used in any query
+func checkOperationId(id string) error {
+	if !utils.CheckIsValidOperationId(id) {
+		return errors.New(fmt.Sprintf("invalid operation id %q", id))
+	}
+	return nil
+}
+
+// narrow the block height range [sBlkNum, eBlkNum] to the blocks produced in [startTime, endTime),
+// maxBlockHeightBefore gets the max block height whose block time is before a time.
+// return false if there is no block in the range
+func narrowBlockRangeByTime(maxBlockHeightBefore func(time.Time) (uint64,bool,error), sBlkNum, eBlkNum uint64, startTime, endTime *time.Time) (uint64,uint64,bool,error) {
+	if startTime != nil {
+		blkNum,found,err := maxBlockHeightBefore(*startTime)
+		if err != nil {
+			return 0,0,false,err
+		}
+		if found && blkNum + 1 > sBlkNum {
+			sBlkNum = blkNum + 1
+		}
+	}
+	if endTime != nil {
+		blkNum,found,err := maxBlockHeightBefore(*endTime)
+		if err != nil {
+			return 0,0,false,err
+		}
+		if !found {
+			// no block before end time
+			return 0,0,false,nil
+		}
+		if blkNum < eBlkNum {
+			eBlkNum = blkNum
+		}
+	}
+	return sBlkNum,eBlkNum,sBlkNum <= eBlkNum,nil
+}
+
+// check the interval and time range of statistics query
+func checkStatisticsQuery(query *types.TransferStatisticsQuery) error {
+	if query.Interval < time.Hour || query.Interval % time.Hour != 0 || !query.StartTime.Before(query.EndTime) {
+		return errors.New(fmt.Sprintf("invalid statistics interval %v or time range [%v, %v)", query.Interval, query.StartTime, query.EndTime))
+	}
+	return nil
+}
+
+// get the max block height of the records to return, it's lib unless the reversible records are asked for
+func queryEndBlock(lib, maxBlkNum uint64, includeReversible bool) uint64 {
+	if !includeReversible && lib < maxBlkNum {
+		return lib
+	}
+	return maxBlkNum
+}
+
+// convert the record of observe node db, and tag its direction relative to account(no direction if account is empty).
+// a record above lib is marked reversible with the count of indexed blocks from its block
+func convertTransferRecord(rec *plugins.TransferRecord, acct string, lib, maxBlkNum uint64) *types.TransferRecord {
+	dir := 0
+	if utils.CheckIsNotEmptyStr(acct) {
+		dir = types.TxDirectionAll
+		if rec.From == acct && rec.To != acct {
+			dir = types.TxDirectionSend
+		} else if rec.To == acct && rec.From != acct {
+			dir = types.TxDirectionReceive
+		}
+	}
+	var confirmations uint64
+	irreversible := rec.BlockHeight <= lib
+	if !irreversible && maxBlkNum >= rec.BlockHeight {
+		confirmations = maxBlkNum - rec.BlockHeight + 1
+	}
+	return &types.TransferRecord{
+		OperationId: rec.OperationId,
+		From: rec.From,
+		To: rec.To,
+		Memo: rec.Memo,
+		Amount: strconv.FormatUint(rec.Amount, 10),
+		BlockHeight: strconv.FormatUint(rec.BlockHeight, 10),
+		BlockTime: rec.BlockTime.UTC().Format(time.RFC3339),
+		Direction: dir,
+		Irreversible: irreversible,
+		Confirmations: confirmations,
+	}
+}
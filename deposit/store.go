@@ -0,0 +1,122 @@
+package deposit
+
+import (
+	"encoding/json"
+	"github.com/jinzhu/gorm"
+	"transfer_history/config"
+	"transfer_history/db"
+	"transfer_history/logs"
+	"transfer_history/types"
+)
+
+// max count of transfers deleted in one statement
+const maxDeleteBatch = 500
+
+// Store keeps the cursor of every deposit account and the states of its transfers after the cursor,
+// so the tracker continues from them after a restart instead of emitting the transitions of the old transfers again
+type Store struct {
+	db *gorm.DB
+}
+
+// open the deposit db in config and create its tables
+func OpenStore() (*Store,error) {
+	return openStore(config.GetDepositDbConfig())
+}
+
+func openStore(cfg *config.DbConfig) (*Store,error) {
+	logger := logs.GetLogger()
+	store,err := db.OpenDb(cfg)
+	if err != nil {
+		logger.Errorf("OpenStore: fail to open deposit db, the error is %v", err)
+		return nil,err
+	}
+	if err := migrateStore(store); err != nil {
+		logger.Errorf("OpenStore: fail to create tables of deposit db, the error is %v", err)
+		store.Close()
+		return nil,err
+	}
+	return &Store{db: store},nil
+}
+
+func migrateStore(store *gorm.DB) error {
+	if err := store.AutoMigrate(&types.DepositCursor{}, &types.DepositState{}).Error; err != nil {
+		return err
+	}
+	if err := store.Model(&types.DepositCursor{}).AddUniqueIndex("idx_deposit_cursor_account", "account").Error; err != nil {
+		return err
+	}
+	return store.Model(&types.DepositState{}).AddUniqueIndex("idx_deposit_state_account_operation", "account", "operation_id").Error
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// get the cursor of account and the states of its transfers after the cursor, the cursor is nil if account is never scanned
+func (s *Store) load(account string) (*types.TransferCursor,map[string]*trackedDeposit,error) {
+	var cursor *types.TransferCursor
+	c := &types.DepositCursor{}
+	err := s.db.Where("account = ?", account).First(c).Error
+	if err == nil {
+		cursor = &types.TransferCursor{BlockHeight: c.BlockHeight, OperationId: c.OperationId}
+	} else if !gorm.IsRecordNotFoundError(err) {
+		return nil,nil,err
+	}
+	var list []*types.DepositState
+	if err := s.db.Where("account = ?", account).Find(&list).Error; err != nil {
+		return nil,nil,err
+	}
+	deposits := make(map[string]*trackedDeposit, len(list))
+	for _,st := range list {
+		rec := &types.TransferRecord{}
+		if err := json.Unmarshal([]byte(st.Record), rec); err != nil {
+			return nil,nil,err
+		}
+		deposits[st.OperationId] = &trackedDeposit{record: rec, state: st.State, saved: true}
+	}
+	return cursor,deposits,nil
+}
+
+// delete the removed transfers of account, save the changed ones and move its cursor in one transaction
+func (s *Store) save(account string, cursor *types.TransferCursor, changed map[string]*trackedDeposit, removed []string) error {
+	tx := s.db.Begin()
+	if err := tx.Error; err != nil {
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+	// delete before saving, so a transfer removed and found again is kept.
+	// sqlite limits the count of variables in a statement
+	for len(removed) > 0 {
+		n := len(removed)
+		if n > maxDeleteBatch {
+			n = maxDeleteBatch
+		}
+		if err := tx.Where("account = ? AND operation_id IN (?)", account, removed[:n]).Delete(&types.DepositState{}).Error; err != nil {
+			return err
+		}
+		removed = removed[n:]
+	}
+	for id,dep := range changed {
+		js,err := json.Marshal(dep.record)
+		if err != nil {
+			return err
+		}
+		st := &types.DepositState{}
+		err = tx.Where(types.DepositState{Account: account, OperationId: id}).
+			Assign(types.DepositState{State: dep.state, Record: string(js)}).
+			FirstOrCreate(st).Error
+		if err != nil {
+			return err
+		}
+	}
+	if cursor != nil {
+		c := &types.DepositCursor{}
+		err := tx.Where(types.DepositCursor{Account: account}).
+			Assign(types.DepositCursor{BlockHeight: cursor.BlockHeight, OperationId: cursor.OperationId}).
+			FirstOrCreate(c).Error
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
@@ -0,0 +1,335 @@
+package deposit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"transfer_history/config"
+	"transfer_history/db"
+	"transfer_history/logs"
+	"transfer_history/types"
+	"transfer_history/utils"
+)
+
+// max count of records in one query of a scan
+const scanLimit = 1000
+
+// an incoming transfer which is not irreversible when the tracker finds it, or is behind such a transfer
+type trackedDeposit struct {
+	record *types.TransferRecord
+	state string
+	// whether it's in store
+	saved bool
+}
+
+// a deposit account and its incoming transfers after the last irreversible one
+type trackedAccount struct {
+	name string
+	// position of the last transfer whose predecessors are all irreversible, the transfers after it are scanned in every round
+	cursor *types.TransferCursor
+	// the transfers after cursor keyed by operation id
+	deposits map[string]*trackedDeposit
+	// operation ids of the transfers changed or removed since they were saved in store
+	changed map[string]bool
+	removed map[string]bool
+}
+
+// Tracker follows every incoming transfer of the deposit accounts from its first appearance until it's irreversible,
+// and keeps the state transitions: seen, confirmed, irreversible, or vanished if it's removed before it becomes irreversible
+type Tracker struct {
+	repo db.TransferRepository
+	store *Store
+	accounts []*trackedAccount
+	start uint64
+	confirmations uint64
+	maxEvents int
+	epoch int64
+
+	lock sync.Mutex
+	// the latest events, the sequence number of events[i] is firstSeq + i
+	events []*types.DepositEvent
+	firstSeq uint64
+
+	ctx context.Context
+	cancel context.CancelFunc
+	done chan struct{}
+}
+
+// get a tracker of the incoming transfers at or above start of accounts, which are confirmed after count of confirmations.
+// start is required, and every account continues from its cursor and the states of its transfers in store
+func NewTracker(repo db.TransferRepository, store *Store, accounts []string, start, confirmations uint64, maxEvents int) (*Tracker,error) {
+	if start == 0 {
+		return nil,errors.New("the start block of deposit accounts is not configured")
+	}
+	t := &Tracker{
+		repo: repo,
+		store: store,
+		start: start,
+		confirmations: confirmations,
+		maxEvents: maxEvents,
+		firstSeq: 1,
+	}
+	seen := make(map[string]bool)
+	for _,acct := range accounts {
+		if !utils.CheckIsValidAccountName(acct) {
+			return nil,errors.New(fmt.Sprintf("deposit account name %q is invalid", acct))
+		}
+		if seen[acct] {
+			return nil,errors.New(fmt.Sprintf("duplicate deposit account %v", acct))
+		}
+		seen[acct] = true
+		cursor,deposits,err := store.load(acct)
+		if err != nil {
+			return nil,err
+		}
+		t.accounts = append(t.accounts, &trackedAccount{
+			name: acct,
+			cursor: cursor,
+			deposits: deposits,
+			changed: make(map[string]bool),
+			removed: make(map[string]bool),
+		})
+	}
+	return t,nil
+}
+
+// get a tracker of the deposit accounts in config, which keeps their transfers in store
+func NewTrackerFromConfig(repo db.TransferRepository, store *Store) (*Tracker,error) {
+	return NewTracker(repo, store, config.GetDepositAccounts(), config.GetDepositStart(), config.GetDepositConfirmations(), config.GetDepositMaxEvents())
+}
+
+// start tracking in background
+func (t *Tracker) Start() {
+	t.epoch = time.Now().Unix()
+	t.ctx,t.cancel = context.WithCancel(context.Background())
+	t.done = make(chan struct{})
+	go t.run()
+}
+
+// stop tracking and wait for the running round to return
+func (t *Tracker) Stop() {
+	t.cancel()
+	<-t.done
+}
+
+// get the start time of the tracker in unix seconds
+func (t *Tracker) Epoch() int64 {
+	return t.epoch
+}
+
+// get at most limit events of account after sequence number after, account is empty for all the accounts.
+// return whether there are more events, and whether some events after the sequence number are dropped
+func (t *Tracker) Events(after uint64, account string, limit int) ([]*types.DepositEvent,bool,bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	missed := after + 1 < t.firstSeq
+	i := 0
+	if after >= t.firstSeq {
+		i = int(after - t.firstSeq + 1)
+	}
+	list := make([]*types.DepositEvent, 0)
+	for ; i < len(t.events); i++ {
+		ev := t.events[i]
+		if utils.CheckIsNotEmptyStr(account) && ev.Account != account {
+			continue
+		}
+		if len(list) >= limit {
+			return list,true,missed
+		}
+		list = append(list, ev)
+	}
+	return list,false,missed
+}
+
+// scan the deposit accounts whenever new blocks are found
+func (t *Tracker) run() {
+	logger := logs.GetLogger()
+	defer close(t.done)
+	for {
+		// get the notification before scanning, so the blocks indexed while scanning are not missed
+		changed := db.WaitBlockChange(t.repo)
+		for _,acct := range t.accounts {
+			if err := t.scan(acct); err != nil && t.ctx.Err() == nil {
+				logger.Errorf("Tracker: fail to scan deposits of %v, the error is %v", acct.name, err)
+			}
+		}
+		select {
+		case <-changed:
+		case <-t.ctx.Done():
+			return
+		}
+	}
+}
+
+// scan all the incoming transfers of acct after its cursor page by page, emit the state changes and the vanished transfers
+func (t *Tracker) scan(acct *trackedAccount) error {
+	ctx,cancel := context.WithTimeout(t.ctx, config.GetQueryTimeout("deposit"))
+	defer cancel()
+	// all the pages are read from one db, so a transfer isn't taken as vanished because another db hasn't indexed it yet
+	err := db.WithPinnedNode(ctx, t.repo, func(repo db.TransferRepository) error {
+		found := make(map[string]bool)
+		cursor := acct.cursor
+		// the cursor of acct stops moving at the first reversible transfer
+		contiguous := true
+		for {
+			query := &types.TransferRecordQuery{
+				Start: t.start,
+				Account: acct.name,
+				Direction: types.TxDirectionReceive,
+				Limit: scanLimit,
+				Cursor: cursor,
+				IncludeReversible: true,
+			}
+			model := repo.GetTransferRecord(ctx, query)
+			if model.Err != nil {
+				return model.Err
+			}
+			for _,rec := range model.List {
+				found[rec.OperationId] = true
+				t.update(acct, rec)
+				if !rec.Irreversible {
+					contiguous = false
+				} else if contiguous {
+					acct.cursor = positionOf(rec)
+				}
+			}
+			if !model.HasMore {
+				t.removeVanished(acct, found, model.MaxQueryBlkNum)
+			}
+			t.removeBehindCursor(acct)
+			if !model.HasMore {
+				return nil
+			}
+			cursor = model.NextCursor
+		}
+	})
+	// the pages processed before an error are saved too, and the changes failing to save are saved in the next round
+	if saveErr := t.save(acct); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return err
+}
+
+// emit the transfers of acct which are not found in a scan as vanished, maxBlkNum is the processed block height of the db.
+// a db which hasn't processed the block of the transfer can't tell whether it vanishes
+func (t *Tracker) removeVanished(acct *trackedAccount, found map[string]bool, maxBlkNum uint64) {
+	for id,dep := range acct.deposits {
+		if found[id] || dep.state == types.DepositIrreversible || positionOf(dep.record).BlockHeight > maxBlkNum {
+			continue
+		}
+		t.emit(acct.name, dep.record, dep.state, types.DepositVanished)
+		acct.remove(id)
+	}
+}
+
+// forget the irreversible transfers of acct at or behind its cursor, they are never scanned again
+func (t *Tracker) removeBehindCursor(acct *trackedAccount) {
+	if acct.cursor == nil {
+		return
+	}
+	for id,dep := range acct.deposits {
+		if dep.state == types.DepositIrreversible && comparePosition(positionOf(dep.record), acct.cursor) <= 0 {
+			acct.remove(id)
+		}
+	}
+}
+
+// save the cursor and the changed transfers of acct in store
+func (t *Tracker) save(acct *trackedAccount) error {
+	changed := make(map[string]*trackedDeposit, len(acct.changed))
+	for id := range acct.changed {
+		changed[id] = acct.deposits[id]
+	}
+	removed := make([]string, 0, len(acct.removed))
+	for id := range acct.removed {
+		removed = append(removed, id)
+	}
+	if err := t.store.save(acct.name, acct.cursor, changed, removed); err != nil {
+		return err
+	}
+	for _,dep := range changed {
+		dep.saved = true
+	}
+	acct.changed = make(map[string]bool)
+	acct.removed = make(map[string]bool)
+	return nil
+}
+
+// record the latest rec of acct, emit an event if its state changes
+func (t *Tracker) update(acct *trackedAccount, rec *types.TransferRecord) {
+	state := types.DepositSeen
+	if rec.Irreversible {
+		state = types.DepositIrreversible
+	} else if rec.Confirmations >= t.confirmations {
+		state = types.DepositConfirmed
+	}
+	dep := acct.deposits[rec.OperationId]
+	if dep == nil {
+		dep = &trackedDeposit{}
+		acct.deposits[rec.OperationId] = dep
+	}
+	if dep.state == types.DepositIrreversible {
+		// a db whose lib is behind may show it above lib again
+		return
+	}
+	if dep.record == nil || dep.record.BlockHeight != rec.BlockHeight || dep.state != state {
+		// the block decides whether the transfer vanishes after a restart
+		acct.changed[rec.OperationId] = true
+	}
+	dep.record = rec
+	if dep.state != state {
+		// the state goes back if the transfer is moved to another block
+		t.emit(acct.name, rec, dep.state, state)
+		dep.state = state
+	}
+}
+
+func (t *Tracker) emit(account string, rec *types.TransferRecord, from, to string) {
+	logger := logs.GetLogger()
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	ev := &types.DepositEvent{
+		Seq: t.firstSeq + uint64(len(t.events)),
+		Account: account,
+		State: to,
+		PreviousState: from,
+		Record: rec,
+		Time: time.Now().UTC(),
+	}
+	t.events = append(t.events, ev)
+	// drop the oldest events in batch, so they are not copied for every new event
+	if len(t.events) > t.maxEvents + t.maxEvents / 10 {
+		drop := len(t.events) - t.maxEvents
+		t.events = append([]*types.DepositEvent(nil), t.events[drop:]...)
+		t.firstSeq += uint64(drop)
+	}
+	logger.Infof("Tracker: deposit %v of %v in block %v is %v, it was %q", rec.OperationId, account, rec.BlockHeight, to, from)
+}
+
+// get the (block height, operation id) position of record
+func positionOf(rec *types.TransferRecord) *types.TransferCursor {
+	blkNum,_ := strconv.ParseUint(rec.BlockHeight, 10, 64)
+	return &types.TransferCursor{BlockHeight: blkNum, OperationId: rec.OperationId}
+}
+
+func (acct *trackedAccount) remove(id string) {
+	if acct.deposits[id].saved {
+		acct.removed[id] = true
+	}
+	delete(acct.deposits, id)
+	delete(acct.changed, id)
+}
+
+func comparePosition(a, b *types.TransferCursor) int {
+	if a.BlockHeight != b.BlockHeight {
+		if a.BlockHeight < b.BlockHeight {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a.OperationId, b.OperationId)
+}
@@ -0,0 +1,182 @@
+package deposit
+
+import (
+	"context"
+	"fmt"
+	"github.com/coschain/contentos-go/app/plugins"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"transfer_history/config"
+	"transfer_history/db"
+	"transfer_history/types"
+)
+
+const testConfirmations = 10
+
+// open a sqlite deposit db at path, it's closed when the test finishes
+func openTestStore(t *testing.T, path string) *Store {
+	t.Helper()
+	store,err := openStore(&config.DbConfig{Driver: config.DbDriverSqlite, DbName: path})
+	if err != nil {
+		t.Fatalf("open deposit db: %v", err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+	})
+	return store
+}
+
+// get a tracker of wallet1 from block 1 on repo, which is scanned by the test instead of running in background
+func newTestTracker(t *testing.T, repo db.TransferRepository, store *Store) *Tracker {
+	t.Helper()
+	tracker,err := NewTracker(repo, store, []string{"wallet1"}, 1, testConfirmations, scanLimit * 10)
+	if err != nil {
+		t.Fatalf("new tracker: %v", err)
+	}
+	tracker.ctx = context.Background()
+	return tracker
+}
+
+func testDeposit(blkNum uint64, n int) *plugins.TransferRecord {
+	return &plugins.TransferRecord{
+		BlockHeight: blkNum,
+		OperationId: fmt.Sprintf("op%v_%v", blkNum, n),
+		From: "initminer",
+		To: "wallet1",
+		Amount: 1,
+		BlockTime: time.Unix(int64(blkNum) * 3, 0),
+	}
+}
+
+// a transfer of another account, it moves the max block height
+func testBlock(blkNum uint64) *plugins.TransferRecord {
+	return &plugins.TransferRecord{BlockHeight: blkNum, OperationId: fmt.Sprintf("op%v_0", blkNum), From: "initminer", To: "alice1", Amount: 1, BlockTime: time.Unix(int64(blkNum) * 3, 0)}
+}
+
+// scan wallet1 and check the events after seq after, each one is "operation id:previous state>state"
+func expectTestEvents(t *testing.T, tracker *Tracker, after uint64, want ...string) uint64 {
+	t.Helper()
+	if err := tracker.scan(tracker.accounts[0]); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	list,_,_ := tracker.Events(after, "", 1000)
+	got := make([]string, 0, len(list))
+	for _,ev := range list {
+		got = append(got, fmt.Sprintf("%v:%v>%v", ev.Record.OperationId, ev.PreviousState, ev.State))
+		after = ev.Seq
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("events are %v, want %v", got, want)
+	}
+	return after
+}
+
+func TestNewTrackerRequiresStart(t *testing.T) {
+	store := openTestStore(t, filepath.Join(t.TempDir(), "deposit.db"))
+	if _,err := NewTracker(db.NewMemoryRepository(), store, []string{"wallet1"}, 0, 10, 100); err == nil {
+		t.Fatal("tracker without start is created")
+	}
+}
+
+func TestTrackerNeverDowngradesIrreversible(t *testing.T) {
+	tracker := newTestTracker(t, db.NewMemoryRepository(), openTestStore(t, filepath.Join(t.TempDir(), "deposit.db")))
+	acct := tracker.accounts[0]
+	rec := &types.TransferRecord{BlockHeight: "50", OperationId: "op50_0", From: "initminer", To: "wallet1", Irreversible: true}
+	tracker.update(acct, rec)
+	// then a db whose lib is behind answers the next scan
+	behind := *rec
+	behind.Irreversible = false
+	tracker.update(acct, &behind)
+	list,_,_ := tracker.Events(0, "", 100)
+	if len(list) != 1 || list[0].State != types.DepositIrreversible {
+		t.Fatalf("got events %+v, want one irreversible event", list)
+	}
+}
+
+func TestScanTransitions(t *testing.T) {
+	repo := db.NewMemoryRepository()
+	repo.AddTransferRecord(testDeposit(10, 0), testDeposit(30, 0), testDeposit(31, 0))
+	repo.SetLib(20)
+	tracker := newTestTracker(t, repo, openTestStore(t, filepath.Join(t.TempDir(), "deposit.db")))
+
+	// the transfer below lib is irreversible when it's found
+	seq := expectTestEvents(t, tracker, 0, "op10_0:>irreversible", "op30_0:>seen", "op31_0:>seen")
+	seq = expectTestEvents(t, tracker, seq)
+	// op30_0 has 10 confirmations at block 39, op31_0 has 9
+	repo.AddTransferRecord(testBlock(39))
+	seq = expectTestEvents(t, tracker, seq, "op30_0:seen>confirmed")
+	repo.AddTransferRecord(testBlock(40))
+	seq = expectTestEvents(t, tracker, seq, "op31_0:seen>confirmed")
+	repo.SetLib(30)
+	seq = expectTestEvents(t, tracker, seq, "op30_0:confirmed>irreversible")
+	if cursor := tracker.accounts[0].cursor; cursor == nil || cursor.OperationId != "op30_0" {
+		t.Fatalf("cursor is %+v, want op30_0", cursor)
+	}
+
+	// a reversible transfer removed by a fork vanishes
+	if !repo.RemoveTransferRecord("op31_0") {
+		t.Fatal("op31_0 isn't removed")
+	}
+	seq = expectTestEvents(t, tracker, seq, "op31_0:confirmed>vanished")
+	repo.AddTransferRecord(testDeposit(45, 0))
+	seq = expectTestEvents(t, tracker, seq, "op45_0:>seen")
+	// a transfer above the processed block height can't be taken as vanished
+	if !repo.RemoveTransferRecord("op45_0") {
+		t.Fatal("op45_0 isn't removed")
+	}
+	seq = expectTestEvents(t, tracker, seq)
+	if _,ok := tracker.accounts[0].deposits["op45_0"]; !ok {
+		t.Fatal("transfer above the processed block height is forgotten")
+	}
+	// it vanishes once the block is processed without it
+	repo.AddTransferRecord(testBlock(46))
+	expectTestEvents(t, tracker, seq, "op45_0:seen>vanished")
+}
+
+// the cursor and the unfinished transfers are kept in store, so a restarted tracker neither emits the irreversible
+// transfers again nor misses the transitions of the reversible ones
+func TestTrackerContinuesAfterRestart(t *testing.T) {
+	repo := db.NewMemoryRepository()
+	repo.AddTransferRecord(testDeposit(10, 0), testDeposit(30, 0), testDeposit(31, 0))
+	repo.SetLib(20)
+	path := filepath.Join(t.TempDir(), "deposit.db")
+	store := openTestStore(t, path)
+	tracker := newTestTracker(t, repo, store)
+	expectTestEvents(t, tracker, 0, "op10_0:>irreversible", "op30_0:>seen", "op31_0:>seen")
+	store.Close()
+
+	repo.AddTransferRecord(testBlock(39))
+	repo.RemoveTransferRecord("op31_0")
+	tracker = newTestTracker(t, repo, openTestStore(t, path))
+	expectTestEvents(t, tracker, 0, "op30_0:seen>confirmed", "op31_0:seen>vanished")
+	repo.SetLib(39)
+	expectTestEvents(t, tracker, 2, "op30_0:confirmed>irreversible")
+	if acct := tracker.accounts[0]; len(acct.deposits) != 0 || acct.cursor == nil || acct.cursor.OperationId != "op30_0" {
+		t.Fatalf("tracked transfers are %v after %+v, want none after op30_0", acct.deposits, acct.cursor)
+	}
+}
+
+// the transfers are processed page by page, and the cursor passes all the irreversible ones
+func TestScanPages(t *testing.T) {
+	repo := db.NewMemoryRepository()
+	var recs []*plugins.TransferRecord
+	for i := 0; i < scanLimit * 2 + 1; i++ {
+		recs = append(recs, testDeposit(10, i))
+	}
+	repo.AddTransferRecord(append(recs, testDeposit(30, 0))...)
+	repo.SetLib(20)
+	tracker := newTestTracker(t, repo, openTestStore(t, filepath.Join(t.TempDir(), "deposit.db")))
+	if err := tracker.scan(tracker.accounts[0]); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	list,_,_ := tracker.Events(0, "", scanLimit * 3)
+	if len(list) != scanLimit * 2 + 2 || list[len(list)-1].Record.OperationId != "op30_0" || list[len(list)-1].State != types.DepositSeen {
+		t.Fatalf("%v events, the last one is %+v", len(list), list[len(list)-1])
+	}
+	acct := tracker.accounts[0]
+	if len(acct.deposits) != 1 || acct.cursor == nil || acct.cursor.BlockHeight != 10 {
+		t.Fatalf("%v transfers are tracked after %+v, want op30_0 after block 10", len(acct.deposits), acct.cursor)
+	}
+}
@@ -56,7 +56,11 @@ func StartLogService() (*logrus.Logger,error) {
 	return logger,nil
 }
 
+// get the logger, it's the standard logger of logrus if log service is not started
 func GetLogger() *logrus.Logger {
+	if logger == nil {
+		return logrus.StandardLogger()
+	}
 	return logger
 }
 
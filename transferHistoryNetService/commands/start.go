@@ -9,8 +9,10 @@ import (
 	"syscall"
 	"transfer_history/config"
 	"transfer_history/db"
+	"transfer_history/deposit"
 	"transfer_history/logs"
 	"transfer_history/webServer"
+	"transfer_history/webhook"
 )
 
 var svEnv string
@@ -57,7 +59,55 @@ func startNetService(cmd *cobra.Command, args []string)  {
 	}
 	defer db.CloseDbService()
 	//start http service
-	err = webServer.StartServer()
+	repo := db.NewFullNodeRepository()
+	if config.IsLocalIndexerEnabled() {
+		repo = db.NewLocalStoreRepository()
+	}
+	if maxRecords := config.GetQueryCacheMaxRecords(); maxRecords > 0 {
+		repo = db.NewCachedRepository(repo, maxRecords)
+	}
+	//start webhook delivery
+	var outbox *webhook.Outbox
+	if targets := config.GetWebhookTargets(); len(targets) > 0 {
+		outbox,err = webhook.OpenOutbox()
+		if err != nil {
+			logger.Errorf("StartWebhook: fail to open webhook outbox, the error is %v", err)
+			os.Exit(1)
+		}
+		defer outbox.Close()
+		dispatcher,err := webhook.NewDispatcher(repo, outbox, targets, webhook.PolicyFromConfig())
+		if err != nil {
+			logger.Errorf("StartWebhook: fail to start webhook, the error is %v", err)
+			os.Exit(1)
+		}
+		dispatcher.Start()
+		defer dispatcher.Stop()
+	}
+	//start tracking deposits
+	var tracker *deposit.Tracker
+	if len(config.GetDepositAccounts()) > 0 {
+		store,err := deposit.OpenStore()
+		if err != nil {
+			logger.Errorf("StartDepositTracker: fail to open deposit db, the error is %v", err)
+			os.Exit(1)
+		}
+		defer store.Close()
+		tracker,err = deposit.NewTrackerFromConfig(repo, store)
+		if err != nil {
+			logger.Errorf("StartDepositTracker: fail to start deposit tracker, the error is %v", err)
+			os.Exit(1)
+		}
+		tracker.Start()
+		defer tracker.Stop()
+	}
+	//open the acknowledged blocks of transfer subscriptions
+	acks,err := webServer.OpenSubscriptionAcks()
+	if err != nil {
+		logger.Error("OpenSubscriptionAcks:fail to open the ack db of subscriptions")
+		os.Exit(1)
+	}
+	defer acks.Close()
+	err = webServer.StartServer(repo, acks, outbox, tracker)
 	if err != nil {
 		os.Exit(1)
 	}
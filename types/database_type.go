@@ -1,7 +1,99 @@
 package types
 
+import "time"
+
 
 type LibInfo struct {
 	Lib  uint64
 	LastCheckTime uint32
 }
+
+// IndexerProgress is the sync height of the local indexer, the records at or below SyncHeight are irreversible
+type IndexerProgress struct {
+	ID  uint64 `gorm:"primary_key"`
+	SyncHeight uint64
+	// lib and processed block height of the full node db in the last round
+	UpstreamLib uint64
+	UpstreamHeight uint64
+	UpdatedAt time.Time
+}
+
+func (IndexerProgress) TableName() string {
+	return IndexerProgressTableName
+}
+
+// SubscriptionAckBlock is the acknowledged block of a transfer subscription, a subscription is identified by its verification code and id
+type SubscriptionAckBlock struct {
+	ID uint64 `gorm:"primary_key"`
+	VerificationCode string
+	SubscriptionId string
+	BlockHeight uint64
+	UpdatedAt time.Time
+}
+
+func (SubscriptionAckBlock) TableName() string {
+	return SubscriptionAckTableName
+}
+
+// WebhookNotification is a transfer notification in the outbox of a webhook
+type WebhookNotification struct {
+	ID uint64 `gorm:"primary_key"`
+	Url string
+	// id of the payload, it's unique in the outbox of a webhook
+	NotificationId string
+	// json of the WebhookPayload, it's posted as it is in every attempt
+	Payload string `gorm:"type:text"`
+	State string
+	Attempts int
+	LastError string `gorm:"type:text"`
+	NextAttemptAt time.Time
+	DeliveredAt *time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (WebhookNotification) TableName() string {
+	return WebhookNotificationTableName
+}
+
+// WebhookCursor is the position of the last transfer of an account put into the outbox of a webhook
+type WebhookCursor struct {
+	ID uint64 `gorm:"primary_key"`
+	Url string
+	Account string
+	BlockHeight uint64
+	OperationId string
+	UpdatedAt time.Time
+}
+
+func (WebhookCursor) TableName() string {
+	return WebhookCursorTableName
+}
+
+// DepositCursor is the position of the last incoming transfer of a deposit account whose predecessors are all irreversible
+type DepositCursor struct {
+	ID uint64 `gorm:"primary_key"`
+	Account string
+	BlockHeight uint64
+	OperationId string
+	UpdatedAt time.Time
+}
+
+func (DepositCursor) TableName() string {
+	return DepositCursorTableName
+}
+
+// DepositState is the state of an incoming transfer of a deposit account after its cursor
+type DepositState struct {
+	ID uint64 `gorm:"primary_key"`
+	Account string
+	OperationId string
+	State string
+	// json of the last seen TransferRecord of the transfer
+	Record string `gorm:"type:text"`
+	UpdatedAt time.Time
+}
+
+func (DepositState) TableName() string {
+	return DepositStateTableName
+}
@@ -1,9 +1,20 @@
 package types
 
+import "time"
+
 const (
 	LibTableName = "libinfo"
+	IndexerProgressTableName = "indexer_progress"
+	SubscriptionAckTableName = "subscription_ack"
+
+	WebhookNotificationTableName = "webhook_outbox"
+	WebhookCursorTableName = "webhook_cursor"
+	DepositCursorTableName = "deposit_cursor"
+	DepositStateTableName = "deposit_state"
 	TxDirectionSend = 1
 	TxDirectionReceive = 2
+	// both transfer out and transfer in
+	TxDirectionAll = 3
 
 	StatusSuccess = 200
 	StatusIntervalError = 500
@@ -13,6 +24,7 @@ const (
 	StatusParamInvalidError = 504
 	StatusParamVerificationCodeInvalidError = 505
 	StatusParamTransferDirectionInvalidError = 506
+	StatusQueryTimeoutError = 507
 )
 
 
@@ -28,24 +40,378 @@ type TransferRecord struct {
 	Memo   string
 	Amount string
 	BlockHeight  string
+	// block time in RFC3339 format(UTC)
+	BlockTime string
+	// direction relative to the queried account, 1:transfer out 2:transfer in 3:transfer to itself
+	Direction int
+	// whether the block of the record is at or below lib
+	Irreversible bool
+	// count of indexed blocks from the block of the record, only for reversible record
+	Confirmations uint64
 }
 
 type TransferHistoryResponse struct {
 	BaseResponse
 	HeadBlockHeight string
+	// the last block covered by the response, it's HeadBlockHeight unless the reversible records are asked for
 	MaxBlockHeight string
+	// the max block height of indexed transfer records, it may be above HeadBlockHeight
+	IndexedBlockHeight string
+	NextCursor string
+	HasMore bool
     List []*TransferRecord
 }
 
+// position of a transfer record in the stable (block_height, operation_id) order
+type TransferCursor struct {
+	BlockHeight uint64
+	OperationId string
+}
+
+// optional filters of transfer record, the zero value means no filter
+type TransferRecordFilter struct {
+	// the other side of the transfer
+	Counterparty string
+	MinAmount *uint64
+	MaxAmount *uint64
+	// exact memo
+	Memo string
+	MemoPrefix string
+}
+
+type TransferRecordQuery struct {
+	Start   uint64
+	Account string
+	Direction int
+	Filter  TransferRecordFilter
+	// only return records in blocks produced in [StartTime, EndTime) if they are not nil
+	StartTime *time.Time
+	EndTime   *time.Time
+	// max count of records to return, 0 means no limit
+	Limit   int
+	// only return records after this position if it is not nil
+	Cursor  *TransferCursor
+	// also return the records above lib
+	IncludeReversible bool
+}
+
 type QueryTransferRecordModel struct {
 	List []*TransferRecord
 	Lib  uint64
 	MaxQueryBlkNum uint64
+	NextCursor *TransferCursor
+	HasMore bool
+	Err  error
+	ErrCode int
+}
+
+type AccountStartBlock struct {
+	Account string
+	Start   uint64
+}
+
+type BatchTransferRecordQuery struct {
+	Accounts []*AccountStartBlock
+	Direction int
+	// max count of records of all the accounts to return, 0 means no limit.
+	// a transfer between two of the accounts is counted once
+	Limit   int
+	// only return records after this position if it is not nil
+	Cursor  *TransferCursor
+	// also return the records above lib
+	IncludeReversible bool
+}
+
+type QueryBatchTransferRecordModel struct {
+	// transfer records keyed by account
+	Records map[string][]*TransferRecord
+	Lib  uint64
+	MaxQueryBlkNum uint64
+	// position of the last returned record of all the accounts
+	NextCursor *TransferCursor
+	HasMore bool
+	Err  error
+	ErrCode int
+}
+
+type BatchTransferHistoryResponse struct {
+	BaseResponse
+	HeadBlockHeight string
+	// the last block covered by the response, it's HeadBlockHeight unless the reversible records are asked for
+	MaxBlockHeight string
+	// the max block height of indexed transfer records, it may be above HeadBlockHeight
+	IndexedBlockHeight string
+	NextCursor string
+	HasMore bool
+	Records map[string][]*TransferRecord
+}
+
+type TransferSummaryQuery struct {
+	Account string
+	Start   uint64
+	// end block height(inclusive), 0 means up to the max block height of transfer record
+	End     uint64
+	// only count the records in blocks produced in [StartTime, EndTime) if they are not nil
+	StartTime *time.Time
+	EndTime   *time.Time
+}
+
+// totals of an account's transfers, the amounts are base units(the actual amount*1000000)
+// and the Cos amounts are the decimal COS strings of them
+type TransferSummary struct {
+	Account string
+	InAmount string
+	OutAmount string
+	NetAmount string
+	InAmountCos string
+	OutAmountCos string
+	NetAmountCos string
+	InCount uint64
+	OutCount uint64
+}
+
+type QueryTransferSummaryModel struct {
+	Summary *TransferSummary
+	Lib uint64
+	MaxQueryBlkNum uint64
+	// the block height range actually counted
+	StartBlkNum uint64
+	EndBlkNum uint64
 	Err  error
 	ErrCode int
 }
 
+type TransferSummaryResponse struct {
+	BaseResponse
+	HeadBlockHeight string
+	// the last block counted, it's always HeadBlockHeight
+	MaxBlockHeight string
+	// the max block height of indexed transfer records, it may be above HeadBlockHeight
+	IndexedBlockHeight string
+	StartBlockHeight string
+	EndBlockHeight string
+	Summary *TransferSummary
+}
+
+type TransferStatisticsQuery struct {
+	// empty account means the whole chain
+	Account string
+	Direction int
+	// bucket size, time.Hour or 24 * time.Hour
+	Interval time.Duration
+	StartTime time.Time
+	EndTime time.Time
+}
+
+type TransferStatisticsBucket struct {
+	// start time of the bucket in RFC3339 format(UTC)
+	StartTime string
+	Count uint64
+	// sum of transfer amount(the actual amount*1000000) and the decimal COS string of it
+	Amount string
+	AmountCos string
+}
+
+type QueryTransferStatisticsModel struct {
+	Buckets []*TransferStatisticsBucket
+	Lib uint64
+	MaxQueryBlkNum uint64
+	Err  error
+	ErrCode int
+}
+
+type TransferStatisticsResponse struct {
+	BaseResponse
+	HeadBlockHeight string
+	// the last block counted, it's always HeadBlockHeight
+	MaxBlockHeight string
+	// the max block height of indexed transfer records, it may be above HeadBlockHeight
+	IndexedBlockHeight string
+	Buckets []*TransferStatisticsBucket
+}
+
+// health of a cos observe node db
+type FullNodeStatus struct {
+	Host string
+	Healthy bool
+	// whether the node is behind the freshest node more than the max block lag
+	Lagging bool
+	ErrorRate float64
+	LatencyMs int64
+	BlockHeight uint64
+	Lib uint64
+	// how many blocks the node is behind the freshest node
+	BlockLag uint64
+	LibLag uint64
+	LastError string
+}
+
+type NodeStatusResponse struct {
+	BaseResponse
+	List []*FullNodeStatus
+}
+
+type TransferByOperationResponse struct {
+	BaseResponse
+	HeadBlockHeight string
+	// whether all the records are at or below the latest irreversible block
+	Irreversible bool
+	List []*TransferRecord
+}
+
 type SingleBlockTransferHistoryResponse struct {
 	BaseResponse
+	HeadBlockHeight string
+	// the last block covered by the response, it's HeadBlockHeight unless the reversible records are asked for
+	MaxBlockHeight string
+	// the max block height of indexed transfer records, it may be above HeadBlockHeight
+	IndexedBlockHeight string
 	List []*TransferRecord
 }
+
+// QueryCacheStatus is the usage of the cache of irreversible query results
+type QueryCacheStatus struct {
+	Hits uint64
+	Misses uint64
+	// count of cached results and the transfer records in them
+	Entries int
+	Records int
+	MaxRecords int
+}
+
+type QueryCacheStatusResponse struct {
+	BaseResponse
+	Cache *QueryCacheStatus
+}
+
+const (
+	// messages sent by the client of transfer subscription
+	SubscriptionSubscribe = "subscribe"
+	SubscriptionAck = "ack"
+	// messages pushed to the client of transfer subscription
+	SubscriptionSubscribed = "subscribed"
+	SubscriptionTransfer = "transfer"
+	SubscriptionSynced = "synced"
+	SubscriptionError = "error"
+)
+
+// an account watched by transfer subscription
+type AccountSubscription struct {
+	Account string
+	Direction int
+	// the records at or above this block are pushed
+	Start uint64
+}
+
+// message sent by the client of transfer subscription, subscribe or ack
+type SubscriptionRequest struct {
+	Type string
+	// chosen by the client, the subscription with the same id resumes after the acknowledged block
+	Id string
+	Accounts []*AccountSubscription
+	// all the records at or below this block are processed by the client, only for ack
+	BlockHeight uint64
+}
+
+// reply of subscribe, the start of every account is moved after the acknowledged block of the subscription
+type SubscribedMessage struct {
+	Type string
+	Id string
+	Accounts []*AccountSubscription
+}
+
+type TransferMessage struct {
+	Type string
+	// the subscribed account which the record belongs to
+	Account string
+	Record *TransferRecord
+}
+
+// all the records at or below BlockHeight are pushed
+type SyncedMessage struct {
+	Type string
+	BlockHeight string
+}
+
+type SubscriptionErrorMessage struct {
+	BaseResponse
+	Type string
+}
+
+// WebhookPayload is the body posted to webhook for a transfer of a watched account
+type WebhookPayload struct {
+	// unique id of the notification, it's the same in every retry
+	Id string
+	// the watched account which the record belongs to
+	Account string
+	Record *TransferRecord
+}
+
+const (
+	// delivery state of the notifications in webhook outbox
+	WebhookNotificationPending = "pending"
+	WebhookNotificationDelivered = "delivered"
+	// failed in all the attempts, it's kept until it's replayed or dropped by admin
+	WebhookNotificationDead = "dead"
+)
+
+// WebhookOutboxEntry is a notification in the webhook outbox
+type WebhookOutboxEntry struct {
+	Id uint64
+	Url string
+	State string
+	Attempts int
+	LastError string
+	// the pending notification is not delivered before this time
+	NextAttemptTime time.Time
+	CreatedTime time.Time
+	DeliveredTime *time.Time
+	Payload *WebhookPayload
+}
+
+type WebhookOutboxResponse struct {
+	BaseResponse
+	List []*WebhookOutboxEntry
+	// whether there are more entries after the last one in List
+	HasMore bool
+}
+
+type WebhookOutboxUpdateResponse struct {
+	BaseResponse
+	// count of the replayed or dropped entries
+	Count int64
+}
+
+const (
+	// states of the incoming transfer of a deposit account
+	DepositSeen = "seen"
+	// the transfer has the configured count of confirmations but it's still reversible
+	DepositConfirmed = "confirmed"
+	DepositIrreversible = "irreversible"
+	// the transfer is removed from the db before it becomes irreversible
+	DepositVanished = "vanished"
+)
+
+// DepositEvent is a state transition of an incoming transfer of a deposit account
+type DepositEvent struct {
+	// sequence number of the event, it starts from 1 when the tracker starts
+	Seq uint64
+	Account string
+	State string
+	// empty if it's the first event of the transfer
+	PreviousState string
+	// the record when the transition is found, it's the last seen record if the transfer vanishes
+	Record *TransferRecord
+	Time time.Time
+}
+
+type DepositEventResponse struct {
+	BaseResponse
+	// start time of the tracker in unix seconds, the sequence numbers restart when it changes
+	Epoch int64
+	List []*DepositEvent
+	// whether there are more events after the last one in List
+	HasMore bool
+	// whether some events after the requested sequence number are dropped from memory
+	Missed bool
+}
@@ -1,8 +1,98 @@
 package utils
 
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/coschain/contentos-go/common/constants"
+	"github.com/coschain/contentos-go/prototype"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+	"transfer_history/types"
+)
+
 func CheckIsNotEmptyStr(str string) bool {
 	if str != "" && len(str) > 0 {
 		return true
 	}
 	return false
-}
\ No newline at end of file
+}
+
+// check whether the string is a valid cos account name (6-16 characters of lowercase letters and digits)
+func CheckIsValidAccountName(name string) bool {
+	return prototype.ValidAccountName(name) == nil
+}
+
+// check whether the string is a trx hash (64 lowercase hex characters)
+func CheckIsValidTrxHash(hash string) bool {
+	if len(hash) != 64 {
+		return false
+	}
+	for _, c := range hash {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// check whether the string is a transfer operation id (trx hash + "_" + operation index)
+func CheckIsValidOperationId(id string) bool {
+	parts := strings.SplitN(id, "_", 2)
+	if len(parts) != 2 || !CheckIsValidTrxHash(parts[0]) {
+		return false
+	}
+	idx, err := strconv.ParseUint(parts[1], 10, 32)
+	return err == nil && strconv.FormatUint(idx, 10) == parts[1]
+}
+
+// format amount of base units to decimal COS string, e.g. 1500000 -> "1.500000"
+func FormatCosAmount(amount *big.Int) string {
+	abs := new(big.Int).Abs(amount)
+	integer, fraction := new(big.Int).QuoRem(abs, big.NewInt(constants.COSTokenDecimals), new(big.Int))
+	sign := ""
+	if amount.Sign() < 0 {
+		sign = "-"
+	}
+	return fmt.Sprintf("%s%s.%06d", sign, integer.String(), fraction.Int64())
+}
+
+// encode the position of a transfer record to an opaque cursor string
+func EncodeTransferCursor(cursor *types.TransferCursor) string {
+	if cursor == nil {
+		return ""
+	}
+	raw := fmt.Sprintf("%d:%s", cursor.BlockHeight, cursor.OperationId)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decode the cursor string generated by EncodeTransferCursor
+func DecodeTransferCursor(str string) (*types.TransferCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(str)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("fail to decode cursor %v", str))
+	}
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 || !CheckIsNotEmptyStr(parts[1]) {
+		return nil, errors.New(fmt.Sprintf("invalid cursor %v", str))
+	}
+	blkNum, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid block height in cursor %v", str))
+	}
+	return &types.TransferCursor{BlockHeight: blkNum, OperationId: parts[1]}, nil
+}
+
+// parse time from RFC3339 string or unix seconds
+func ParseTimeParam(str string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		return time.Time{}, errors.New(fmt.Sprintf("invalid time %v, it should be RFC3339 or unix seconds", str))
+	}
+	return t, nil
+}
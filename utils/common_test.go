@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"encoding/base64"
+	"math/big"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"transfer_history/types"
+)
+
+func TestTransferCursorRoundTrip(t *testing.T) {
+	opId := "9f2c3b7a8e6d5c4b3a29180706f5e4d3c2b1a09f8e7d6c5b4a3928170605f4e3_2"
+	for _,cursor := range []*types.TransferCursor{
+		{BlockHeight: 0, OperationId: opId},
+		{BlockHeight: 1234567, OperationId: opId},
+		{BlockHeight: 18446744073709551615, OperationId: "a:b"},
+	} {
+		str := EncodeTransferCursor(cursor)
+		got,err := DecodeTransferCursor(str)
+		if err != nil {
+			t.Fatalf("decode cursor %+v: %v", cursor, err)
+		}
+		if *got != *cursor {
+			t.Errorf("cursor %+v is decoded to %+v", cursor, got)
+		}
+	}
+	if str := EncodeTransferCursor(nil); str != "" {
+		t.Errorf("nil cursor is encoded to %q", str)
+	}
+}
+
+// cursors not generated by EncodeTransferCursor must be rejected
+func TestDecodeInvalidTransferCursor(t *testing.T) {
+	encode := func(raw string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+	for name,str := range map[string]string{
+		"not base64":          "not a cursor!",
+		"padded base64":       base64.URLEncoding.EncodeToString([]byte("10:op_0")),
+		"no separator":        encode("10"),
+		"empty operation id":  encode("10:"),
+		"negative height":     encode("-1:op_0"),
+		"non-numeric height":  encode("ten:op_0"),
+		"empty height":        encode(":op_0"),
+		"overflowed height":   encode("18446744073709551616:op_0"),
+		"json of other api":   encode(`{"page":2}`),
+	} {
+		if cursor,err := DecodeTransferCursor(str); err == nil {
+			t.Errorf("%v: cursor %q is decoded to %+v", name, str, cursor)
+		}
+	}
+}
+
+func TestParseTimeParam(t *testing.T) {
+	want := time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)
+	for _,str := range []string{"2026-10-01T08:00:00Z", "2026-10-01T16:00:00+08:00", strconv.FormatInt(want.Unix(), 10)} {
+		got,err := ParseTimeParam(str)
+		if err != nil {
+			t.Fatalf("parse %q: %v", str, err)
+		}
+		if !got.Equal(want) {
+			t.Errorf("%q is parsed to %v, want %v", str, got, want)
+		}
+	}
+	for _,str := range []string{"", "2026-10-01", "2026-10-01 08:00:00", "1.5", "yesterday"} {
+		if got,err := ParseTimeParam(str); err == nil {
+			t.Errorf("invalid time %q is parsed to %v", str, got)
+		}
+	}
+}
+
+func TestCheckTrxHashAndOperationId(t *testing.T) {
+	hash := strings.Repeat("0123456789abcdef", 4)
+	for str,want := range map[string]bool{
+		hash: true,
+		hash[1:]: false,
+		hash + "0": false,
+		strings.ToUpper(hash): false,
+		hash[:63] + "g": false,
+		hash[:62] + "_1": false,
+		hash[:62] + "%%": false,
+	} {
+		if got := CheckIsValidTrxHash(str); got != want {
+			t.Errorf("trx hash %q is valid: %v, want %v", str, got, want)
+		}
+	}
+	for str,want := range map[string]bool{
+		hash + "_0": true,
+		hash + "_12": true,
+		hash: false,
+		hash + "_": false,
+		hash + "_01": false,
+		hash + "_-1": false,
+		hash + "_+1": false,
+		hash + "_1_2": false,
+		hash + "_%": false,
+		hash + "_99999999999": false,
+		"_0": false,
+	} {
+		if got := CheckIsValidOperationId(str); got != want {
+			t.Errorf("operation id %q is valid: %v, want %v", str, got, want)
+		}
+	}
+}
+
+func TestFormatCosAmount(t *testing.T) {
+	maxUint64 := new(big.Int).SetUint64(18446744073709551615)
+	for _,c := range []struct {
+		amount *big.Int
+		want string
+	}{
+		{big.NewInt(0), "0.000000"},
+		{big.NewInt(1), "0.000001"},
+		{big.NewInt(1500000), "1.500000"},
+		{big.NewInt(-1500000), "-1.500000"},
+		{big.NewInt(-1), "-0.000001"},
+		{maxUint64, "18446744073709.551615"},
+		// the sum of several max amounts doesn't overflow
+		{new(big.Int).Mul(maxUint64, big.NewInt(3)), "55340232221128.654845"},
+	} {
+		if got := FormatCosAmount(c.amount); got != c.want {
+			t.Errorf("amount %v is formatted to %v, want %v", c.amount, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package webServer
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"transfer_history/db"
+	"transfer_history/types"
+)
+
+// a repository which counts the queries reaching it
+type countingRepository struct {
+	db.TransferRepository
+	calls int32
+}
+
+func (repo *countingRepository) count() {
+	atomic.AddInt32(&repo.calls, 1)
+}
+
+func (repo *countingRepository) GetLib(ctx context.Context) (uint64,error) {
+	repo.count()
+	return repo.TransferRepository.GetLib(ctx)
+}
+
+func (repo *countingRepository) GetMaxBlockHeight(ctx context.Context) (uint64,error) {
+	repo.count()
+	return repo.TransferRepository.GetMaxBlockHeight(ctx)
+}
+
+func (repo *countingRepository) GetTransferRecord(ctx context.Context, query *types.TransferRecordQuery) *types.QueryTransferRecordModel {
+	repo.count()
+	return repo.TransferRepository.GetTransferRecord(ctx, query)
+}
+
+func (repo *countingRepository) GetUserTransferRecordByBlock(ctx context.Context, blkNum uint64, acct string, dir int, includeReversible bool) *types.QueryTransferRecordModel {
+	repo.count()
+	return repo.TransferRepository.GetUserTransferRecordByBlock(ctx, blkNum, acct, dir, includeReversible)
+}
+
+func (repo *countingRepository) GetBatchTransferRecord(ctx context.Context, query *types.BatchTransferRecordQuery) *types.QueryBatchTransferRecordModel {
+	repo.count()
+	return repo.TransferRepository.GetBatchTransferRecord(ctx, query)
+}
+
+func (repo *countingRepository) GetTransferSummary(ctx context.Context, query *types.TransferSummaryQuery) *types.QueryTransferSummaryModel {
+	repo.count()
+	return repo.TransferRepository.GetTransferSummary(ctx, query)
+}
+
+func (repo *countingRepository) GetTransferStatistics(ctx context.Context, query *types.TransferStatisticsQuery) *types.QueryTransferStatisticsModel {
+	repo.count()
+	return repo.TransferRepository.GetTransferStatistics(ctx, query)
+}
+
+func (repo *countingRepository) GetTransferRecordByOperation(ctx context.Context, id string, isTrxHash bool) *types.QueryTransferRecordModel {
+	repo.count()
+	return repo.TransferRepository.GetTransferRecordByOperation(ctx, id, isTrxHash)
+}
+
+var hostileAccountNames = []string{
+	"",
+	"alice1'",
+	"alice1' OR '1'='1",
+	`alice1"; DROP TABLE transfer_records; --`,
+	"%",
+	"alice%",
+	"_",
+	"alic_1",
+	"alice1\x00",
+	"Alice1",
+	"alice",
+	strings.Repeat("a", 17),
+	strings.Repeat("a", 4096),
+}
+
+func TestHostileAccountNamesNeverReachRepository(t *testing.T) {
+	repo := &countingRepository{TransferRepository: newTestRepository()}
+	server := newTestServer(t, repo)
+	// the params of every api besides the account
+	apis := []struct {
+		url string
+		key string
+		params url.Values
+	}{
+		{getTransferHistoryUrl, accountNameKey, url.Values{txDirectionKey: {"3"}, startBlockNumKey: {"0"}}},
+		{getTransferHistoryInBlockUrl, accountNameKey, url.Values{txDirectionKey: {"3"}, singleBlockKey: {"10"}}},
+		{getTransferSummaryUrl, accountNameKey, url.Values{}},
+		{getTransferStatisticsUrl, accountNameKey, url.Values{intervalKey: {dayInterval}, startTimeKey: {"2020-01-01T00:00:00Z"}, endTimeKey: {"2020-01-02T00:00:00Z"}}},
+		{getTransferHistoryUrl, counterpartyKey, url.Values{accountNameKey: {"alice1"}, txDirectionKey: {"3"}, startBlockNumKey: {"0"}}},
+		{getBatchTransferHistoryUrl, accountsKey, url.Values{txDirectionKey: {"3"}}},
+	}
+	for _,api := range apis {
+		for _,name := range hostileAccountNames {
+			if api.key == counterpartyKey && name == "" {
+				// counterparty is optional
+				continue
+			}
+			params := url.Values{}
+			for k,v := range api.params {
+				params[k] = v
+			}
+			params.Set(api.key, name)
+			if api.key == accountsKey {
+				params.Set(api.key, "alice1:0," + name + ":0")
+			}
+			res := &types.BaseResponse{}
+			getApi(t, server, api.url, params, res)
+			if res.Status != types.StatusParamInvalidError {
+				t.Errorf("%v with %v %q: status is %v, msg is %v", api.url, api.key, name, res.Status, res.Msg)
+			}
+		}
+	}
+	// a query string which can't be decoded
+	resp,err := http.Get(server.URL + getTransferHistoryUrl + "?code=" + testVerificationCode + "&direction=3&start=0&account=alice%zz")
+	if err != nil {
+		t.Fatalf("get %v: %v", getTransferHistoryUrl, err)
+	}
+	res := &types.BaseResponse{}
+	decodeResponse(t, resp, res)
+	if res.Status != types.StatusParamInvalidError {
+		t.Errorf("undecodable account: status is %v, msg is %v", res.Status, res.Msg)
+	}
+	if calls := atomic.LoadInt32(&repo.calls); calls != 0 {
+		t.Fatalf("hostile account names reach the repository %v times", calls)
+	}
+
+	// the same apis with a valid account do reach it
+	params := url.Values{accountNameKey: {"alice1"}, txDirectionKey: {"3"}, startBlockNumKey: {"0"}}
+	getApi(t, server, getTransferHistoryUrl, params, &types.BaseResponse{})
+	if atomic.LoadInt32(&repo.calls) == 0 {
+		t.Fatal("valid account name doesn't reach the repository")
+	}
+}
@@ -0,0 +1,56 @@
+package webServer
+
+import (
+	"fmt"
+	"net/http"
+	"transfer_history/logs"
+	"transfer_history/types"
+	"transfer_history/utils"
+)
+
+//
+// get the state transitions of the incoming transfers of deposit accounts after a sequence number
+//
+func (h *transferHandler) getDepositEvents(w http.ResponseWriter, r *http.Request)  {
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+	logger := logs.GetLogger()
+	res := types.DepositEventResponse{List: make([]*types.DepositEvent, 0)}
+	vCode,err,code := parseVerificationCode(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	acctName,err,code := parseOptionalParameterFromRequest(r, accountNameKey)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	if utils.CheckIsNotEmptyStr(acctName) && !utils.CheckIsValidAccountName(acctName) {
+		res.Status = types.StatusParamInvalidError
+		res.Msg = fmt.Sprintf("account name %q is invalid", acctName)
+		writeResponse(w, res)
+		return
+	}
+	after,limit,err,code := parseAfterParams(r, defaultQueryLimit)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	logger.Infof("getDepositEvents: account is:%v, after is:%v, limit is:%v, verification code is:%v", acctName, after, limit, vCode)
+	if h.tracker == nil {
+		res.Status = types.StatusParamInvalidError
+		res.Msg = "no deposit account is tracked"
+		writeResponse(w, res)
+		return
+	}
+	res.Status = types.StatusSuccess
+	res.Epoch = h.tracker.Epoch()
+	res.List,res.HasMore,res.Missed = h.tracker.Events(after, acctName, limit)
+	writeResponse(w, res)
+}
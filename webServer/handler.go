@@ -1,27 +1,72 @@
 package webServer
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"transfer_history/config"
 	"transfer_history/db"
+	"transfer_history/deposit"
 	"transfer_history/logs"
 	"transfer_history/types"
 	"transfer_history/utils"
+	"transfer_history/webhook"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const (
 	startBlockNumKey = "start"
 	singleBlockKey = "block"
+	endBlockNumKey = "end"
 	accountNameKey = "account"
 	txDirectionKey = "direction"
 	verificationCodeKey = "code"
+	limitKey = "limit"
+	cursorKey = "cursor"
+	startTimeKey = "startTime"
+	endTimeKey = "endTime"
+	counterpartyKey = "counterparty"
+	minAmountKey = "minAmount"
+	maxAmountKey = "maxAmount"
+	memoKey = "memo"
+	memoPrefixKey = "memoPrefix"
+	operationIdKey = "operationId"
+	trxHashKey = "trxHash"
+	accountsKey = "accounts"
+	intervalKey = "interval"
+	includeReversibleKey = "includeReversible"
+	// the sequence number or id of the last item of the previous page
+	afterKey = "after"
+	waitKey = "wait"
+
+	hourInterval = "hour"
+	dayInterval = "day"
+
+	// default and max count of records in one page of transfer history
+	defaultQueryLimit = 1000
+	maxQueryLimit = 10000
+	// max count of accounts in one batch query
+	maxBatchAccountCount = 100
+	// max seconds to wait for the new records, it's less than the write timeout of http server
+	maxWaitSeconds = 60
 )
 
+// http handlers of transfer history api, they query transfer records from repo
+type transferHandler struct {
+	repo db.TransferRepository
+	// the acknowledged blocks of transfer subscriptions
+	acks *SubscriptionAcks
+	// the notifications of webhooks, nil if no webhook is configured
+	outbox *webhook.Outbox
+	// the tracker of deposit accounts, nil if no deposit account is configured
+	tracker *deposit.Tracker
+}
+
 type historyParamsModel struct {
 	verificationCode string
 	txDirection  int
@@ -33,7 +78,7 @@ type historyParamsModel struct {
 //
 // get a list of user's transfer history
 //
-func getTransferHistory(w http.ResponseWriter, r *http.Request)  {
+func (h *transferHandler) getTransferHistory(w http.ResponseWriter, r *http.Request)  {
 	w.Header().Add("Access-Control-Allow-Origin", "*")
     logger := logs.GetLogger()
 	res := types.TransferHistoryResponse{
@@ -50,8 +95,22 @@ func getTransferHistory(w http.ResponseWriter, r *http.Request)  {
 	dir := paramsInfo.txDirection
 	acctName := paramsInfo.account
 
-	// get start block height
-	startBlkNum,err,code := parseBlockNumberByKey(r, startBlockNumKey)
+	// get time range
+	startTime,endTime,err,code := parseTimeRangeParams(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+
+	// get start block height, it's optional if start time is provided
+	var startBlkNum uint64
+	if startTime == nil {
+		startBlkNum,err,code = parseBlockNumberByKey(r, startBlockNumKey)
+	} else {
+		startBlkNum,err,code = parseOptionalBlockNumberByKey(r, startBlockNumKey)
+	}
 	if err != nil {
 		res.Status = code
 		res.Msg = err.Error()
@@ -59,19 +118,61 @@ func getTransferHistory(w http.ResponseWriter, r *http.Request)  {
 		return
 	}
 
-	logger.Infof("getTransferHistory: start is:%v, transfer direction is:%v, account is:%v, verification code is:%v", startBlkNum, dir, acctName, paramsInfo.verificationCode)
-	isSender := false
-	if dir == types.TxDirectionSend {
-		isSender = true
+	// get page params
+	limit,cursor,err,code := parsePageParams(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+
+	// get optional filters
+	filter,err,code := parseFilterParams(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
 	}
-	model := db.GetTransferRecord(startBlkNum, acctName, isSender)
+	includeReversible,err,code := parseIncludeReversible(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	wait,err,code := parseWait(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+
+	logger.Infof("getTransferHistory: start is:%v, start time is:%v, end time is:%v, transfer direction is:%v, account is:%v, filter is:%+v, limit is:%v, cursor is:%v, include reversible is:%v, wait is:%v, verification code is:%v", startBlkNum, startTime, endTime, dir, acctName, filter, limit, cursor, includeReversible, wait, paramsInfo.verificationCode)
+	query := &types.TransferRecordQuery{
+		Start: startBlkNum,
+		Account: acctName,
+		Direction: dir,
+		Filter: filter,
+		StartTime: startTime,
+		EndTime: endTime,
+		Limit: limit,
+		Cursor: cursor,
+		IncludeReversible: includeReversible,
+	}
+	model := h.waitTransferRecord(r, query, wait)
 	if model.Err != nil {
 		res.Status = model.ErrCode
 		res.Msg = model.Err.Error()
 	} else {
 		res.Status = types.StatusSuccess
 		res.HeadBlockHeight = strconv.FormatUint(model.Lib, 10)
-		res.MaxBlockHeight = strconv.FormatUint(model.MaxQueryBlkNum, 10)
+		res.MaxBlockHeight = strconv.FormatUint(coveredBlockHeight(model.Lib, model.MaxQueryBlkNum, includeReversible), 10)
+		res.IndexedBlockHeight = strconv.FormatUint(model.MaxQueryBlkNum, 10)
+		res.NextCursor = utils.EncodeTransferCursor(model.NextCursor)
+		res.HasMore = model.HasMore
 		if len(model.List) > 0 {
 			res.List = model.List
 		}
@@ -79,7 +180,31 @@ func getTransferHistory(w http.ResponseWriter, r *http.Request)  {
 	writeResponse(w, res)
 }
 
-func getTransferHistoryOfBlock(w http.ResponseWriter, r *http.Request)  {
+// query the records, if there is none, query again whenever new blocks are found until some records are found or wait expires.
+// all the waiting requests share the block watcher of repo
+func (h *transferHandler) waitTransferRecord(r *http.Request, query *types.TransferRecordQuery, wait time.Duration) *types.QueryTransferRecordModel {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		// get the notification before querying, so the blocks indexed while querying are not missed
+		changed := db.WaitBlockChange(h.repo)
+		ctx,cancel := newQueryContext(r, "getTransferHistory")
+		model := h.repo.GetTransferRecord(ctx, query)
+		cancel()
+		if model.Err != nil || len(model.List) > 0 || wait <= 0 {
+			return model
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return model
+		case <-r.Context().Done():
+			return model
+		}
+	}
+}
+
+func (h *transferHandler) getTransferHistoryOfBlock(w http.ResponseWriter, r *http.Request)  {
 	logger := logs.GetLogger()
 	res := types.SingleBlockTransferHistoryResponse{
 		List: make([]*types.TransferRecord,0),
@@ -99,25 +224,382 @@ func getTransferHistoryOfBlock(w http.ResponseWriter, r *http.Request)  {
 		writeResponse(w, res)
 		return
 	}
+	includeReversible,err,code := parseIncludeReversible(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+
+	logger.Infof("getTransferHistoryByBlock: block is:%v, account is:%v, transfer direction is:%v, include reversible is:%v, verification code is:%v", blkNum, paramsInfo.account, paramsInfo.txDirection, includeReversible, paramsInfo.verificationCode)
+	ctx,cancel := newQueryContext(r, "getTransferHistoryByBlock")
+	defer cancel()
+	model := h.repo.GetUserTransferRecordByBlock(ctx, blkNum, paramsInfo.account, paramsInfo.txDirection, includeReversible)
+	if model.Err != nil {
+		res.Status = model.ErrCode
+		res.Msg = model.Err.Error()
+	} else {
+		res.Status = types.StatusSuccess
+		res.HeadBlockHeight = strconv.FormatUint(model.Lib, 10)
+		res.MaxBlockHeight = strconv.FormatUint(coveredBlockHeight(model.Lib, model.MaxQueryBlkNum, includeReversible), 10)
+		res.IndexedBlockHeight = strconv.FormatUint(model.MaxQueryBlkNum, 10)
+		if len(model.List) > 0 {
+			res.List = model.List
+		}
+	}
+	writeResponse(w, res)
+}
+
+//
+// get transfer history of several accounts in one request
+//
+func (h *transferHandler) getBatchTransferHistory(w http.ResponseWriter, r *http.Request)  {
+	logger := logs.GetLogger()
+	res := types.BatchTransferHistoryResponse{
+		Records: make(map[string][]*types.TransferRecord),
+	}
+	vCode,err,code := parseVerificationCode(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	dir,err,code := parseTxDirection(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	accounts,err,code := parseBatchAccounts(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	includeReversible,err,code := parseIncludeReversible(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+
+	limit,cursor,err,code := parsePageParams(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	if limit == 0 {
+		// the records of many accounts are always paged
+		limit = defaultQueryLimit
+	}
+
+	logger.Infof("getBatchTransferHistory: account count is:%v, transfer direction is:%v, limit is:%v, cursor is:%v, include reversible is:%v, verification code is:%v", len(accounts), dir, limit, cursor, includeReversible, vCode)
+	query := &types.BatchTransferRecordQuery{
+		Accounts: accounts,
+		Direction: dir,
+		Limit: limit,
+		Cursor: cursor,
+		IncludeReversible: includeReversible,
+	}
+	ctx,cancel := newQueryContext(r, "getBatchTransferHistory")
+	defer cancel()
+	model := h.repo.GetBatchTransferRecord(ctx, query)
+	if model.Err != nil {
+		res.Status = model.ErrCode
+		res.Msg = model.Err.Error()
+	} else {
+		res.Status = types.StatusSuccess
+		res.HeadBlockHeight = strconv.FormatUint(model.Lib, 10)
+		res.MaxBlockHeight = strconv.FormatUint(coveredBlockHeight(model.Lib, model.MaxQueryBlkNum, includeReversible), 10)
+		res.IndexedBlockHeight = strconv.FormatUint(model.MaxQueryBlkNum, 10)
+		res.NextCursor = utils.EncodeTransferCursor(model.NextCursor)
+		res.HasMore = model.HasMore
+		res.Records = model.Records
+	}
+	writeResponse(w, res)
+}
+
+//
+// get the totals of an account's transfers in a block or time range
+//
+func (h *transferHandler) getTransferSummary(w http.ResponseWriter, r *http.Request)  {
+	logger := logs.GetLogger()
+	res := types.TransferSummaryResponse{}
+	vCode,err,code := parseVerificationCode(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	acctName,err,code := parseAccountName(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	startTime,endTime,err,code := parseTimeRangeParams(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	startBlkNum,err,code := parseOptionalBlockNumberByKey(r, startBlockNumKey)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	endBlkNum,err,code := parseOptionalBlockNumberByKey(r, endBlockNumKey)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	if endBlkNum > 0 && endBlkNum < startBlkNum {
+		res.Status = types.StatusParamInvalidError
+		res.Msg = fmt.Sprintf("end block %v is less than start block %v", endBlkNum, startBlkNum)
+		writeResponse(w, res)
+		return
+	}
+
+	logger.Infof("getTransferSummary: account is:%v, start is:%v, end is:%v, start time is:%v, end time is:%v, verification code is:%v", acctName, startBlkNum, endBlkNum, startTime, endTime, vCode)
+	query := &types.TransferSummaryQuery{
+		Account: acctName,
+		Start: startBlkNum,
+		End: endBlkNum,
+		StartTime: startTime,
+		EndTime: endTime,
+	}
+	ctx,cancel := newQueryContext(r, "getTransferSummary")
+	defer cancel()
+	model := h.repo.GetTransferSummary(ctx, query)
+	if model.Err != nil {
+		res.Status = model.ErrCode
+		res.Msg = model.Err.Error()
+	} else {
+		res.Status = types.StatusSuccess
+		res.HeadBlockHeight = strconv.FormatUint(model.Lib, 10)
+		res.MaxBlockHeight = strconv.FormatUint(coveredBlockHeight(model.Lib, model.MaxQueryBlkNum, false), 10)
+		res.IndexedBlockHeight = strconv.FormatUint(model.MaxQueryBlkNum, 10)
+		res.StartBlockHeight = strconv.FormatUint(model.StartBlkNum, 10)
+		res.EndBlockHeight = strconv.FormatUint(model.EndBlkNum, 10)
+		res.Summary = model.Summary
+	}
+	writeResponse(w, res)
+}
+
+//
+// get the hourly or daily transfer count and volume of an account or of the whole chain
+//
+func (h *transferHandler) getTransferStatistics(w http.ResponseWriter, r *http.Request)  {
+	logger := logs.GetLogger()
+	res := types.TransferStatisticsResponse{
+		Buckets: make([]*types.TransferStatisticsBucket, 0),
+	}
+	vCode,err,code := parseVerificationCode(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	query := &types.TransferStatisticsQuery{}
+	// account is optional, get statistics of the whole chain if it's not provided
+	acctName,err,code := parseOptionalParameterFromRequest(r, accountNameKey)
+	if err == nil && (utils.CheckIsNotEmptyStr(acctName) || isParameterProvided(r, accountNameKey)) {
+		acctName,err,code = parseAccountName(r)
+	}
+	if err == nil && utils.CheckIsNotEmptyStr(acctName) {
+		query.Account = acctName
+		query.Direction = types.TxDirectionAll
+		if dirStr,_,_ := parseOptionalParameterFromRequest(r, txDirectionKey); utils.CheckIsNotEmptyStr(dirStr) {
+			query.Direction,err,code = parseTxDirection(r)
+		}
+	}
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	interval,err,code := parseParameterFromRequest(r, intervalKey)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	var maxBuckets int
+	switch interval {
+	case hourInterval:
+		query.Interval = time.Hour
+		maxBuckets = config.GetStatisticsMaxHourBuckets()
+	case dayInterval:
+		query.Interval = 24 * time.Hour
+		maxBuckets = config.GetStatisticsMaxDayBuckets()
+	default:
+		res.Status = types.StatusParamInvalidError
+		res.Msg = fmt.Sprintf("interval %v is invalid, it should be %v or %v", interval, hourInterval, dayInterval)
+		writeResponse(w, res)
+		return
+	}
+	startTime,endTime,err,code := parseTimeRangeParams(r)
+	if err == nil && (startTime == nil || endTime == nil) {
+		err = errors.New(fmt.Sprintf("lack parameter %v or %v", startTimeKey, endTimeKey))
+		code = types.StatusLackParamError
+	}
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	// limit the time range, so it can't scan the whole table
+	if endTime.Sub(startTime.Truncate(query.Interval)) > time.Duration(maxBuckets) * query.Interval {
+		res.Status = types.StatusParamInvalidError
+		res.Msg = fmt.Sprintf("time range is too large, at most %v buckets of %v", maxBuckets, interval)
+		writeResponse(w, res)
+		return
+	}
+	query.StartTime = *startTime
+	query.EndTime = *endTime
 
-	logger.Infof("getTransferHistoryByBlock: block is:%v, account is:%v, verification code is:%v", blkNum, paramsInfo.account, paramsInfo.verificationCode)
-	isSender := false
-	if paramsInfo.txDirection != types.TxDirectionSend {
-		isSender = false
+	logger.Infof("getTransferStatistics: account is:%v, direction is:%v, interval is:%v, start time is:%v, end time is:%v, verification code is:%v", query.Account, query.Direction, interval, startTime, endTime, vCode)
+	ctx,cancel := newQueryContext(r, "getTransferStatistics")
+	defer cancel()
+	model := h.repo.GetTransferStatistics(ctx, query)
+	if model.Err != nil {
+		res.Status = model.ErrCode
+		res.Msg = model.Err.Error()
+	} else {
+		res.Status = types.StatusSuccess
+		res.HeadBlockHeight = strconv.FormatUint(model.Lib, 10)
+		res.MaxBlockHeight = strconv.FormatUint(coveredBlockHeight(model.Lib, model.MaxQueryBlkNum, false), 10)
+		res.IndexedBlockHeight = strconv.FormatUint(model.MaxQueryBlkNum, 10)
+		res.Buckets = model.Buckets
+	}
+	writeResponse(w, res)
+}
+
+//
+// get transfer record by operation id, or all the transfer records of a trx if isTrxHash is true
+//
+func (h *transferHandler) getTransferByOperation(w http.ResponseWriter, r *http.Request, isTrxHash bool)  {
+	logger := logs.GetLogger()
+	res := types.TransferByOperationResponse{
+		List: make([]*types.TransferRecord,0),
+	}
+	vCode,err,code := parseVerificationCode(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	idKey := operationIdKey
+	if isTrxHash {
+		idKey = trxHashKey
 	}
-	model := db.GetUserTransferRecordByBlock(blkNum, paramsInfo.account, isSender)
+	id,err,code := parseParameterFromRequest(r, idKey)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+
+	logger.Infof("getTransferByOperation: %v is:%v, verification code is:%v", idKey, id, vCode)
+	api := "getTransferByOperation"
+	if isTrxHash {
+		api = "getTransferByTrx"
+	}
+	ctx,cancel := newQueryContext(r, api)
+	defer cancel()
+	model := h.repo.GetTransferRecordByOperation(ctx, id, isTrxHash)
 	if model.Err != nil {
 		res.Status = model.ErrCode
 		res.Msg = model.Err.Error()
 	} else {
 		res.Status = types.StatusSuccess
+		res.HeadBlockHeight = strconv.FormatUint(model.Lib, 10)
 		if len(model.List) > 0 {
 			res.List = model.List
+			res.Irreversible = true
+			for _,rec := range model.List {
+				res.Irreversible = res.Irreversible && rec.Irreversible
+			}
+		}
+	}
+	writeResponse(w, res)
+}
+
+// get the health and block lag of the cos observe node dbs, only for admin
+func (h *transferHandler) getNodeStatus(w http.ResponseWriter, r *http.Request)  {
+	logger := logs.GetLogger()
+	res := types.NodeStatusResponse{}
+	vCode,err,code := parseAdminVerificationCode(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	logger.Infof("getNodeStatus: verification code is:%v", vCode)
+	res.Status = types.StatusSuccess
+	res.List = make([]*types.FullNodeStatus, 0)
+	if provider,ok := h.repo.(db.NodeStatusProvider); ok {
+		if list := provider.GetNodeStatus(); list != nil {
+			res.List = list
 		}
 	}
 	writeResponse(w, res)
 }
 
+// get the hit and miss count of the query cache, only for admin
+func (h *transferHandler) getCacheStatus(w http.ResponseWriter, r *http.Request)  {
+	logger := logs.GetLogger()
+	res := types.QueryCacheStatusResponse{}
+	vCode,err,code := parseAdminVerificationCode(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	logger.Infof("getCacheStatus: verification code is:%v", vCode)
+	res.Status = types.StatusSuccess
+	res.Cache = &types.QueryCacheStatus{}
+	if provider,ok := h.repo.(db.CacheStatusProvider); ok {
+		res.Cache = provider.GetCacheStatus()
+	}
+	writeResponse(w, res)
+}
+
+// get the context of the db queries of a request, which is done when the query deadline of api is exceeded or the client disconnects
+func newQueryContext(r *http.Request, api string) (context.Context,context.CancelFunc) {
+	return context.WithTimeout(r.Context(), config.GetQueryTimeout(api))
+}
+
+// get the last block covered by a response, records above lib are only included if they are asked for
+func coveredBlockHeight(lib, maxBlkNum uint64, includeReversible bool) uint64 {
+	if !includeReversible && lib < maxBlkNum {
+		return lib
+	}
+	return maxBlkNum
+}
+
 func writeResponse(w http.ResponseWriter, data interface{}) {
 	js, err := json.Marshal(data)
 	if err != nil {
@@ -135,56 +617,135 @@ func writeResponse(w http.ResponseWriter, data interface{}) {
 
 func parseHistoryParams(r *http.Request) historyParamsModel {
 	model := historyParamsModel{}
-	logger := logs.GetLogger()
 	//Get Verification Code
-	vCode,err,code := parseParameterFromRequest(r, verificationCodeKey)
+	vCode,err,code := parseVerificationCode(r)
 	if err != nil {
 		model.err = err
 		model.errCode = code
 		return model
 	}
-	if !config.CheckIsValidVerificationCode(vCode) {
-		msg := fmt.Sprintf("verification code %v is invalid", vCode)
-		model.err = errors.New(msg)
-		model.errCode = types.StatusParamVerificationCodeInvalidError
+
+	//Get transfer direction
+	dir,err,code := parseTxDirection(r)
+	if err != nil {
+		model.err = err
+		model.errCode = code
 		return model
 	}
 
-	//Get transfer direction
-	dirStr,err,code := parseParameterFromRequest(r, txDirectionKey)
+	// get account param
+	acctName,err,code := parseAccountName(r)
 	if err != nil {
 		model.err = err
 		model.errCode = code
 		return model
 	}
+	model.account = acctName
+	model.txDirection = dir
+	model.verificationCode = vCode
+	return model
+}
+
+// get account name and check whether it is valid
+func parseAccountName(r *http.Request) (string,error,int) {
+	logger := logs.GetLogger()
+	acctName,err,code := parseParameterFromRequest(r, accountNameKey)
+	// an empty account name is invalid, not missing
+	if err != nil && !(code == types.StatusLackParamError && isParameterProvided(r, accountNameKey)) {
+		return "",err,code
+	}
+	if !utils.CheckIsValidAccountName(acctName) {
+		logger.Errorf("parseAccountName: account name %q is invalid", acctName)
+		msg := fmt.Sprintf("account name %q is invalid", acctName)
+		return "",errors.New(msg),types.StatusParamInvalidError
+	}
+	return acctName,nil,0
+}
+
+// get transfer direction and check whether it is valid
+func parseTxDirection(r *http.Request) (int,error,int) {
+	logger := logs.GetLogger()
+	dirStr,err,code := parseParameterFromRequest(r, txDirectionKey)
+	if err != nil {
+		return 0,err,code
+	}
 
 	dir,err := strconv.Atoi(dirStr)
 	if err != nil {
 		msg := fmt.Sprintf("fail to parse transfer direction %v", dirStr)
-		model.err = errors.New(msg)
-		model.errCode = types.StatusGetTransferRecordError
-		return model
+		return 0,errors.New(msg),types.StatusGetTransferRecordError
 	}
-	if dir != types.TxDirectionSend && dir != types.TxDirectionReceive {
+	if dir != types.TxDirectionSend && dir != types.TxDirectionReceive && dir != types.TxDirectionAll {
 		// invalid transfer direction
-		logger.Errorf("getTransferHistory: transfer direction %v is invalid", dir)
+		logger.Errorf("parseTxDirection: transfer direction %v is invalid", dir)
 		msg := fmt.Sprintf("transfer direction %v is invalid", dir)
-		model.err = errors.New(msg)
-		model.errCode = types.StatusParamTransferDirectionInvalidError
-		return model
+		return 0,errors.New(msg),types.StatusParamTransferDirectionInvalidError
 	}
+	return dir,nil,0
+}
 
-	// get account param
-	acctName,err,code := parseParameterFromRequest(r, accountNameKey)
+// parse the accounts param of batch query, the format is "account1:start1,account2:start2"
+func parseBatchAccounts(r *http.Request) ([]*types.AccountStartBlock,error,int) {
+	str,err,code := parseParameterFromRequest(r, accountsKey)
 	if err != nil {
-		model.err = err
-		model.errCode = code
-		return model
+		return nil,err,code
 	}
-	model.account = acctName
-	model.txDirection = dir
-	model.verificationCode = vCode
-	return model
+	var (
+		list []*types.AccountStartBlock
+		seen = make(map[string]bool)
+	)
+	for _,item := range strings.Split(str, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) != 2 {
+			msg := fmt.Sprintf("invalid account item %q, it should be account:start", item)
+			return nil,errors.New(msg),types.StatusParamInvalidError
+		}
+		if !utils.CheckIsValidAccountName(parts[0]) {
+			msg := fmt.Sprintf("account name %q is invalid", parts[0])
+			return nil,errors.New(msg),types.StatusParamInvalidError
+		}
+		if seen[parts[0]] {
+			msg := fmt.Sprintf("duplicate account %v", parts[0])
+			return nil,errors.New(msg),types.StatusParamInvalidError
+		}
+		start,err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			msg := fmt.Sprintf("fail to parse start block of account %v,%v", parts[0], err)
+			return nil,errors.New(msg),types.StatusParamInvalidError
+		}
+		seen[parts[0]] = true
+		list = append(list, &types.AccountStartBlock{Account: parts[0], Start: start})
+	}
+	if len(list) > maxBatchAccountCount {
+		msg := fmt.Sprintf("too many accounts, the max count is %v", maxBatchAccountCount)
+		return nil,errors.New(msg),types.StatusParamInvalidError
+	}
+	return list,nil,0
+}
+
+// get verification code and check whether it is valid
+func parseVerificationCode(r *http.Request) (string,error,int) {
+	vCode,err,code := parseParameterFromRequest(r, verificationCodeKey)
+	if err != nil {
+		return "",err,code
+	}
+	if !config.CheckIsValidVerificationCode(vCode) {
+		msg := fmt.Sprintf("verification code %v is invalid", vCode)
+		return "",errors.New(msg),types.StatusParamVerificationCodeInvalidError
+	}
+	return vCode,nil,0
+}
+
+func parseAdminVerificationCode(r *http.Request) (string,error,int) {
+	vCode,err,code := parseParameterFromRequest(r, verificationCodeKey)
+	if err != nil {
+		return "",err,code
+	}
+	if !config.CheckIsValidAdminVerificationCode(vCode) {
+		msg := fmt.Sprintf("admin verification code %v is invalid", vCode)
+		return "",errors.New(msg),types.StatusParamVerificationCodeInvalidError
+	}
+	return vCode,nil,0
 }
 
 func parseBlockNumberByKey(r *http.Request, pKey string) (uint64,error,int) {
@@ -201,6 +762,193 @@ func parseBlockNumberByKey(r *http.Request, pKey string) (uint64,error,int) {
 	return blkNum,nil,0
 }
 
+func parseOptionalBlockNumberByKey(r *http.Request, pKey string) (uint64,error,int) {
+	blkStr,err,code := parseOptionalParameterFromRequest(r, pKey)
+	if err != nil || !utils.CheckIsNotEmptyStr(blkStr) {
+		return 0,err,code
+	}
+	return parseBlockNumberByKey(r, pKey)
+}
+
+// parse the optional startTime and endTime params, the time range is [startTime, endTime)
+func parseTimeRangeParams(r *http.Request) (*time.Time,*time.Time,error,int) {
+	var startTime, endTime *time.Time
+	for _,key := range []string{startTimeKey, endTimeKey} {
+		str,err,code := parseOptionalParameterFromRequest(r, key)
+		if err != nil {
+			return nil,nil,err,code
+		}
+		if !utils.CheckIsNotEmptyStr(str) {
+			continue
+		}
+		t,err := utils.ParseTimeParam(str)
+		if err != nil {
+			return nil,nil,err,types.StatusParamInvalidError
+		}
+		if key == startTimeKey {
+			startTime = &t
+		} else {
+			endTime = &t
+		}
+	}
+	if startTime != nil && endTime != nil && !startTime.Before(*endTime) {
+		msg := fmt.Sprintf("start time %v should be before end time %v", startTime, endTime)
+		return nil,nil,errors.New(msg),types.StatusParamInvalidError
+	}
+	return startTime,endTime,nil,0
+}
+
+// parse the optional counterparty, amount and memo filters
+func parseFilterParams(r *http.Request) (types.TransferRecordFilter,error,int) {
+	filter := types.TransferRecordFilter{}
+	cp,err,code := parseOptionalParameterFromRequest(r, counterpartyKey)
+	if err != nil {
+		return filter,err,code
+	}
+	if utils.CheckIsNotEmptyStr(cp) && !utils.CheckIsValidAccountName(cp) {
+		msg := fmt.Sprintf("counterparty %q is invalid", cp)
+		return filter,errors.New(msg),types.StatusParamInvalidError
+	}
+	filter.Counterparty = cp
+	for _,key := range []string{minAmountKey, maxAmountKey} {
+		str,err,code := parseOptionalParameterFromRequest(r, key)
+		if err != nil {
+			return filter,err,code
+		}
+		if !utils.CheckIsNotEmptyStr(str) {
+			continue
+		}
+		amount,err := strconv.ParseUint(str, 10, 64)
+		if err != nil {
+			msg := fmt.Sprintf("fail to parse %v %v", key, str)
+			return filter,errors.New(msg),types.StatusParamInvalidError
+		}
+		if key == minAmountKey {
+			filter.MinAmount = &amount
+		} else {
+			filter.MaxAmount = &amount
+		}
+	}
+	if filter.MinAmount != nil && filter.MaxAmount != nil && *filter.MinAmount > *filter.MaxAmount {
+		msg := fmt.Sprintf("min amount %v is greater than max amount %v", *filter.MinAmount, *filter.MaxAmount)
+		return filter,errors.New(msg),types.StatusParamInvalidError
+	}
+	if filter.Memo,err,code = parseOptionalParameterFromRequest(r, memoKey); err != nil {
+		return filter,err,code
+	}
+	if filter.MemoPrefix,err,code = parseOptionalParameterFromRequest(r, memoPrefixKey); err != nil {
+		return filter,err,code
+	}
+	return filter,nil,0
+}
+
+// get how long to wait for the new records, 0 if the request doesn't wait
+func parseWait(r *http.Request) (time.Duration,error,int) {
+	waitStr,err,code := parseOptionalParameterFromRequest(r, waitKey)
+	if err != nil || !utils.CheckIsNotEmptyStr(waitStr) {
+		return 0,err,code
+	}
+	seconds,err := strconv.Atoi(waitStr)
+	if err != nil || seconds < 0 || seconds > maxWaitSeconds {
+		msg := fmt.Sprintf("wait %v is invalid, it should be in range [0, %v]", waitStr, maxWaitSeconds)
+		return 0,errors.New(msg),types.StatusParamInvalidError
+	}
+	return time.Duration(seconds) * time.Second,nil,0
+}
+
+// get the sequence number or id after which a page starts, and the max count of items in the page
+func parseAfterParams(r *http.Request, defaultLimit int) (uint64,int,error,int) {
+	afterStr,err,code := parseOptionalParameterFromRequest(r, afterKey)
+	if err != nil {
+		return 0,0,err,code
+	}
+	limitStr,err,code := parseOptionalParameterFromRequest(r, limitKey)
+	if err != nil {
+		return 0,0,err,code
+	}
+	var after uint64
+	limit := defaultLimit
+	if utils.CheckIsNotEmptyStr(afterStr) {
+		if after,err = strconv.ParseUint(afterStr, 10, 64); err != nil {
+			return 0,0,errors.New(fmt.Sprintf("after %v is invalid", afterStr)),types.StatusParamInvalidError
+		}
+	}
+	if utils.CheckIsNotEmptyStr(limitStr) {
+		limit,err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxQueryLimit {
+			msg := fmt.Sprintf("limit %v is invalid, it should be in range [1, %v]", limitStr, maxQueryLimit)
+			return 0,0,errors.New(msg),types.StatusParamInvalidError
+		}
+	}
+	return after,limit,nil,0
+}
+
+// parse the limit and cursor params of paging query.
+// to stay compatible with old clients, there is no limit if neither of them is provided
+func parsePageParams(r *http.Request) (int,*types.TransferCursor,error,int) {
+	limitStr,err,code := parseOptionalParameterFromRequest(r, limitKey)
+	if err != nil {
+		return 0,nil,err,code
+	}
+	cursorStr,err,code := parseOptionalParameterFromRequest(r, cursorKey)
+	if err != nil {
+		return 0,nil,err,code
+	}
+	var (
+		limit int
+		cursor *types.TransferCursor
+	)
+	if utils.CheckIsNotEmptyStr(limitStr) {
+		limit,err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxQueryLimit {
+			msg := fmt.Sprintf("limit %v is invalid, it should be in range [1, %v]", limitStr, maxQueryLimit)
+			return 0,nil,errors.New(msg),types.StatusParamInvalidError
+		}
+	}
+	if utils.CheckIsNotEmptyStr(cursorStr) {
+		cursor,err = utils.DecodeTransferCursor(cursorStr)
+		if err != nil {
+			return 0,nil,err,types.StatusParamInvalidError
+		}
+		if limit == 0 {
+			limit = defaultQueryLimit
+		}
+	}
+	return limit,cursor,nil,0
+}
+
+// parse whether to include the records above lib, it's false by default
+func parseIncludeReversible(r *http.Request) (bool,error,int) {
+	str,err,code := parseOptionalParameterFromRequest(r, includeReversibleKey)
+	if err != nil || !utils.CheckIsNotEmptyStr(str) {
+		return false,err,code
+	}
+	include,err := strconv.ParseBool(str)
+	if err != nil {
+		msg := fmt.Sprintf("%v %v is invalid", includeReversibleKey, str)
+		return false,errors.New(msg),types.StatusParamInvalidError
+	}
+	return include,nil,0
+}
+
+// get optional parameter, return empty string if the parameter is not provided
+func parseOptionalParameterFromRequest(r *http.Request, parameter string) (string,error,int) {
+	val,err,code := parseParameterFromRequest(r, parameter)
+	if err != nil && code == types.StatusLackParamError {
+		return "",nil,0
+	}
+	return val,err,code
+}
+
+// whether the parameter is in the request, even if its value is empty
+func isParameterProvided(r *http.Request, parameter string) bool {
+	if r == nil || r.ParseForm() != nil {
+		return false
+	}
+	_,ok := r.Form[parameter]
+	return ok
+}
+
 func parseParameterFromRequest(r *http.Request, parameter string) (string,error,int) {
 	var (
 		err error
@@ -215,7 +963,10 @@ func parseParameterFromRequest(r *http.Request, parameter string) (string,error,
 	if reqMethod == http.MethodPost || reqMethod == http.MethodGet {
 		if reqMethod == http.MethodGet {
 			queryForm, err := url.ParseQuery(r.URL.RawQuery)
-			if err == nil && len(queryForm[parameter]) > 0  && utils.CheckIsNotEmptyStr(queryForm[parameter][0]){
+			if err != nil {
+				return "", errors.New(fmt.Sprintf("invalid query, %v", err)), types.StatusParamInvalidError
+			}
+			if len(queryForm[parameter]) > 0  && utils.CheckIsNotEmptyStr(queryForm[parameter][0]){
 				return queryForm[parameter][0], err, http.StatusOK
 			} else {
 				return "", errors.New(fmt.Sprintf("lack parameter %v", parameter)), types.StatusLackParamError
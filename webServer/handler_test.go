@@ -0,0 +1,545 @@
+package webServer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/coschain/contentos-go/app/plugins"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"transfer_history/config"
+	"transfer_history/db"
+	"transfer_history/types"
+	"transfer_history/utils"
+)
+
+const (
+	testVerificationCode = "c"
+	testAdminVerificationCode = "admin"
+)
+
+// the config is loaded once in a process, it has the verification codes of the tests and a sqlite webhook outbox in a temp dir
+func TestMain(m *testing.M) {
+	dir,err := ioutil.TempDir("", "webServer")
+	if err != nil {
+		panic(err)
+	}
+	cfg := fmt.Sprintf(`{"dev": {
+		"verificationCodeList": [%q], "adminVerificationCodeList": [%q],
+		"webhook": {"outbox": {"fullNodeDbDriver": "sqlite3", "fullNodeDbName": %q}}
+	}}`, testVerificationCode, testAdminVerificationCode, filepath.Join(dir, "outbox.db"))
+	path := filepath.Join(dir, "config.json")
+	if err := ioutil.WriteFile(path, []byte(cfg), 0600); err != nil {
+		panic(err)
+	}
+	config.SetConfigEnv(config.EnvDev)
+	if err := config.LoadExchangeTransferHistoryConfig(path); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// get a server of the http api on repo, it's closed when the test finishes
+func newTestServer(t *testing.T, repo db.TransferRepository) *httptest.Server {
+	server := httptest.NewServer(NewApiHandler(repo, openTestAcks(t, filepath.Join(t.TempDir(), "ack.db")), nil, nil))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// open the sqlite ack db of subscriptions at path, it's closed when the test finishes
+func openTestAcks(t *testing.T, path string) *SubscriptionAcks {
+	t.Helper()
+	acks,err := openSubscriptionAcks(&config.DbConfig{Driver: config.DbDriverSqlite, DbName: path})
+	if err != nil {
+		t.Fatalf("open ack db: %v", err)
+	}
+	t.Cleanup(func() {
+		acks.Close()
+	})
+	return acks
+}
+
+// get api of server with params and the verification code, and decode the json response into res
+func getApi(t *testing.T, server *httptest.Server, api string, params url.Values, res interface{}) {
+	t.Helper()
+	if params.Get(verificationCodeKey) == "" {
+		params.Set(verificationCodeKey, testVerificationCode)
+	}
+	resp,err := http.Get(server.URL + api + "?" + params.Encode())
+	if err != nil {
+		t.Fatalf("get %v: %v", api, err)
+	}
+	decodeResponse(t, resp, res)
+}
+
+// decode the json response into res and close it
+func decodeResponse(t *testing.T, resp *http.Response, res interface{}) {
+	t.Helper()
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		t.Fatalf("decode response of %v: %v", resp.Request.URL.Path, err)
+	}
+}
+
+// invalid page params are rejected before reaching the db
+func TestInvalidPageParams(t *testing.T) {
+	server := newTestServer(t, db.NewMemoryRepository())
+	for _,page := range []url.Values{
+		{limitKey: {"0"}},
+		{limitKey: {"-1"}},
+		{limitKey: {"10001"}},
+		{limitKey: {"ten"}},
+		{cursorKey: {"not a cursor!"}},
+		{cursorKey: {utils.EncodeTransferCursor(&types.TransferCursor{BlockHeight: 10})}},
+		{cursorKey: {"MTA"}},
+		{limitKey: {"10"}, cursorKey: {"MTA"}},
+	} {
+		params := url.Values{accountNameKey: {"alice1"}, txDirectionKey: {"2"}, startBlockNumKey: {"0"}}
+		for k,v := range page {
+			params[k] = v
+		}
+		res := &types.BaseResponse{}
+		getApi(t, server, getTransferHistoryUrl, params, res)
+		if res.Status != types.StatusParamInvalidError {
+			t.Errorf("page params %v: status is %v, msg is %v", page, res.Status, res.Msg)
+		}
+	}
+}
+
+// direction 3 passes the param check as well as the others
+func TestDirectionParam(t *testing.T) {
+	server := newTestServer(t, db.NewMemoryRepository())
+	for dir,status := range map[string]int{
+		"1": types.StatusSuccess,
+		"2": types.StatusSuccess,
+		"3": types.StatusSuccess,
+		"0": types.StatusParamTransferDirectionInvalidError,
+		"4": types.StatusParamTransferDirectionInvalidError,
+	} {
+		res := &types.BaseResponse{}
+		params := url.Values{accountNameKey: {"alice1"}, txDirectionKey: {dir}, startBlockNumKey: {"0"}}
+		getApi(t, server, getTransferHistoryUrl, params, res)
+		if res.Status != status {
+			t.Errorf("direction %v: status is %v, msg is %v", dir, res.Status, res.Msg)
+		}
+	}
+}
+
+func TestTimeRangeParams(t *testing.T) {
+	server := newTestServer(t, db.NewMemoryRepository())
+	for _,c := range []struct {
+		params url.Values
+		status int
+	}{
+		{url.Values{startTimeKey: {"yesterday"}}, types.StatusParamInvalidError},
+		{url.Values{startBlockNumKey: {"0"}, endTimeKey: {"2026-10-01"}}, types.StatusParamInvalidError},
+		{url.Values{startTimeKey: {"2026-10-02T00:00:00Z"}, endTimeKey: {"2026-10-01T00:00:00Z"}}, types.StatusParamInvalidError},
+		{url.Values{startTimeKey: {"1700000000"}, endTimeKey: {"1700000000"}}, types.StatusParamInvalidError},
+		// the start block height is only required without start time
+		{url.Values{endTimeKey: {"2026-10-01T00:00:00Z"}}, types.StatusLackParamError},
+		{url.Values{startTimeKey: {"2026-10-01T00:00:00Z"}}, types.StatusSuccess},
+		{url.Values{startTimeKey: {"2026-10-01T00:00:00Z"}, startBlockNumKey: {"x"}}, types.StatusParamInvalidError},
+	} {
+		params := url.Values{accountNameKey: {"alice1"}, txDirectionKey: {"2"}}
+		for k,v := range c.params {
+			params[k] = v
+		}
+		res := &types.BaseResponse{}
+		getApi(t, server, getTransferHistoryUrl, params, res)
+		if res.Status != c.status {
+			t.Errorf("params %v: status is %v, want %v, msg is %v", c.params, res.Status, c.status, res.Msg)
+		}
+	}
+}
+
+func TestFilterParams(t *testing.T) {
+	server := newTestServer(t, db.NewMemoryRepository())
+	for _,c := range []struct {
+		params url.Values
+		status int
+	}{
+		{url.Values{counterpartyKey: {"Bob"}}, types.StatusParamInvalidError},
+		{url.Values{counterpartyKey: {"bob%"}}, types.StatusParamInvalidError},
+		{url.Values{minAmountKey: {"-1"}}, types.StatusParamInvalidError},
+		{url.Values{maxAmountKey: {"1.5"}}, types.StatusParamInvalidError},
+		{url.Values{minAmountKey: {"10"}, maxAmountKey: {"9"}}, types.StatusParamInvalidError},
+		// valid filters reach the repository
+		{url.Values{minAmountKey: {"10"}, maxAmountKey: {"10"}, counterpartyKey: {"bobbob1"}}, types.StatusSuccess},
+		{url.Values{memoKey: {"50% off"}, memoPrefixKey: {"order_"}}, types.StatusSuccess},
+	} {
+		params := url.Values{accountNameKey: {"alice1"}, txDirectionKey: {"2"}, startBlockNumKey: {"0"}}
+		for k,v := range c.params {
+			params[k] = v
+		}
+		res := &types.BaseResponse{}
+		getApi(t, server, getTransferHistoryUrl, params, res)
+		if res.Status != c.status {
+			t.Errorf("params %v: status is %v, want %v, msg is %v", c.params, res.Status, c.status, res.Msg)
+		}
+	}
+}
+
+func TestOperationParams(t *testing.T) {
+	server := newTestServer(t, db.NewMemoryRepository())
+	for _,c := range []struct {
+		api string
+		params url.Values
+		status int
+	}{
+		{getTransferByOperationUrl, url.Values{}, types.StatusLackParamError},
+		{getTransferByOperationUrl, url.Values{operationIdKey: {"op1_0"}}, types.StatusParamInvalidError},
+		{getTransferByOperationUrl, url.Values{operationIdKey: {fmt.Sprintf("%064x", 1)}}, types.StatusParamInvalidError},
+		{getTransferByOperationUrl, url.Values{operationIdKey: {fmt.Sprintf("%064x_0", 1)}, verificationCodeKey: {"wrong"}}, types.StatusParamVerificationCodeInvalidError},
+		{getTransferByTrxUrl, url.Values{}, types.StatusLackParamError},
+		{getTransferByTrxUrl, url.Values{trxHashKey: {fmt.Sprintf("%064x_0", 1)}}, types.StatusParamInvalidError},
+		{getTransferByTrxUrl, url.Values{trxHashKey: {strings.Repeat("%", 64)}}, types.StatusParamInvalidError},
+	} {
+		res := &types.BaseResponse{}
+		getApi(t, server, c.api, c.params, res)
+		if res.Status != c.status {
+			t.Errorf("%v with %v: status is %v, want %v, msg is %v", c.api, c.params, res.Status, c.status, res.Msg)
+		}
+	}
+}
+
+func TestBatchParams(t *testing.T) {
+	server := newTestServer(t, db.NewMemoryRepository())
+	many := make([]string, maxBatchAccountCount + 1)
+	for i := range many {
+		many[i] = fmt.Sprintf("acct%04d:0", i)
+	}
+	for _,c := range []struct {
+		params url.Values
+		status int
+	}{
+		{url.Values{}, types.StatusLackParamError},
+		{url.Values{accountsKey: {"alice1"}}, types.StatusParamInvalidError},
+		{url.Values{accountsKey: {"alice1:x"}}, types.StatusParamInvalidError},
+		{url.Values{accountsKey: {"alice%:0"}}, types.StatusParamInvalidError},
+		{url.Values{accountsKey: {"alice1:0,alice1:5"}}, types.StatusParamInvalidError},
+		{url.Values{accountsKey: {strings.Join(many, ",")}}, types.StatusParamInvalidError},
+		{url.Values{accountsKey: {"alice1:0"}, limitKey: {"0"}}, types.StatusParamInvalidError},
+		{url.Values{accountsKey: {"alice1:0"}, cursorKey: {"MTA"}}, types.StatusParamInvalidError},
+		// valid params reach the repository
+		{url.Values{accountsKey: {"alice1:0, bobbob1:10"}, limitKey: {"10"}}, types.StatusSuccess},
+	} {
+		params := url.Values{txDirectionKey: {"3"}, verificationCodeKey: {testVerificationCode}}
+		for k,v := range c.params {
+			params[k] = v
+		}
+		resp,err := http.PostForm(server.URL + getBatchTransferHistoryUrl, params)
+		if err != nil {
+			t.Fatalf("post %v: %v", getBatchTransferHistoryUrl, err)
+		}
+		res := &types.BaseResponse{}
+		decodeResponse(t, resp, res)
+		if res.Status != c.status {
+			t.Errorf("params %v: status is %v, want %v, msg is %v", c.params, res.Status, c.status, res.Msg)
+		}
+	}
+}
+
+func TestSummaryParams(t *testing.T) {
+	server := newTestServer(t, db.NewMemoryRepository())
+	for _,c := range []struct {
+		params url.Values
+		status int
+	}{
+		{url.Values{}, types.StatusLackParamError},
+		{url.Values{accountNameKey: {""}}, types.StatusParamInvalidError},
+		{url.Values{accountNameKey: {"alice%"}}, types.StatusParamInvalidError},
+		{url.Values{accountNameKey: {"alice1"}, startBlockNumKey: {"x"}}, types.StatusParamInvalidError},
+		{url.Values{accountNameKey: {"alice1"}, startBlockNumKey: {"10"}, endBlockNumKey: {"9"}}, types.StatusParamInvalidError},
+		{url.Values{accountNameKey: {"alice1"}, startTimeKey: {"2026-10-02T00:00:00Z"}, endTimeKey: {"2026-10-01T00:00:00Z"}}, types.StatusParamInvalidError},
+		// valid params reach the repository
+		{url.Values{accountNameKey: {"alice1"}}, types.StatusSuccess},
+		{url.Values{accountNameKey: {"alice1"}, startBlockNumKey: {"10"}, endBlockNumKey: {"10"}}, types.StatusSuccess},
+	} {
+		res := &types.BaseResponse{}
+		getApi(t, server, getTransferSummaryUrl, c.params, res)
+		if res.Status != c.status {
+			t.Errorf("params %v: status is %v, want %v, msg is %v", c.params, res.Status, c.status, res.Msg)
+		}
+	}
+}
+
+func TestStatisticsParams(t *testing.T) {
+	server := newTestServer(t, db.NewMemoryRepository())
+	day := url.Values{intervalKey: {dayInterval}, startTimeKey: {"2026-10-01T00:00:00Z"}, endTimeKey: {"2026-10-08T00:00:00Z"}}
+	for _,c := range []struct {
+		params url.Values
+		status int
+	}{
+		{url.Values{startTimeKey: {"2026-10-01T00:00:00Z"}, endTimeKey: {"2026-10-02T00:00:00Z"}}, types.StatusLackParamError},
+		{url.Values{intervalKey: {"minute"}, startTimeKey: {"2026-10-01T00:00:00Z"}, endTimeKey: {"2026-10-02T00:00:00Z"}}, types.StatusParamInvalidError},
+		{url.Values{intervalKey: {hourInterval}, startTimeKey: {"2026-10-01T00:00:00Z"}}, types.StatusLackParamError},
+		{url.Values{intervalKey: {hourInterval}, startTimeKey: {"2026-10-02T00:00:00Z"}, endTimeKey: {"2026-10-01T00:00:00Z"}}, types.StatusParamInvalidError},
+		// at most 744 hourly buckets and 366 daily buckets by default
+		{url.Values{intervalKey: {hourInterval}, startTimeKey: {"2026-10-01T00:00:00Z"}, endTimeKey: {"2026-11-01T00:00:01Z"}}, types.StatusParamInvalidError},
+		{url.Values{intervalKey: {dayInterval}, startTimeKey: {"2026-01-01T00:00:00Z"}, endTimeKey: {"2027-01-02T00:00:01Z"}}, types.StatusParamInvalidError},
+		{url.Values{intervalKey: day[intervalKey], startTimeKey: day[startTimeKey], endTimeKey: day[endTimeKey], accountNameKey: {""}}, types.StatusParamInvalidError},
+		{url.Values{intervalKey: day[intervalKey], startTimeKey: day[startTimeKey], endTimeKey: day[endTimeKey], accountNameKey: {"alice%"}}, types.StatusParamInvalidError},
+		{url.Values{intervalKey: day[intervalKey], startTimeKey: day[startTimeKey], endTimeKey: day[endTimeKey], accountNameKey: {"alice1"}, txDirectionKey: {"4"}}, types.StatusParamTransferDirectionInvalidError},
+		// valid params reach the repository
+		{url.Values{intervalKey: {hourInterval}, startTimeKey: {"2026-10-01T00:00:00Z"}, endTimeKey: {"2026-11-01T00:00:00Z"}}, types.StatusSuccess},
+		{url.Values{intervalKey: day[intervalKey], startTimeKey: day[startTimeKey], endTimeKey: day[endTimeKey], accountNameKey: {"alice1"}, txDirectionKey: {"1"}}, types.StatusSuccess},
+	} {
+		res := &types.BaseResponse{}
+		getApi(t, server, getTransferStatisticsUrl, c.params, res)
+		if res.Status != c.status {
+			t.Errorf("params %v: status is %v, want %v, msg is %v", c.params, res.Status, c.status, res.Msg)
+		}
+	}
+}
+
+// a valid operation id of the transfer in block blkNum
+func testOperationId(blkNum uint64) string {
+	return fmt.Sprintf("%064x_0", blkNum)
+}
+
+// a repository with transfers of alice1 in block 10, 20 and 30, and lib 25
+func newTestRepository() *db.MemoryRepository {
+	repo := db.NewMemoryRepository()
+	for _,blkNum := range []uint64{10, 20, 30} {
+		repo.AddTransferRecord(&plugins.TransferRecord{
+			BlockHeight: blkNum,
+			OperationId: testOperationId(blkNum),
+			From: "initminer",
+			To: "alice1",
+			Amount: blkNum,
+			BlockTime: time.Unix(int64(blkNum) * 3, 0),
+		})
+	}
+	repo.SetLib(25)
+	return repo
+}
+
+func TestGetTransferHistory(t *testing.T) {
+	server := newTestServer(t, newTestRepository())
+	params := url.Values{accountNameKey: {"alice1"}, txDirectionKey: {"3"}, startBlockNumKey: {"0"}}
+	res := &types.TransferHistoryResponse{}
+	getApi(t, server, getTransferHistoryUrl, params, res)
+	if res.Status != types.StatusSuccess {
+		t.Fatalf("status is %v, msg is %v", res.Status, res.Msg)
+	}
+	if len(res.List) != 2 || res.HeadBlockHeight != "25" || res.MaxBlockHeight != "25" || res.IndexedBlockHeight != "30" {
+		t.Fatalf("got %v records, head %v, max %v, indexed %v", len(res.List), res.HeadBlockHeight, res.MaxBlockHeight, res.IndexedBlockHeight)
+	}
+
+	params.Set(includeReversibleKey, "true")
+	res = &types.TransferHistoryResponse{}
+	getApi(t, server, getTransferHistoryUrl, params, res)
+	if len(res.List) != 3 || res.MaxBlockHeight != "30" || res.List[2].Irreversible {
+		t.Fatalf("got %v records with reversible ones, max %v", len(res.List), res.MaxBlockHeight)
+	}
+
+	// page through the irreversible records
+	params.Del(includeReversibleKey)
+	params.Set(limitKey, "1")
+	var ids []string
+	for i := 0; i < 3; i++ {
+		res = &types.TransferHistoryResponse{}
+		getApi(t, server, getTransferHistoryUrl, params, res)
+		if res.Status != types.StatusSuccess {
+			t.Fatalf("status is %v, msg is %v", res.Status, res.Msg)
+		}
+		for _,rec := range res.List {
+			ids = append(ids, rec.OperationId)
+		}
+		if !res.HasMore {
+			break
+		}
+		params.Set(cursorKey, res.NextCursor)
+	}
+	if len(ids) != 2 || ids[0] != testOperationId(10) || ids[1] != testOperationId(20) {
+		t.Fatalf("paged records are %v", ids)
+	}
+}
+
+func TestGetTransferHistoryOfBlockAndOperation(t *testing.T) {
+	server := newTestServer(t, newTestRepository())
+	block := &types.SingleBlockTransferHistoryResponse{}
+	getApi(t, server, getTransferHistoryInBlockUrl, url.Values{accountNameKey: {"alice1"}, txDirectionKey: {"2"}, singleBlockKey: {"20"}}, block)
+	if block.Status != types.StatusSuccess || len(block.List) != 1 || block.List[0].OperationId != testOperationId(20) {
+		t.Fatalf("block 20 is %+v", block)
+	}
+
+	op := &types.TransferByOperationResponse{}
+	getApi(t, server, getTransferByOperationUrl, url.Values{operationIdKey: {testOperationId(30)}}, op)
+	if op.Status != types.StatusSuccess || len(op.List) != 1 || op.Irreversible {
+		t.Fatalf("operation in block 30 is %+v", op)
+	}
+	op = &types.TransferByOperationResponse{}
+	getApi(t, server, getTransferByOperationUrl, url.Values{operationIdKey: {testOperationId(10)}}, op)
+	if op.Status != types.StatusSuccess || len(op.List) != 1 || !op.Irreversible {
+		t.Fatalf("operation in block 10 is %+v", op)
+	}
+}
+
+func TestGetTransferSummary(t *testing.T) {
+	server := newTestServer(t, newTestRepository())
+	res := &types.TransferSummaryResponse{}
+	getApi(t, server, getTransferSummaryUrl, url.Values{accountNameKey: {"alice1"}}, res)
+	if res.Status != types.StatusSuccess {
+		t.Fatalf("status is %v, msg is %v", res.Status, res.Msg)
+	}
+	// only the irreversible records are counted
+	if res.Summary.InCount != 2 || res.Summary.InAmount != "30" || res.MaxBlockHeight != "25" {
+		t.Fatalf("summary is %+v, max block height is %v", res.Summary, res.MaxBlockHeight)
+	}
+}
+
+func TestGetBatchTransferHistory(t *testing.T) {
+	repo := newTestRepository()
+	repo.AddTransferRecord(&plugins.TransferRecord{BlockHeight: 15, OperationId: testOperationId(15), From: "alice1", To: "bobbob1", Amount: 1, BlockTime: time.Unix(45, 0)})
+	server := newTestServer(t, repo)
+	params := url.Values{accountsKey: {"alice1:0,bobbob1:0"}, txDirectionKey: {"3"}, limitKey: {"2"}}
+	res := &types.BatchTransferHistoryResponse{}
+	getApi(t, server, getBatchTransferHistoryUrl, params, res)
+	if res.Status != types.StatusSuccess || !res.HasMore || len(res.Records["alice1"]) != 2 || len(res.Records["bobbob1"]) != 1 {
+		t.Fatalf("first page is %+v", res)
+	}
+	cursor,err := utils.DecodeTransferCursor(res.NextCursor)
+	if err != nil || cursor.OperationId != testOperationId(15) {
+		t.Fatalf("next cursor is %q, the error is %v", res.NextCursor, err)
+	}
+	params.Set(cursorKey, res.NextCursor)
+	res = &types.BatchTransferHistoryResponse{}
+	getApi(t, server, getBatchTransferHistoryUrl, params, res)
+	if res.Status != types.StatusSuccess || res.HasMore || len(res.Records["alice1"]) != 1 || res.Records["alice1"][0].OperationId != testOperationId(20) {
+		t.Fatalf("second page is %+v", res)
+	}
+}
+
+// a repository which reports the status of its nodes
+type nodeStatusRepository struct {
+	*db.MemoryRepository
+}
+
+func (repo *nodeStatusRepository) GetNodeStatus() []*types.FullNodeStatus {
+	return []*types.FullNodeStatus{{Host: "node0", Healthy: true, BlockHeight: 30}}
+}
+
+func TestGetNodeStatus(t *testing.T) {
+	// the node status is only for admin
+	server := newTestServer(t, &nodeStatusRepository{newTestRepository()})
+	res := &types.NodeStatusResponse{}
+	getApi(t, server, getNodeStatusUrl, url.Values{}, res)
+	if res.Status != types.StatusParamVerificationCodeInvalidError || len(res.List) != 0 {
+		t.Fatalf("node status without admin verification code is %+v", res)
+	}
+	res = &types.NodeStatusResponse{}
+	getApi(t, server, getNodeStatusUrl, url.Values{verificationCodeKey: {testAdminVerificationCode}}, res)
+	if res.Status != types.StatusSuccess || len(res.List) != 1 || res.List[0].Host != "node0" {
+		t.Fatalf("node status is %+v", res)
+	}
+
+	// a repository without nodes has an empty list
+	server = newTestServer(t, newTestRepository())
+	res = &types.NodeStatusResponse{}
+	getApi(t, server, getNodeStatusUrl, url.Values{verificationCodeKey: {testAdminVerificationCode}}, res)
+	if res.Status != types.StatusSuccess || res.List == nil || len(res.List) != 0 {
+		t.Fatalf("node status of memory repository is %+v", res)
+	}
+}
+
+// the queries of a request whose client is gone fail with the timeout status
+func TestCanceledRequest(t *testing.T) {
+	handler := NewApiHandler(newTestRepository(), nil, nil, nil)
+	params := url.Values{verificationCodeKey: {testVerificationCode}, accountNameKey: {"alice1"}, txDirectionKey: {"3"}, startBlockNumKey: {"0"}}
+	ctx,cancel := context.WithCancel(context.Background())
+	cancel()
+	r := httptest.NewRequest(http.MethodGet, getTransferHistoryUrl + "?" + params.Encode(), nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	res := &types.TransferHistoryResponse{}
+	if err := json.NewDecoder(w.Body).Decode(res); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if res.Status != types.StatusQueryTimeoutError || len(res.List) != 0 {
+		t.Fatalf("status is %v, msg is %v", res.Status, res.Msg)
+	}
+}
+
+// params of getTransferHistory which match no record of newTestRepository, the record in block 30 is above lib
+func testWaitParams(wait string) url.Values {
+	return url.Values{accountNameKey: {"alice1"}, txDirectionKey: {"3"}, startBlockNumKey: {"26"}, waitKey: {wait}}
+}
+
+func TestGetTransferHistoryWaitsForNewRecord(t *testing.T) {
+	repo := newTestRepository()
+	server := newTestServer(t, repo)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		// a new block without the records of alice1 doesn't end the wait
+		repo.AddTransferRecord(&plugins.TransferRecord{BlockHeight: 35, OperationId: testOperationId(35), From: "initminer", To: "bobbob1", Amount: 35, BlockTime: time.Unix(35 * 3, 0)})
+		time.Sleep(100 * time.Millisecond)
+		// the record in block 30 becomes irreversible
+		repo.SetLib(30)
+	}()
+	start := time.Now()
+	res := &types.TransferHistoryResponse{}
+	getApi(t, server, getTransferHistoryUrl, testWaitParams("30"), res)
+	if elapsed := time.Since(start); elapsed < 200 * time.Millisecond || elapsed > 10 * time.Second {
+		t.Fatalf("request returns after %v, want when the record is irreversible", elapsed)
+	}
+	if res.Status != types.StatusSuccess || len(res.List) != 1 || res.List[0].BlockHeight != "30" {
+		t.Fatalf("response is %+v", res)
+	}
+}
+
+func TestGetTransferHistoryWaitExpires(t *testing.T) {
+	server := newTestServer(t, newTestRepository())
+	start := time.Now()
+	res := &types.TransferHistoryResponse{}
+	getApi(t, server, getTransferHistoryUrl, testWaitParams("1"), res)
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("request returns after %v, want after the wait expires", elapsed)
+	}
+	if res.Status != types.StatusSuccess || len(res.List) != 0 {
+		t.Fatalf("response is %+v, want no record", res)
+	}
+}
+
+func TestGetTransferHistoryRejectsInvalidWait(t *testing.T) {
+	server := newTestServer(t, newTestRepository())
+	for _,wait := range []string{strconv.Itoa(maxWaitSeconds + 1), "-1", "a"} {
+		res := &types.TransferHistoryResponse{}
+		getApi(t, server, getTransferHistoryUrl, testWaitParams(wait), res)
+		if res.Status != types.StatusParamInvalidError {
+			t.Fatalf("status of wait %q is %v, msg is %v", wait, res.Status, res.Msg)
+		}
+	}
+}
+
+// the wait ends when the client goes away
+func TestGetTransferHistoryWaitCanceled(t *testing.T) {
+	handler := NewApiHandler(newTestRepository(), nil, nil, nil)
+	params := testWaitParams(strconv.Itoa(maxWaitSeconds))
+	params.Set(verificationCodeKey, testVerificationCode)
+	ctx,cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, getTransferHistoryUrl + "?" + params.Encode(), nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(w, r)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait doesn't end after the request is canceled")
+	}
+}
@@ -0,0 +1,191 @@
+package webServer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"transfer_history/logs"
+	"transfer_history/types"
+	"transfer_history/utils"
+	"transfer_history/webhook"
+)
+
+const (
+	webhookUrlKey = "url"
+	outboxStateKey = "state"
+	outboxIdKey = "id"
+
+	// default count of entries in one page of webhook outbox
+	defaultOutboxLimit = 100
+)
+
+// list the notifications in webhook outbox by url and delivery state, only for admin
+func (h *transferHandler) getWebhookOutbox(w http.ResponseWriter, r *http.Request)  {
+	logger := logs.GetLogger()
+	res := types.WebhookOutboxResponse{List: make([]*types.WebhookOutboxEntry, 0)}
+	vCode,query,err,code := parseOutboxQuery(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	logger.Infof("getWebhookOutbox: url is:%v, state is:%v, after is:%v, limit is:%v, verification code is:%v", query.Url, query.State, query.After, query.Limit, vCode)
+	res.Status = types.StatusSuccess
+	if h.outbox == nil {
+		writeResponse(w, res)
+		return
+	}
+	list,hasMore,err := h.outbox.List(query)
+	if err != nil {
+		logger.Errorf("getWebhookOutbox: fail to list outbox, the error is %v", err)
+		res.Status = types.StatusIntervalError
+		res.Msg = "fail to list webhook outbox"
+		writeResponse(w, res)
+		return
+	}
+	for _,n := range list {
+		res.List = append(res.List, outboxEntryOf(n))
+	}
+	res.HasMore = hasMore
+	writeResponse(w, res)
+}
+
+// make the dead notifications in webhook outbox pending again, only for admin
+func (h *transferHandler) replayWebhookOutbox(w http.ResponseWriter, r *http.Request)  {
+	h.updateDeadNotifications(w, r, "replayWebhookOutbox", func(url string, ids []uint64) (int64,error) {
+		return h.outbox.Replay(url, ids)
+	})
+}
+
+// delete the dead notifications in webhook outbox, only for admin
+func (h *transferHandler) dropWebhookOutbox(w http.ResponseWriter, r *http.Request)  {
+	h.updateDeadNotifications(w, r, "dropWebhookOutbox", func(url string, ids []uint64) (int64,error) {
+		return h.outbox.Drop(url, ids)
+	})
+}
+
+// update the dead notifications in ids, or all the dead notifications of url if there is no id.
+// the update changes the outbox, so it only accepts POST
+func (h *transferHandler) updateDeadNotifications(w http.ResponseWriter, r *http.Request, api string, update func(url string, ids []uint64) (int64,error)) {
+	logger := logs.GetLogger()
+	res := types.WebhookOutboxUpdateResponse{}
+	if r.Method != http.MethodPost {
+		res.Status = http.StatusMethodNotAllowed
+		res.Msg = fmt.Sprintf("Not support %v method", r.Method)
+		w.Header().Set("Allow", http.MethodPost)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeResponse(w, res)
+		return
+	}
+	vCode,err,code := parseAdminVerificationCode(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	url,err,code := parseOptionalParameterFromRequest(r, webhookUrlKey)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	ids,err,code := parseOutboxIds(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	if len(ids) == 0 && !utils.CheckIsNotEmptyStr(url) {
+		res.Status = types.StatusLackParamError
+		res.Msg = fmt.Sprintf("lack parameter %v or %v", outboxIdKey, webhookUrlKey)
+		writeResponse(w, res)
+		return
+	}
+	logger.Infof("%v: url is:%v, id count is:%v, verification code is:%v", api, url, len(ids), vCode)
+	res.Status = types.StatusSuccess
+	if h.outbox == nil {
+		writeResponse(w, res)
+		return
+	}
+	count,err := update(url, ids)
+	if err != nil {
+		logger.Errorf("%v: fail to update outbox, the error is %v", api, err)
+		res.Status = types.StatusIntervalError
+		res.Msg = "fail to update webhook outbox"
+		writeResponse(w, res)
+		return
+	}
+	res.Count = count
+	writeResponse(w, res)
+}
+
+func parseOutboxQuery(r *http.Request) (string,*webhook.OutboxQuery,error,int) {
+	vCode,err,code := parseAdminVerificationCode(r)
+	if err != nil {
+		return "",nil,err,code
+	}
+	query := &webhook.OutboxQuery{Limit: defaultOutboxLimit}
+	if query.Url,err,code = parseOptionalParameterFromRequest(r, webhookUrlKey); err != nil {
+		return "",nil,err,code
+	}
+	if query.State,err,code = parseOptionalParameterFromRequest(r, outboxStateKey); err != nil {
+		return "",nil,err,code
+	}
+	switch query.State {
+	case "", types.WebhookNotificationPending, types.WebhookNotificationDelivered, types.WebhookNotificationDead:
+	default:
+		msg := fmt.Sprintf("state %v is invalid, it should be %v, %v or %v", query.State, types.WebhookNotificationPending, types.WebhookNotificationDelivered, types.WebhookNotificationDead)
+		return "",nil,errors.New(msg),types.StatusParamInvalidError
+	}
+	if query.After,query.Limit,err,code = parseAfterParams(r, defaultOutboxLimit); err != nil {
+		return "",nil,err,code
+	}
+	return vCode,query,nil,0
+}
+
+// get the comma separated ids of outbox entries, at most max query limit
+func parseOutboxIds(r *http.Request) ([]uint64,error,int) {
+	idStr,err,code := parseOptionalParameterFromRequest(r, outboxIdKey)
+	if err != nil || !utils.CheckIsNotEmptyStr(idStr) {
+		return nil,err,code
+	}
+	parts := strings.Split(idStr, ",")
+	if len(parts) > maxQueryLimit {
+		return nil,errors.New(fmt.Sprintf("too many ids, at most %v", maxQueryLimit)),types.StatusParamInvalidError
+	}
+	ids := make([]uint64, 0, len(parts))
+	for _,part := range parts {
+		id,err := strconv.ParseUint(strings.TrimSpace(part), 10, 64)
+		if err != nil {
+			return nil,errors.New(fmt.Sprintf("id %q is invalid", part)),types.StatusParamInvalidError
+		}
+		ids = append(ids, id)
+	}
+	return ids,nil,0
+}
+
+func outboxEntryOf(n *types.WebhookNotification) *types.WebhookOutboxEntry {
+	entry := &types.WebhookOutboxEntry{
+		Id: n.ID,
+		Url: n.Url,
+		State: n.State,
+		Attempts: n.Attempts,
+		LastError: n.LastError,
+		NextAttemptTime: n.NextAttemptAt,
+		CreatedTime: n.CreatedAt,
+		DeliveredTime: n.DeliveredAt,
+		Payload: &types.WebhookPayload{},
+	}
+	if err := json.Unmarshal([]byte(n.Payload), entry.Payload); err != nil {
+		entry.Payload = nil
+	}
+	return entry
+}
@@ -0,0 +1,127 @@
+package webServer
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+	"transfer_history/config"
+	"transfer_history/types"
+	"transfer_history/webhook"
+)
+
+const testWebhookUrl = "http://localhost/hook"
+
+// get a server of the http api with a new webhook outbox at the path in config, which has the dead notifications of ids.
+// the server and outbox are closed when the test finishes
+func newTestOutboxServer(t *testing.T, ids ...string) (*httptest.Server,*webhook.Outbox) {
+	t.Helper()
+	os.Remove(config.GetWebhookOutboxDbConfig().DbName)
+	outbox,err := webhook.OpenOutbox()
+	if err != nil {
+		t.Fatalf("open outbox: %v", err)
+	}
+	t.Cleanup(func() {
+		outbox.Close()
+	})
+	list := make([]*types.WebhookPayload, 0, len(ids))
+	for _,id := range ids {
+		list = append(list, &types.WebhookPayload{Id: id, Account: "alice1"})
+	}
+	if err := outbox.Enqueue(testWebhookUrl, "alice1", list, nil); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	for range ids {
+		n,err := outbox.NextPending(testWebhookUrl)
+		if err != nil || n == nil {
+			t.Fatalf("next pending is %+v, the error is %v", n, err)
+		}
+		if err := outbox.MarkFailed(n, errors.New("refused"), time.Now(), true); err != nil {
+			t.Fatalf("mark failed: %v", err)
+		}
+	}
+	server := httptest.NewServer(NewApiHandler(newTestRepository(), openTestAcks(t, filepath.Join(t.TempDir(), "ack.db")), outbox, nil))
+	t.Cleanup(server.Close)
+	return server,outbox
+}
+
+func postTestOutboxUpdate(t *testing.T, server *httptest.Server, api string, params url.Values) *types.WebhookOutboxUpdateResponse {
+	t.Helper()
+	params.Set(verificationCodeKey, testAdminVerificationCode)
+	resp,err := http.PostForm(server.URL + api, params)
+	if err != nil {
+		t.Fatalf("post %v: %v", api, err)
+	}
+	res := &types.WebhookOutboxUpdateResponse{}
+	decodeResponse(t, resp, res)
+	return res
+}
+
+func TestWebhookOutbox(t *testing.T) {
+	server,outbox := newTestOutboxServer(t, "alice1:op10_0", "alice1:op20_0")
+
+	res := &types.WebhookOutboxResponse{}
+	getApi(t, server, getWebhookOutboxUrl, url.Values{verificationCodeKey: {testAdminVerificationCode}, outboxStateKey: {types.WebhookNotificationDead}, limitKey: {"1"}}, res)
+	if res.Status != types.StatusSuccess || len(res.List) != 1 || !res.HasMore || res.List[0].Payload == nil || res.List[0].Payload.Id != "alice1:op10_0" {
+		t.Fatalf("first page of dead notifications is %+v", res)
+	}
+	first := res.List[0].Id
+	res = &types.WebhookOutboxResponse{}
+	getApi(t, server, getWebhookOutboxUrl, url.Values{verificationCodeKey: {testAdminVerificationCode}, outboxStateKey: {types.WebhookNotificationDead}, afterKey: {strconv.FormatUint(first, 10)}}, res)
+	if res.Status != types.StatusSuccess || len(res.List) != 1 || res.HasMore || res.List[0].Payload.Id != "alice1:op20_0" {
+		t.Fatalf("second page of dead notifications is %+v", res)
+	}
+	second := res.List[0].Id
+
+	// the outbox is only for admin
+	res = &types.WebhookOutboxResponse{}
+	getApi(t, server, getWebhookOutboxUrl, url.Values{}, res)
+	if res.Status != types.StatusParamVerificationCodeInvalidError {
+		t.Fatalf("outbox without admin verification code is %+v", res)
+	}
+
+	if res := postTestOutboxUpdate(t, server, replayWebhookOutboxUrl, url.Values{outboxIdKey: {strconv.FormatUint(first, 10)}}); res.Status != types.StatusSuccess || res.Count != 1 {
+		t.Fatalf("replay is %+v", res)
+	}
+	n,err := outbox.NextPending(testWebhookUrl)
+	if err != nil || n == nil || n.ID != first || n.Attempts != 0 {
+		t.Fatalf("replayed notification is %+v, the error is %v", n, err)
+	}
+	if res := postTestOutboxUpdate(t, server, dropWebhookOutboxUrl, url.Values{webhookUrlKey: {testWebhookUrl}}); res.Status != types.StatusSuccess || res.Count != 1 {
+		t.Fatalf("drop is %+v", res)
+	}
+	if list,_,err := outbox.List(&webhook.OutboxQuery{Url: testWebhookUrl, Limit: 10}); err != nil || len(list) != 1 || list[0].ID == second {
+		t.Fatalf("notifications after drop are %+v, the error is %v", list, err)
+	}
+	if res := postTestOutboxUpdate(t, server, dropWebhookOutboxUrl, url.Values{}); res.Status != types.StatusLackParamError {
+		t.Fatalf("drop without id or url is %+v", res)
+	}
+}
+
+// replay and drop change the outbox, so they don't accept GET
+func TestUpdateWebhookOutboxOnlyAcceptsPost(t *testing.T) {
+	server,outbox := newTestOutboxServer(t, "alice1:op10_0")
+	for _,api := range []string{replayWebhookOutboxUrl, dropWebhookOutboxUrl} {
+		params := url.Values{verificationCodeKey: {testAdminVerificationCode}, webhookUrlKey: {testWebhookUrl}}
+		resp,err := http.Get(server.URL + api + "?" + params.Encode())
+		if err != nil {
+			t.Fatalf("get %v: %v", api, err)
+		}
+		if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != http.MethodPost {
+			t.Fatalf("get %v replies %v with Allow %q", api, resp.StatusCode, resp.Header.Get("Allow"))
+		}
+		res := &types.WebhookOutboxUpdateResponse{}
+		decodeResponse(t, resp, res)
+		if res.Status != http.StatusMethodNotAllowed {
+			t.Fatalf("get %v is %+v", api, res)
+		}
+	}
+	if list,_,err := outbox.List(&webhook.OutboxQuery{Url: testWebhookUrl, State: types.WebhookNotificationDead, Limit: 10}); err != nil || len(list) != 1 {
+		t.Fatalf("dead notifications after GET are %+v, the error is %v", list, err)
+	}
+}
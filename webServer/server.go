@@ -9,12 +9,28 @@ import (
 	"sync"
 	"time"
 	"transfer_history/config"
+	"transfer_history/db"
+	"transfer_history/deposit"
 	"transfer_history/logs"
+	"transfer_history/webhook"
 )
 
 const (
 	getTransferHistoryUrl = "/api/getTransferHistory"
 	getTransferHistoryInBlockUrl = "/api/getTransferHistoryByBlock"
+	getTransferByOperationUrl = "/api/getTransferByOperation"
+	getTransferByTrxUrl = "/api/getTransferByTrx"
+	getBatchTransferHistoryUrl = "/api/getBatchTransferHistory"
+	getTransferSummaryUrl = "/api/getTransferSummary"
+	getTransferStatisticsUrl = "/api/getTransferStatistics"
+	getNodeStatusUrl = "/api/admin/getNodeStatus"
+	getCacheStatusUrl = "/api/admin/getCacheStatus"
+	getWebhookOutboxUrl = "/api/admin/getWebhookOutbox"
+	replayWebhookOutboxUrl = "/api/admin/replayWebhookOutbox"
+	dropWebhookOutboxUrl = "/api/admin/dropWebhookOutbox"
+	getDepositEventsUrl = "/api/getDepositEvents"
+	subscribeTransfersUrl = "/api/subscribeTransfers"
+	streamTransfersUrl = "/api/streamTransfers"
 
 	writeTimeOut = 3
 	readTimeOut  = 3
@@ -26,9 +42,10 @@ var (
 )
 
 
-func StartServer() error {
+// start the http server of the apis, outbox is nil if no webhook is configured, and tracker is nil if no deposit account is configured
+func StartServer(repo db.TransferRepository, acks *SubscriptionAcks, outbox *webhook.Outbox, tracker *deposit.Tracker) error {
 	var g errgroup.Group
-	serverMux := initHandlers()
+	serverMux := initHandlers(repo, acks, outbox, tracker)
 	server = &http.Server{Handler: serverMux, ReadTimeout: readTimeOut * time.Minute, WriteTimeout: writeTimeOut * time.Minute}
 	addr := ":" + config.GetHttpPort()
 	listener,err := net.Listen("tcp", addr)
@@ -51,13 +68,59 @@ func StartServer() error {
 }
 
 
-func initHandlers() *http.ServeMux {
+// get the http handler of all the apis, which query transfer records from repo,
+// keep the acknowledged blocks of transfer subscriptions in acks, manage the webhook outbox and read the deposit events of tracker
+func NewApiHandler(repo db.TransferRepository, acks *SubscriptionAcks, outbox *webhook.Outbox, tracker *deposit.Tracker) http.Handler {
+	return initHandlers(repo, acks, outbox, tracker)
+}
+
+func initHandlers(repo db.TransferRepository, acks *SubscriptionAcks, outbox *webhook.Outbox, tracker *deposit.Tracker) *http.ServeMux {
+	h := &transferHandler{repo: repo, acks: acks, outbox: outbox, tracker: tracker}
 	serverMux := http.NewServeMux()
 	serverMux.HandleFunc(getTransferHistoryUrl, func(writer http.ResponseWriter, request *http.Request) {
-		getTransferHistory(writer, request)
+		h.getTransferHistory(writer, request)
 	})
 	serverMux.HandleFunc(getTransferHistoryInBlockUrl, func(writer http.ResponseWriter, request *http.Request) {
-		getTransferHistoryOfBlock(writer, request)
+		h.getTransferHistoryOfBlock(writer, request)
+	})
+	serverMux.HandleFunc(getTransferByOperationUrl, func(writer http.ResponseWriter, request *http.Request) {
+		h.getTransferByOperation(writer, request, false)
+	})
+	serverMux.HandleFunc(getTransferByTrxUrl, func(writer http.ResponseWriter, request *http.Request) {
+		h.getTransferByOperation(writer, request, true)
+	})
+	serverMux.HandleFunc(getBatchTransferHistoryUrl, func(writer http.ResponseWriter, request *http.Request) {
+		h.getBatchTransferHistory(writer, request)
+	})
+	serverMux.HandleFunc(getTransferSummaryUrl, func(writer http.ResponseWriter, request *http.Request) {
+		h.getTransferSummary(writer, request)
+	})
+	serverMux.HandleFunc(getTransferStatisticsUrl, func(writer http.ResponseWriter, request *http.Request) {
+		h.getTransferStatistics(writer, request)
+	})
+	serverMux.HandleFunc(getNodeStatusUrl, func(writer http.ResponseWriter, request *http.Request) {
+		h.getNodeStatus(writer, request)
+	})
+	serverMux.HandleFunc(getCacheStatusUrl, func(writer http.ResponseWriter, request *http.Request) {
+		h.getCacheStatus(writer, request)
+	})
+	serverMux.HandleFunc(getWebhookOutboxUrl, func(writer http.ResponseWriter, request *http.Request) {
+		h.getWebhookOutbox(writer, request)
+	})
+	serverMux.HandleFunc(replayWebhookOutboxUrl, func(writer http.ResponseWriter, request *http.Request) {
+		h.replayWebhookOutbox(writer, request)
+	})
+	serverMux.HandleFunc(dropWebhookOutboxUrl, func(writer http.ResponseWriter, request *http.Request) {
+		h.dropWebhookOutbox(writer, request)
+	})
+	serverMux.HandleFunc(getDepositEventsUrl, func(writer http.ResponseWriter, request *http.Request) {
+		h.getDepositEvents(writer, request)
+	})
+	serverMux.HandleFunc(subscribeTransfersUrl, func(writer http.ResponseWriter, request *http.Request) {
+		h.subscribeTransfers(writer, request)
+	})
+	serverMux.HandleFunc(streamTransfersUrl, func(writer http.ResponseWriter, request *http.Request) {
+		h.streamTransfers(writer, request)
 	})
 	return serverMux
 }
@@ -0,0 +1,293 @@
+package webServer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"transfer_history/config"
+	"transfer_history/db"
+	"transfer_history/logs"
+	"transfer_history/types"
+	"transfer_history/utils"
+)
+
+const (
+	// the stream ends before the write timeout of http server, and the client reconnects with Last-Event-ID
+	streamMaxDuration = writeTimeOut * time.Minute - 30 * time.Second
+	// interval of the comment which keeps the stream alive through proxies
+	streamHeartbeatInterval = 15 * time.Second
+	// milliseconds the client waits before reconnecting
+	streamRetryMs = 1000
+	lastEventIdHeader = "Last-Event-ID"
+	// the same as Last-Event-ID, for the client which can't set headers
+	lastEventIdKey = "lastEventId"
+)
+
+// records of an account fetched but not sent yet
+type streamAccount struct {
+	acct *types.AccountStartBlock
+	list []*types.TransferRecord
+	// position of the last fetched record
+	cursor *types.TransferCursor
+	// whether there are more records at or below the lib of the round
+	more bool
+}
+
+// server-sent events of the irreversible transfers of some accounts
+type transferStream struct {
+	w http.ResponseWriter
+	flusher http.Flusher
+	repo db.TransferRepository
+	accounts []*types.AccountStartBlock
+	dir int
+	// position of the last sent record, nil if nothing is sent
+	position *types.TransferCursor
+	synced uint64
+}
+
+//
+// stream the irreversible transfers of accounts as server-sent events, which is resumed after Last-Event-ID
+//
+func (h *transferHandler) streamTransfers(w http.ResponseWriter, r *http.Request)  {
+	w.Header().Add("Access-Control-Allow-Origin", "*")
+	logger := logs.GetLogger()
+	res := types.BaseResponse{}
+	vCode,err,code := parseVerificationCode(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	dir,err,code := parseTxDirection(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	accounts,err,code := parseBatchAccounts(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	position,err,code := parseLastEventId(r)
+	if err != nil {
+		res.Status = code
+		res.Msg = err.Error()
+		writeResponse(w, res)
+		return
+	}
+	flusher,ok := w.(http.Flusher)
+	if !ok {
+		res.Status = types.StatusIntervalError
+		res.Msg = "streaming is not supported"
+		writeResponse(w, res)
+		return
+	}
+
+	logger.Infof("streamTransfers: account count is:%v, transfer direction is:%v, last event id is:%v, verification code is:%v", len(accounts), dir, position, vCode)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// stop nginx from buffering the events
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	stream := &transferStream{
+		w: w,
+		flusher: flusher,
+		repo: h.repo,
+		accounts: accounts,
+		dir: dir,
+		position: position,
+	}
+	stream.run(r.Context())
+}
+
+// get the position of the last event received by client, nil if it's the first connection
+func parseLastEventId(r *http.Request) (*types.TransferCursor,error,int) {
+	id := r.Header.Get(lastEventIdHeader)
+	if !utils.CheckIsNotEmptyStr(id) {
+		id,_,_ = parseOptionalParameterFromRequest(r, lastEventIdKey)
+	}
+	if !utils.CheckIsNotEmptyStr(id) {
+		return nil,nil,0
+	}
+	parts := strings.SplitN(id, ":", 2)
+	if len(parts) == 2 && utils.CheckIsNotEmptyStr(parts[1]) {
+		if blkNum,err := strconv.ParseUint(parts[0], 10, 64); err == nil {
+			return &types.TransferCursor{BlockHeight: blkNum, OperationId: parts[1]},nil,0
+		}
+	}
+	msg := fmt.Sprintf("invalid last event id %q, it should be block height:operation id", id)
+	return nil,errors.New(msg),types.StatusParamInvalidError
+}
+
+// id of the event of record, it's the position of record
+func transferEventId(rec *types.TransferRecord) string {
+	return rec.BlockHeight + ":" + rec.OperationId
+}
+
+// send the records until the client disconnects or the stream lasts for max duration, wait for the new blocks after all the records are sent
+func (s *transferStream) run(ctx context.Context) {
+	logger := logs.GetLogger()
+	ctx,cancel := context.WithTimeout(ctx, streamMaxDuration)
+	defer cancel()
+	if err := s.write(fmt.Sprintf("retry: %d\n\n", streamRetryMs)); err != nil {
+		return
+	}
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		// get the notification before sending, so the blocks indexed while sending are not missed
+		changed := db.WaitBlockChange(s.repo)
+		if err,code := s.push(ctx); err != nil {
+			if ctx.Err() == nil {
+				logger.Errorf("streamTransfers: stop streaming, the error is %v", err)
+				if code != 0 {
+					msg := &types.SubscriptionErrorMessage{Type: types.SubscriptionError}
+					msg.Status = code
+					msg.Msg = err.Error()
+					s.writeEvent("", types.SubscriptionError, msg)
+				}
+			}
+			return
+		}
+		for waiting := true; waiting; {
+			select {
+			case <-changed:
+				waiting = false
+			case <-heartbeat.C:
+				if err := s.write(": ping\n\n"); err != nil {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// send the records of all the accounts after the last position and at or below lib in (block height, operation id) order,
+// a transfer between two of the accounts is sent once. then send the lib if it grows
+func (s *transferStream) push(ctx context.Context) (error,int) {
+	// the lowest lib seen in this round, the records above it are sent in the next round
+	var lib uint64 = math.MaxUint64
+	list := make([]*streamAccount, 0, len(s.accounts))
+	for _,acct := range s.accounts {
+		list = append(list, &streamAccount{acct: acct, cursor: s.position, more: true})
+	}
+	for {
+		// every account needs its next record to decide which one is sent first
+		for _,sa := range list {
+			if len(sa.list) > 0 || !sa.more {
+				continue
+			}
+			query := &types.TransferRecordQuery{
+				Start: sa.acct.Start,
+				Account: sa.acct.Account,
+				Direction: s.dir,
+				Limit: defaultQueryLimit,
+				Cursor: sa.cursor,
+			}
+			qCtx,cancel := context.WithTimeout(ctx, config.GetQueryTimeout("streamTransfers"))
+			model := s.repo.GetTransferRecord(qCtx, query)
+			cancel()
+			if model.Err != nil {
+				return model.Err,model.ErrCode
+			}
+			sa.list, sa.cursor, sa.more = model.List, model.NextCursor, model.HasMore
+			if model.Lib < lib {
+				lib = model.Lib
+			}
+		}
+		// the accounts may be queried on dbs with different libs, a record above the lowest one can't be sent,
+		// or the position moves past the blocks which are not irreversible on the db of another account
+		for _,sa := range list {
+			sa.dropAbove(lib)
+		}
+		var next *streamAccount
+		for _,sa := range list {
+			if len(sa.list) > 0 && (next == nil || compareTransferPosition(positionOf(sa.list[0]), positionOf(next.list[0])) < 0) {
+				next = sa
+			}
+		}
+		if next == nil {
+			break
+		}
+		rec := next.list[0]
+		next.list = next.list[1:]
+		pos := positionOf(rec)
+		if s.position != nil && compareTransferPosition(pos, s.position) <= 0 {
+			// the same transfer of another account
+			continue
+		}
+		if err := s.writeEvent(transferEventId(rec), types.SubscriptionTransfer, rec); err != nil {
+			return err,0
+		}
+		s.position = pos
+	}
+	if lib != math.MaxUint64 && lib > s.synced {
+		s.synced = lib
+		return s.writeEvent("", types.SubscriptionSynced, &types.SyncedMessage{Type: types.SubscriptionSynced, BlockHeight: strconv.FormatUint(lib, 10)}),0
+	}
+	return nil,0
+}
+
+// drop the fetched records above lib, the account has no more records in this round if any of them is dropped
+func (sa *streamAccount) dropAbove(lib uint64) {
+	for i,rec := range sa.list {
+		if positionOf(rec).BlockHeight > lib {
+			sa.list = sa.list[:i]
+			sa.more = false
+			return
+		}
+	}
+}
+
+// get the (block height, operation id) position of record
+func positionOf(rec *types.TransferRecord) *types.TransferCursor {
+	blkNum,_ := strconv.ParseUint(rec.BlockHeight, 10, 64)
+	return &types.TransferCursor{BlockHeight: blkNum, OperationId: rec.OperationId}
+}
+
+func compareTransferPosition(a, b *types.TransferCursor) int {
+	if a.BlockHeight != b.BlockHeight {
+		if a.BlockHeight < b.BlockHeight {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(a.OperationId, b.OperationId)
+}
+
+// write an event, the id is not changed if id is empty
+func (s *transferStream) writeEvent(id, event string, data interface{}) error {
+	js,err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if utils.CheckIsNotEmptyStr(id) {
+		fmt.Fprintf(&buf, "id: %s\n", id)
+	}
+	fmt.Fprintf(&buf, "event: %s\ndata: %s\n\n", event, js)
+	return s.write(buf.String())
+}
+
+func (s *transferStream) write(str string) error {
+	if _,err := s.w.Write([]byte(str)); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
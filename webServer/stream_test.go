@@ -0,0 +1,70 @@
+package webServer
+
+import (
+	"context"
+	"github.com/coschain/contentos-go/app/plugins"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"transfer_history/db"
+	"transfer_history/types"
+)
+
+// a repository whose queries of every account are answered by a db with its own lib
+type libPerAccountRepository struct {
+	*db.MemoryRepository
+	libs map[string]uint64
+}
+
+func (repo *libPerAccountRepository) GetTransferRecord(ctx context.Context, query *types.TransferRecordQuery) *types.QueryTransferRecordModel {
+	q := *query
+	q.IncludeReversible = true
+	model := repo.MemoryRepository.GetTransferRecord(ctx, &q)
+	lib := repo.libs[query.Account]
+	list := make([]*types.TransferRecord, 0)
+	for _,rec := range model.List {
+		if positionOf(rec).BlockHeight <= lib {
+			list = append(list, rec)
+		}
+	}
+	model.List, model.Lib, model.HasMore = list, lib, false
+	return model
+}
+
+func TestStreamPushStopsAtLowestLib(t *testing.T) {
+	mem := db.NewMemoryRepository()
+	mem.AddTransferRecord(
+		&plugins.TransferRecord{BlockHeight: 93, OperationId: "op93_0", From: "initminer", To: "alice1", Amount: 1, BlockTime: time.Now()},
+		&plugins.TransferRecord{BlockHeight: 95, OperationId: "op95_0", From: "initminer", To: "bobbob1", Amount: 1, BlockTime: time.Now()},
+	)
+	// the db answering alice1 hasn't made block 93 irreversible
+	repo := &libPerAccountRepository{MemoryRepository: mem, libs: map[string]uint64{"alice1": 90, "bobbob1": 100}}
+	w := httptest.NewRecorder()
+	s := &transferStream{
+		w: w,
+		flusher: w,
+		repo: repo,
+		accounts: []*types.AccountStartBlock{{Account: "alice1"}, {Account: "bobbob1"}},
+		dir: types.TxDirectionAll,
+	}
+	if err,_ := s.push(context.Background()); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if strings.Contains(w.Body.String(), "op95_0") {
+		t.Fatalf("record above the lowest lib is sent: %q", w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), `"BlockHeight":"90"`) {
+		t.Fatalf("lowest lib is not synced: %q", w.Body.String())
+	}
+
+	repo.libs["alice1"] = 100
+	if err,_ := s.push(context.Background()); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	body := w.Body.String()
+	i, j := strings.Index(body, "id: 93:op93_0"), strings.Index(body, "id: 95:op95_0")
+	if i < 0 || j < 0 || i > j {
+		t.Fatalf("records are not sent in order: %q", body)
+	}
+}
@@ -0,0 +1,298 @@
+package webServer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+	"transfer_history/config"
+	"transfer_history/db"
+	"transfer_history/logs"
+	"transfer_history/types"
+	"transfer_history/utils"
+)
+
+const (
+	// max time to write a message to the subscriber
+	subscriptionWriteWait = 10 * time.Second
+	// the subscriber is pinged in every interval, and closed if it doesn't answer in pong wait
+	subscriptionPingInterval = 30 * time.Second
+	subscriptionPongWait = 60 * time.Second
+	// max size of a message sent by the subscriber
+	subscriptionMaxMessageSize = 64 * 1024
+	// max length of subscription id
+	maxSubscriptionIdLength = 64
+)
+
+var upgrader = websocket.Upgrader{
+	// the same as the http apis, any origin is allowed
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// a websocket client subscribing the transfers of some accounts
+type transferSubscriber struct {
+	conn *websocket.Conn
+	repo db.TransferRepository
+	acks *SubscriptionAcks
+	// the subscription is identified by the verification code and id, the id is empty if the client gives no id
+	vCode string
+	id string
+	accounts []*types.AccountSubscription
+	// position of the last pushed record of every account
+	cursors []*types.TransferCursor
+	// the block height in the last synced message, it's read by the goroutine of acks
+	synced uint64
+	ping *time.Ticker
+}
+
+// error of the subscription, code is 0 if the connection fails, otherwise it's replied to the subscriber
+type subscriptionError struct {
+	err error
+	code int
+}
+
+//
+// push the irreversible transfers of accounts to a websocket client, the backlog first and then the new blocks
+//
+func (h *transferHandler) subscribeTransfers(w http.ResponseWriter, r *http.Request)  {
+	logger := logs.GetLogger()
+	vCode,err,code := parseVerificationCode(r)
+	if err != nil {
+		writeResponse(w, types.BaseResponse{Status: code, Msg: err.Error()})
+		return
+	}
+	conn,err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader replies the error to client
+		logger.Errorf("subscribeTransfers: fail to upgrade to websocket, the error is %v", err)
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(subscriptionMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(subscriptionPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(subscriptionPongWait))
+	})
+	sub := &transferSubscriber{conn: conn, repo: h.repo, acks: h.acks, vCode: vCode}
+	// the first message subscribes the accounts
+	req,readErr := sub.read()
+	if readErr == nil {
+		readErr = sub.subscribe(req)
+	}
+	if readErr != nil {
+		logger.Errorf("subscribeTransfers: fail to subscribe, the error is %v", readErr.err)
+		if readErr.code != 0 {
+			sub.writeError(readErr.code, readErr.err)
+		}
+		return
+	}
+	logger.Infof("subscribeTransfers: id is:%v, account count is:%v, verification code is:%v", req.Id, len(sub.accounts), vCode)
+	sub.run(r.Context())
+}
+
+// read a message of the subscriber
+func (sub *transferSubscriber) read() (*types.SubscriptionRequest,*subscriptionError) {
+	_,data,err := sub.conn.ReadMessage()
+	if err != nil {
+		return nil,&subscriptionError{err: err}
+	}
+	sub.conn.SetReadDeadline(time.Now().Add(subscriptionPongWait))
+	req := &types.SubscriptionRequest{}
+	if err := json.Unmarshal(data, req); err != nil {
+		msg := fmt.Sprintf("fail to parse message, the error is %v", err)
+		return nil,&subscriptionError{err: errors.New(msg), code: types.StatusParamInvalidError}
+	}
+	return req,nil
+}
+
+// check the subscribed accounts, and move their start after the acknowledged block of the same subscription id
+func (sub *transferSubscriber) subscribe(req *types.SubscriptionRequest) *subscriptionError {
+	invalid := func(msg string, code int) *subscriptionError {
+		return &subscriptionError{err: errors.New(msg), code: code}
+	}
+	if req.Type != types.SubscriptionSubscribe {
+		return invalid(fmt.Sprintf("the first message should be %v, not %q", types.SubscriptionSubscribe, req.Type), types.StatusParamInvalidError)
+	}
+	if len(req.Id) > maxSubscriptionIdLength {
+		return invalid(fmt.Sprintf("subscription id is longer than %v", maxSubscriptionIdLength), types.StatusParamInvalidError)
+	}
+	if len(req.Accounts) == 0 {
+		return invalid("lack accounts to subscribe", types.StatusLackParamError)
+	}
+	if len(req.Accounts) > maxBatchAccountCount {
+		return invalid(fmt.Sprintf("too many accounts, at most %v", maxBatchAccountCount), types.StatusParamInvalidError)
+	}
+	seen := make(map[string]bool)
+	for _,acct := range req.Accounts {
+		if acct == nil || !utils.CheckIsValidAccountName(acct.Account) {
+			return invalid("account name of subscription is invalid", types.StatusParamInvalidError)
+		}
+		if seen[acct.Account] {
+			return invalid(fmt.Sprintf("duplicate account %v", acct.Account), types.StatusParamInvalidError)
+		}
+		seen[acct.Account] = true
+		if acct.Direction != types.TxDirectionSend && acct.Direction != types.TxDirectionReceive && acct.Direction != types.TxDirectionAll {
+			return invalid(fmt.Sprintf("transfer direction %v is invalid", acct.Direction), types.StatusParamTransferDirectionInvalidError)
+		}
+	}
+	if utils.CheckIsNotEmptyStr(req.Id) {
+		sub.id = req.Id
+		acked,ok,err := sub.acks.get(sub.vCode, sub.id)
+		if err != nil {
+			return &subscriptionError{err: err, code: types.StatusIntervalError}
+		}
+		if ok {
+			for _,acct := range req.Accounts {
+				if acct.Start <= acked {
+					acct.Start = acked + 1
+				}
+			}
+		}
+	}
+	sub.accounts = req.Accounts
+	sub.cursors = make([]*types.TransferCursor, len(req.Accounts))
+	return sub.writeMessage(&types.SubscribedMessage{Type: types.SubscriptionSubscribed, Id: req.Id, Accounts: sub.accounts})
+}
+
+// push the records until the connection is closed, wait for the new blocks after all the records are pushed
+func (sub *transferSubscriber) run(ctx context.Context) {
+	logger := logs.GetLogger()
+	readErr := make(chan *subscriptionError, 1)
+	go func() {
+		readErr <- sub.readAcks()
+	}()
+	sub.ping = time.NewTicker(subscriptionPingInterval)
+	defer sub.ping.Stop()
+	for {
+		// get the notification before pushing, so the blocks indexed while pushing are not missed
+		changed := db.WaitBlockChange(sub.repo)
+		if err := sub.push(ctx); err != nil {
+			logger.Errorf("subscribeTransfers: stop pushing to %v, the error is %v", sub.id, err.err)
+			if err.code != 0 {
+				sub.writeError(err.code, err.err)
+			}
+			return
+		}
+		for waiting := true; waiting; {
+			select {
+			case <-changed:
+				waiting = false
+			case <-sub.ping.C:
+				if err := sub.writePing(); err != nil {
+					return
+				}
+			case err := <-readErr:
+				if err.code != 0 {
+					logger.Errorf("subscribeTransfers: invalid message of %v, the error is %v", sub.id, err.err)
+					sub.writeError(err.code, err.err)
+				}
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// read the ack messages until the connection is closed or a message is invalid
+func (sub *transferSubscriber) readAcks() *subscriptionError {
+	logger := logs.GetLogger()
+	for {
+		req,err := sub.read()
+		if err != nil {
+			return err
+		}
+		if req.Type != types.SubscriptionAck {
+			msg := fmt.Sprintf("unexpected message %q, only %v is accepted after subscribing", req.Type, types.SubscriptionAck)
+			return &subscriptionError{err: errors.New(msg), code: types.StatusParamInvalidError}
+		}
+		if utils.CheckIsNotEmptyStr(sub.id) {
+			// the records above the synced block may be not pushed yet
+			blk := req.BlockHeight
+			if synced := atomic.LoadUint64(&sub.synced); blk > synced {
+				blk = synced
+			}
+			if err := sub.acks.ack(sub.vCode, sub.id, blk); err != nil {
+				// the subscription resumes from the previous acknowledged block, the client skips the processed records
+				logger.Errorf("subscribeTransfers: fail to acknowledge block %v of %v, the error is %v", blk, sub.id, err)
+			}
+		}
+	}
+}
+
+// push the new irreversible records of every account from its last position, then the block height all of them are synced to
+func (sub *transferSubscriber) push(ctx context.Context) *subscriptionError {
+	var synced uint64
+	for i,acct := range sub.accounts {
+		for {
+			query := &types.TransferRecordQuery{
+				Start: acct.Start,
+				Account: acct.Account,
+				Direction: acct.Direction,
+				Limit: defaultQueryLimit,
+				Cursor: sub.cursors[i],
+			}
+			qCtx,cancel := context.WithTimeout(ctx, config.GetQueryTimeout("subscribeTransfers"))
+			model := sub.repo.GetTransferRecord(qCtx, query)
+			cancel()
+			if model.Err != nil {
+				return &subscriptionError{err: model.Err, code: model.ErrCode}
+			}
+			for _,rec := range model.List {
+				if err := sub.writeMessage(&types.TransferMessage{Type: types.SubscriptionTransfer, Account: acct.Account, Record: rec}); err != nil {
+					return err
+				}
+			}
+			// keep the connection alive while pushing a long backlog
+			select {
+			case <-sub.ping.C:
+				if err := sub.writePing(); err != nil {
+					return err
+				}
+			default:
+			}
+			sub.cursors[i] = model.NextCursor
+			if i == 0 || model.Lib < synced {
+				synced = model.Lib
+			}
+			if !model.HasMore {
+				break
+			}
+		}
+	}
+	if synced > atomic.LoadUint64(&sub.synced) {
+		atomic.StoreUint64(&sub.synced, synced)
+		return sub.writeMessage(&types.SyncedMessage{Type: types.SubscriptionSynced, BlockHeight: strconv.FormatUint(synced, 10)})
+	}
+	return nil
+}
+
+func (sub *transferSubscriber) writeMessage(msg interface{}) *subscriptionError {
+	sub.conn.SetWriteDeadline(time.Now().Add(subscriptionWriteWait))
+	if err := sub.conn.WriteJSON(msg); err != nil {
+		return &subscriptionError{err: err}
+	}
+	return nil
+}
+
+func (sub *transferSubscriber) writePing() *subscriptionError {
+	sub.conn.SetWriteDeadline(time.Now().Add(subscriptionWriteWait))
+	if err := sub.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+		return &subscriptionError{err: err}
+	}
+	return nil
+}
+
+func (sub *transferSubscriber) writeError(code int, err error) {
+	msg := &types.SubscriptionErrorMessage{Type: types.SubscriptionError}
+	msg.Status = code
+	msg.Msg = err.Error()
+	sub.writeMessage(msg)
+}
@@ -0,0 +1,74 @@
+package webServer
+
+import (
+	"github.com/jinzhu/gorm"
+	"transfer_history/config"
+	"transfer_history/db"
+	"transfer_history/logs"
+	"transfer_history/types"
+)
+
+// SubscriptionAcks keeps the acknowledged block of every transfer subscription in a db,
+// so a subscription resumes after its acknowledged block across reconnects and restarts
+type SubscriptionAcks struct {
+	db *gorm.DB
+}
+
+// open the ack db in config and create its table
+func OpenSubscriptionAcks() (*SubscriptionAcks,error) {
+	return openSubscriptionAcks(config.GetSubscriptionAckDbConfig())
+}
+
+func openSubscriptionAcks(cfg *config.DbConfig) (*SubscriptionAcks,error) {
+	logger := logs.GetLogger()
+	store,err := db.OpenDb(cfg)
+	if err != nil {
+		logger.Errorf("OpenSubscriptionAcks: fail to open ack db, the error is %v", err)
+		return nil,err
+	}
+	if cfg.Driver == config.DbDriverSqlite {
+		// sqlite allows one writer, the subscribers acknowledge concurrently
+		store.DB().SetMaxOpenConns(1)
+	}
+	err = store.AutoMigrate(&types.SubscriptionAckBlock{}).Error
+	if err == nil {
+		err = store.Model(&types.SubscriptionAckBlock{}).AddUniqueIndex("idx_subscription_ack_code_id", "verification_code", "subscription_id").Error
+	}
+	if err != nil {
+		logger.Errorf("OpenSubscriptionAcks: fail to create table of ack db, the error is %v", err)
+		store.Close()
+		return nil,err
+	}
+	return &SubscriptionAcks{db: store},nil
+}
+
+func (acks *SubscriptionAcks) Close() error {
+	return acks.db.Close()
+}
+
+// get the acknowledged block of the subscription, return false if it's never acknowledged
+func (acks *SubscriptionAcks) get(vCode, id string) (uint64,bool,error) {
+	ack := &types.SubscriptionAckBlock{}
+	err := acks.db.Where("verification_code = ? AND subscription_id = ?", vCode, id).Take(ack).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return 0,false,nil
+	}
+	if err != nil {
+		return 0,false,err
+	}
+	return ack.BlockHeight,true,nil
+}
+
+// acknowledge the records at or below blk, the acknowledged block never goes back
+func (acks *SubscriptionAcks) ack(vCode, id string, blk uint64) error {
+	ack := &types.SubscriptionAckBlock{}
+	err := acks.db.Where(types.SubscriptionAckBlock{VerificationCode: vCode, SubscriptionId: id}).
+		Attrs(types.SubscriptionAckBlock{BlockHeight: blk}).
+		FirstOrCreate(ack).Error
+	if err != nil || ack.BlockHeight >= blk {
+		return err
+	}
+	return acks.db.Model(&types.SubscriptionAckBlock{}).
+		Where("verification_code = ? AND subscription_id = ? AND block_height < ?", vCode, id, blk).
+		Update("block_height", blk).Error
+}
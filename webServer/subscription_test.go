@@ -0,0 +1,173 @@
+package webServer
+
+import (
+	"github.com/coschain/contentos-go/app/plugins"
+	"github.com/gorilla/websocket"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"transfer_history/types"
+)
+
+// any message pushed by the subscription
+type testSubscriptionMessage struct {
+	types.SubscriptionErrorMessage
+	Id string
+	Accounts []*types.AccountSubscription
+	Account string
+	Record *types.TransferRecord
+	BlockHeight string
+}
+
+// dial the subscription of server with the test verification code, the connection is closed when the test finishes
+func dialTestSubscription(t *testing.T, server *httptest.Server) *websocket.Conn {
+	t.Helper()
+	u := "ws" + strings.TrimPrefix(server.URL, "http") + subscribeTransfersUrl + "?" + url.Values{verificationCodeKey: {testVerificationCode}}.Encode()
+	conn,_,err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatalf("dial subscription: %v", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return conn
+}
+
+func readTestMessage(t *testing.T, conn *websocket.Conn) *testSubscriptionMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	msg := &testSubscriptionMessage{}
+	if err := conn.ReadJSON(msg); err != nil {
+		t.Fatalf("read message: %v", err)
+	}
+	return msg
+}
+
+// read the pushed records of alice1 until a synced message, check their blocks and the synced block
+func expectTestTransfers(t *testing.T, conn *websocket.Conn, wantSynced string, wantBlocks ...string) {
+	t.Helper()
+	var blocks []string
+	for {
+		msg := readTestMessage(t, conn)
+		if msg.Type == types.SubscriptionSynced {
+			if msg.BlockHeight != wantSynced {
+				t.Fatalf("synced to block %v, want %v", msg.BlockHeight, wantSynced)
+			}
+			break
+		}
+		if msg.Type != types.SubscriptionTransfer || msg.Account != "alice1" || msg.Record == nil {
+			t.Fatalf("unexpected message %+v", msg)
+		}
+		blocks = append(blocks, msg.Record.BlockHeight)
+	}
+	if strings.Join(blocks, ",") != strings.Join(wantBlocks, ",") {
+		t.Fatalf("records are in blocks %v, want %v", blocks, wantBlocks)
+	}
+}
+
+// subscribe alice1 with id, return the start in reply
+func subscribeTestAccount(t *testing.T, conn *websocket.Conn, id string) uint64 {
+	t.Helper()
+	req := &types.SubscriptionRequest{
+		Type: types.SubscriptionSubscribe,
+		Id: id,
+		Accounts: []*types.AccountSubscription{{Account: "alice1", Direction: types.TxDirectionAll}},
+	}
+	if err := conn.WriteJSON(req); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	msg := readTestMessage(t, conn)
+	if msg.Type != types.SubscriptionSubscribed || msg.Id != id || len(msg.Accounts) != 1 {
+		t.Fatalf("reply of subscribe is %+v", msg)
+	}
+	return msg.Accounts[0].Start
+}
+
+// wait until the acknowledged block of id is blk
+func waitTestAck(t *testing.T, acks *SubscriptionAcks, id string, blk uint64) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if acked,ok,err := acks.get(testVerificationCode, id); err == nil && ok && acked == blk {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("block %v of %v is not acknowledged", blk, id)
+		}
+	}
+}
+
+// the backlog is pushed first, then the new blocks, and a reconnected subscription resumes after the acknowledged block,
+// even if the service restarts in between
+func TestSubscribeTransfers(t *testing.T) {
+	repo := newTestRepository()
+	ackPath := filepath.Join(t.TempDir(), "ack.db")
+	acks := openTestAcks(t, ackPath)
+	server := httptest.NewServer(NewApiHandler(repo, acks, nil, nil))
+	defer server.Close()
+
+	conn := dialTestSubscription(t, server)
+	if start := subscribeTestAccount(t, conn, "wallet1"); start != 0 {
+		t.Fatalf("start of a new subscription is %v", start)
+	}
+	// the record in block 30 is above lib
+	expectTestTransfers(t, conn, "25", "10", "20")
+	repo.SetLib(30)
+	expectTestTransfers(t, conn, "30", "30")
+	if err := conn.WriteJSON(&types.SubscriptionRequest{Type: types.SubscriptionAck, BlockHeight: 20}); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	waitTestAck(t, acks, "wallet1", 20)
+	conn.Close()
+
+	// restart the service, the acknowledged block is kept in the ack db
+	server.Close()
+	acks.Close()
+	acks = openTestAcks(t, ackPath)
+	server = httptest.NewServer(NewApiHandler(repo, acks, nil, nil))
+	defer server.Close()
+	repo.AddTransferRecord(&plugins.TransferRecord{BlockHeight: 35, OperationId: testOperationId(35), From: "initminer", To: "alice1", Amount: 35, BlockTime: time.Unix(35 * 3, 0)})
+	repo.SetLib(40)
+	conn = dialTestSubscription(t, server)
+	if start := subscribeTestAccount(t, conn, "wallet1"); start != 21 {
+		t.Fatalf("start of the resumed subscription is %v, want 21", start)
+	}
+	expectTestTransfers(t, conn, "40", "30", "35")
+	// an ack above the synced block is limited to it
+	if err := conn.WriteJSON(&types.SubscriptionRequest{Type: types.SubscriptionAck, BlockHeight: 100}); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	waitTestAck(t, acks, "wallet1", 40)
+
+	// another subscription id starts from its own start
+	other := dialTestSubscription(t, server)
+	if start := subscribeTestAccount(t, other, "wallet2"); start != 0 {
+		t.Fatalf("start of another subscription is %v", start)
+	}
+	expectTestTransfers(t, other, "40", "10", "20", "30", "35")
+}
+
+func TestSubscribeTransfersRejectsInvalidMessages(t *testing.T) {
+	server := newTestServer(t, newTestRepository())
+	for _,c := range []struct {
+		name string
+		req *types.SubscriptionRequest
+		code int
+	}{
+		{"ack before subscribe", &types.SubscriptionRequest{Type: types.SubscriptionAck, BlockHeight: 10}, types.StatusParamInvalidError},
+		{"no account", &types.SubscriptionRequest{Type: types.SubscriptionSubscribe}, types.StatusLackParamError},
+		{"invalid account", &types.SubscriptionRequest{Type: types.SubscriptionSubscribe, Accounts: []*types.AccountSubscription{{Account: "alice", Direction: types.TxDirectionAll}}}, types.StatusParamInvalidError},
+		{"invalid direction", &types.SubscriptionRequest{Type: types.SubscriptionSubscribe, Accounts: []*types.AccountSubscription{{Account: "alice1", Direction: 4}}}, types.StatusParamTransferDirectionInvalidError},
+		{"long id", &types.SubscriptionRequest{Type: types.SubscriptionSubscribe, Id: strings.Repeat("a", maxSubscriptionIdLength + 1), Accounts: []*types.AccountSubscription{{Account: "alice1", Direction: types.TxDirectionAll}}}, types.StatusParamInvalidError},
+	} {
+		conn := dialTestSubscription(t, server)
+		if err := conn.WriteJSON(c.req); err != nil {
+			t.Fatalf("%v: write: %v", c.name, err)
+		}
+		if msg := readTestMessage(t, conn); msg.Type != types.SubscriptionError || msg.Status != c.code {
+			t.Fatalf("%v: reply is %+v, want error %v", c.name, msg, c.code)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"transfer_history/config"
+	"transfer_history/db"
+	"transfer_history/logs"
+	"transfer_history/types"
+	"transfer_history/utils"
+)
+
+const (
+	// headers of the signature of every request
+	TimestampHeader = "X-Transfer-Timestamp"
+	SignatureHeader = "X-Transfer-Signature"
+
+	// max count of transfers waiting for delivery of a target, the new transfers are fetched after some of them are delivered
+	maxPendingNotifications = 1000
+	// interval of deleting the old delivered notifications in outbox
+	outboxPruneInterval = time.Hour
+	// wait before reading or writing outbox again after it fails
+	outboxRetryInterval = 5 * time.Second
+)
+
+// DeliveryPolicy decides how long to wait for a delivery and how to retry it
+type DeliveryPolicy struct {
+	// max count of delivering a transfer, it's dead in outbox after that
+	MaxAttempts int
+	// wait before the first retry, it's doubled in every retry up to MaxRetryInterval
+	RetryInterval time.Duration
+	MaxRetryInterval time.Duration
+	Timeout time.Duration
+}
+
+// get the delivery policy in config
+func PolicyFromConfig() DeliveryPolicy {
+	return DeliveryPolicy{
+		MaxAttempts: config.GetWebhookMaxAttempts(),
+		RetryInterval: config.GetWebhookRetryInterval(),
+		MaxRetryInterval: config.GetWebhookMaxRetryInterval(),
+		Timeout: config.GetWebhookTimeout(),
+	}
+}
+
+// get the wait before the retry after attempts deliveries fail
+func (p DeliveryPolicy) backoff(attempts int) time.Duration {
+	wait := p.RetryInterval
+	for i := 1; i < attempts && wait < p.MaxRetryInterval; i++ {
+		wait *= 2
+	}
+	if wait > p.MaxRetryInterval {
+		wait = p.MaxRetryInterval
+	}
+	return wait
+}
+
+// a webhook and the accounts watched by it
+type target struct {
+	cfg config.WebhookTarget
+}
+
+// Dispatcher posts the new irreversible transfers of watched accounts to webhooks through the outbox.
+// transfers of a webhook are delivered one by one in order, a failed delivery is retried before the next one
+type Dispatcher struct {
+	repo db.TransferRepository
+	outbox *Outbox
+	policy DeliveryPolicy
+	client *http.Client
+	targets []*target
+	ctx context.Context
+	cancel context.CancelFunc
+	wg sync.WaitGroup
+}
+
+// get a dispatcher of the webhooks in list, which fetches transfers from repo into outbox
+func NewDispatcher(repo db.TransferRepository, outbox *Outbox, list []config.WebhookTarget, policy DeliveryPolicy) (*Dispatcher,error) {
+	d := &Dispatcher{
+		repo: repo,
+		outbox: outbox,
+		policy: policy,
+		client: &http.Client{Timeout: policy.Timeout},
+	}
+	// the notifications and positions in outbox are keyed by url, so a url can't be shared by webhooks
+	urls := make(map[string]bool)
+	for _,cfg := range list {
+		if err := checkTarget(&cfg); err != nil {
+			return nil,err
+		}
+		if urls[cfg.Url] {
+			return nil,errors.New(fmt.Sprintf("duplicate webhook url %v", cfg.Url))
+		}
+		urls[cfg.Url] = true
+		d.targets = append(d.targets, &target{cfg: cfg})
+	}
+	return d,nil
+}
+
+func checkTarget(cfg *config.WebhookTarget) error {
+	if !utils.CheckIsNotEmptyStr(cfg.Url) {
+		return errors.New("webhook url is empty")
+	}
+	if len(cfg.Accounts) == 0 {
+		return errors.New(fmt.Sprintf("webhook %v has no account", cfg.Url))
+	}
+	for _,acct := range cfg.Accounts {
+		if !utils.CheckIsValidAccountName(acct) {
+			return errors.New(fmt.Sprintf("account name %q of webhook %v is invalid", acct, cfg.Url))
+		}
+	}
+	if cfg.Direction != types.TxDirectionSend && cfg.Direction != types.TxDirectionReceive && cfg.Direction != types.TxDirectionAll {
+		return errors.New(fmt.Sprintf("transfer direction %v of webhook %v is invalid", cfg.Direction, cfg.Url))
+	}
+	return nil
+}
+
+// start fetching and delivering transfers, the pending notifications in outbox are delivered first
+func (d *Dispatcher) Start() {
+	d.ctx,d.cancel = context.WithCancel(context.Background())
+	d.wg.Add(1)
+	go d.watch()
+	for _,t := range d.targets {
+		d.wg.Add(1)
+		go d.deliverAll(t)
+	}
+}
+
+// stop fetching and delivering, the pending notifications are kept in outbox
+func (d *Dispatcher) Stop() {
+	d.cancel()
+	d.wg.Wait()
+}
+
+// fetch the new transfers of every webhook whenever new blocks are found, and prune the old delivered notifications
+func (d *Dispatcher) watch() {
+	logger := logs.GetLogger()
+	defer d.wg.Done()
+	var lastPrune time.Time
+	for {
+		// get the notification before fetching, so the blocks indexed while fetching are not missed
+		changed := db.WaitBlockChange(d.repo)
+		for _,t := range d.targets {
+			if err := d.fetch(t); err != nil && d.ctx.Err() == nil {
+				logger.Errorf("Dispatcher: fail to fetch transfers of webhook %v, the error is %v", t.cfg.Url, err)
+			}
+		}
+		if time.Since(lastPrune) >= outboxPruneInterval {
+			lastPrune = time.Now()
+			count,err := d.outbox.PruneDelivered(lastPrune.Add(-config.GetWebhookOutboxRetention()))
+			if err != nil {
+				logger.Errorf("Dispatcher: fail to prune outbox, the error is %v", err)
+			} else if count > 0 {
+				logger.Infof("Dispatcher: prune %v delivered notifications", count)
+			}
+		}
+		select {
+		case <-changed:
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+// put the irreversible transfers of every account of t after its last position into outbox, until t has too many pending ones
+func (d *Dispatcher) fetch(t *target) error {
+	for _,acct := range t.cfg.Accounts {
+		cursor,err := d.outbox.GetCursor(t.cfg.Url, acct)
+		if err != nil {
+			return err
+		}
+		for {
+			pending,err := d.outbox.CountPending(t.cfg.Url)
+			if err != nil {
+				return err
+			}
+			room := maxPendingNotifications - pending
+			if room <= 0 {
+				return nil
+			}
+			query := &types.TransferRecordQuery{
+				Start: t.cfg.Start,
+				Account: acct,
+				Direction: t.cfg.Direction,
+				Limit: room,
+				Cursor: cursor,
+			}
+			ctx,cancel := context.WithTimeout(d.ctx, config.GetQueryTimeout("webhook"))
+			model := d.repo.GetTransferRecord(ctx, query)
+			cancel()
+			if model.Err != nil {
+				return model.Err
+			}
+			list := make([]*types.WebhookPayload, 0, len(model.List))
+			for _,rec := range model.List {
+				list = append(list, &types.WebhookPayload{Id: acct + ":" + rec.OperationId, Account: acct, Record: rec})
+			}
+			if err := d.outbox.Enqueue(t.cfg.Url, acct, list, model.NextCursor); err != nil {
+				return err
+			}
+			cursor = model.NextCursor
+			if !model.HasMore {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// deliver the pending notifications of t in order until the dispatcher stops
+func (d *Dispatcher) deliverAll(t *target) {
+	logger := logs.GetLogger()
+	defer d.wg.Done()
+	for {
+		// get the notification before reading outbox, so the notifications added meanwhile are not missed
+		changed := d.outbox.Changed()
+		n,err := d.outbox.NextPending(t.cfg.Url)
+		if err != nil {
+			logger.Errorf("Dispatcher: fail to read outbox of webhook %v, the error is %v", t.cfg.Url, err)
+			select {
+			case <-time.After(outboxRetryInterval):
+				continue
+			case <-d.ctx.Done():
+				return
+			}
+		}
+		if n == nil {
+			select {
+			case <-changed:
+				continue
+			case <-d.ctx.Done():
+				return
+			}
+		}
+		if wait := time.Until(n.NextAttemptAt); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-d.ctx.Done():
+				return
+			}
+		}
+		err = d.deliver(t, n)
+		if d.ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			logger.Debugf("Dispatcher: deliver %v to %v", n.NotificationId, t.cfg.Url)
+			err = d.outbox.MarkDelivered(n)
+		} else if n.Attempts + 1 >= d.policy.MaxAttempts {
+			logger.Errorf("Dispatcher: %v of webhook %v is dead after %v attempts, the error is %v", n.NotificationId, t.cfg.Url, n.Attempts + 1, err)
+			err = d.outbox.MarkFailed(n, err, time.Now(), true)
+		} else {
+			wait := d.policy.backoff(n.Attempts + 1)
+			logger.Errorf("Dispatcher: fail to deliver %v to %v, retry after %v, the error is %v", n.NotificationId, t.cfg.Url, wait, err)
+			err = d.outbox.MarkFailed(n, err, time.Now().Add(wait), false)
+		}
+		if err != nil {
+			logger.Errorf("Dispatcher: fail to update %v in outbox of webhook %v, the error is %v", n.NotificationId, t.cfg.Url, err)
+			select {
+			case <-time.After(outboxRetryInterval):
+			case <-d.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// post the payload of n to the webhook, it's delivered if the webhook replies 2xx
+func (d *Dispatcher) deliver(t *target, n *types.WebhookNotification) error {
+	body := []byte(n.Payload)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req,err := http.NewRequest(http.MethodPost, t.cfg.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(d.ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(t.cfg.Secret, timestamp, body))
+	resp,err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// read the body so the connection is reused
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64 * 1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New(fmt.Sprintf("webhook replies status %v", resp.StatusCode))
+	}
+	return nil
+}
+
+// get the signature of a request, it's the hex HMAC-SHA256 of "timestamp.body" with secret
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// check the signature of a request with secret
+func Verify(secret, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
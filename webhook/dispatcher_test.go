@@ -0,0 +1,258 @@
+package webhook
+
+import (
+	"encoding/json"
+	"github.com/coschain/contentos-go/app/plugins"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	"transfer_history/config"
+	"transfer_history/db"
+	"transfer_history/types"
+)
+
+const testSecret = "secret"
+
+// a webhook which checks the signature and fails the first count of requests
+type testReceiver struct {
+	t *testing.T
+	lock sync.Mutex
+	failures int
+	requests int
+	delivered []string
+}
+
+func (r *testReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body,err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !Verify(testSecret, req.Header.Get(TimestampHeader), body, req.Header.Get(SignatureHeader)) {
+		r.t.Errorf("invalid signature of %s", body)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.requests++
+	if r.requests <= r.failures {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	payload := &types.WebhookPayload{}
+	if err := json.Unmarshal(body, payload); err != nil {
+		r.t.Errorf("invalid payload %s", body)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.delivered = append(r.delivered, payload.Id)
+}
+
+// fail the next count of requests
+func (r *testReceiver) fail(count int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.failures = r.requests + count
+}
+
+// wait until count of transfers are delivered, get the ids of them and the count of requests
+func (r *testReceiver) wait(count int) ([]string,int) {
+	r.t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		r.lock.Lock()
+		delivered, requests := append([]string(nil), r.delivered...), r.requests
+		r.lock.Unlock()
+		if len(delivered) >= count {
+			return delivered,requests
+		}
+		if time.Now().After(deadline) {
+			r.t.Fatalf("%v transfers are delivered, want %v", len(delivered), count)
+		}
+	}
+}
+
+// wait until the notifications of url in outbox are all delivered or dead
+func waitTestOutboxSettled(t *testing.T, outbox *Outbox, url string) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		pending,err := outbox.CountPending(url)
+		if err != nil {
+			t.Fatalf("count pending: %v", err)
+		}
+		if pending == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%v notifications are still pending", pending)
+		}
+	}
+}
+
+func testRecord(blkNum uint64, opId, from, to string) *plugins.TransferRecord {
+	return &plugins.TransferRecord{BlockHeight: blkNum, OperationId: opId, From: from, To: to, Amount: 1, BlockTime: time.Now()}
+}
+
+// start a dispatcher of one webhook at server watching the incoming transfers of alice1, it's stopped when the test finishes
+func startTestDispatcher(t *testing.T, repo db.TransferRepository, outbox *Outbox, server *httptest.Server, maxAttempts int) *Dispatcher {
+	t.Helper()
+	policy := DeliveryPolicy{MaxAttempts: maxAttempts, RetryInterval: 10 * time.Millisecond, MaxRetryInterval: 40 * time.Millisecond, Timeout: time.Second}
+	targets := []config.WebhookTarget{{Url: server.URL, Secret: testSecret, Accounts: []string{"alice1"}, Direction: types.TxDirectionReceive}}
+	d,err := NewDispatcher(repo, outbox, targets, policy)
+	if err != nil {
+		t.Fatalf("new dispatcher: %v", err)
+	}
+	d.Start()
+	t.Cleanup(d.Stop)
+	return d
+}
+
+func TestDispatcherRetriesAndDeliversInOrder(t *testing.T) {
+	repo := db.NewMemoryRepository()
+	repo.AddTransferRecord(
+		testRecord(50, "op50_0", "initminer", "alice1"),
+		testRecord(60, "op60_0", "initminer", "alice1"),
+		// the transfer out of alice1 isn't watched
+		testRecord(61, "op61_0", "alice1", "bobbob1"),
+	)
+	repo.SetLib(100)
+	receiver := &testReceiver{t: t, failures: 3}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	outbox := openTestOutbox(t, filepath.Join(t.TempDir(), "outbox.db"))
+	startTestDispatcher(t, repo, outbox, server, 10)
+
+	delivered,requests := receiver.wait(2)
+	if delivered[0] != "alice1:op50_0" || delivered[1] != "alice1:op60_0" || requests != receiver.failures + 2 {
+		t.Fatalf("transfers are delivered as %v in %v requests", delivered, requests)
+	}
+	// the delivery is recorded after the webhook replies
+	waitTestOutboxSettled(t, outbox, server.URL)
+	list,_,err := outbox.List(&OutboxQuery{Url: server.URL, Limit: 10})
+	if err != nil {
+		t.Fatalf("list outbox: %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("outbox has %v notifications, want 2", len(list))
+	}
+	// the first transfer is retried after the failures, the second one is delivered at once
+	for i,attempts := range []int{receiver.failures + 1, 1} {
+		if list[i].State != types.WebhookNotificationDelivered || list[i].Attempts != attempts || list[i].DeliveredAt == nil {
+			t.Errorf("notification %v is %v after %v attempts, want delivered after %v", list[i].NotificationId, list[i].State, list[i].Attempts, attempts)
+		}
+	}
+
+	// the transfers of new irreversible blocks are delivered
+	repo.AddTransferRecord(testRecord(110, "op110_0", "initminer", "alice1"))
+	repo.SetLib(120)
+	if delivered,_ := receiver.wait(3); delivered[2] != "alice1:op110_0" {
+		t.Fatalf("transfers are delivered as %v", delivered)
+	}
+}
+
+// the pending notifications and the positions of accounts are kept in outbox across restarts,
+// so a restarted dispatcher delivers the pending ones and never fetches the delivered ones again
+func TestDispatcherResumesAfterRestart(t *testing.T) {
+	repo := db.NewMemoryRepository()
+	repo.AddTransferRecord(testRecord(50, "op50_0", "initminer", "alice1"))
+	repo.SetLib(100)
+	receiver := &testReceiver{t: t}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "outbox.db")
+	outbox := openTestOutbox(t, path)
+	d := startTestDispatcher(t, repo, outbox, server, 10)
+	receiver.wait(1)
+	waitTestOutboxSettled(t, outbox, server.URL)
+
+	// the webhook fails while the service restarts, the new transfer stays pending
+	receiver.fail(1000)
+	repo.AddTransferRecord(testRecord(110, "op110_0", "initminer", "alice1"))
+	repo.SetLib(120)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if pending,err := outbox.CountPending(server.URL); err == nil && pending == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("new transfer isn't put into outbox")
+		}
+	}
+	d.Stop()
+	outbox.Close()
+
+	receiver.fail(0)
+	outbox = openTestOutbox(t, path)
+	startTestDispatcher(t, repo, outbox, server, 10)
+	delivered,_ := receiver.wait(2)
+	waitTestOutboxSettled(t, outbox, server.URL)
+	time.Sleep(50 * time.Millisecond)
+	receiver.lock.Lock()
+	defer receiver.lock.Unlock()
+	if len(receiver.delivered) != 2 || delivered[0] != "alice1:op50_0" || delivered[1] != "alice1:op110_0" {
+		t.Fatalf("transfers are delivered as %v", receiver.delivered)
+	}
+}
+
+// a notification is dead after max attempts, and delivered again after it's replayed
+func TestDispatcherDeliversReplayedNotification(t *testing.T) {
+	repo := db.NewMemoryRepository()
+	repo.AddTransferRecord(testRecord(50, "op50_0", "initminer", "alice1"))
+	repo.SetLib(100)
+	receiver := &testReceiver{t: t, failures: 3}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	outbox := openTestOutbox(t, filepath.Join(t.TempDir(), "outbox.db"))
+	startTestDispatcher(t, repo, outbox, server, 3)
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		dead,_,err := outbox.List(&OutboxQuery{Url: server.URL, State: types.WebhookNotificationDead, Limit: 10})
+		if err != nil {
+			t.Fatalf("list outbox: %v", err)
+		}
+		if len(dead) == 1 {
+			if dead[0].Attempts != 3 || dead[0].LastError == "" {
+				t.Fatalf("dead notification is %+v", dead[0])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("notification isn't dead after max attempts")
+		}
+	}
+	if count,err := outbox.Replay(server.URL, nil); err != nil || count != 1 {
+		t.Fatalf("replay %v notifications, the error is %v", count, err)
+	}
+	if delivered,_ := receiver.wait(1); delivered[0] != "alice1:op50_0" {
+		t.Fatalf("transfers are delivered as %v", delivered)
+	}
+	waitTestOutboxSettled(t, outbox, server.URL)
+	list,_,err := outbox.List(&OutboxQuery{Url: server.URL, Limit: 10})
+	if err != nil || len(list) != 1 || list[0].State != types.WebhookNotificationDelivered || list[0].Attempts != 1 {
+		t.Fatalf("notifications are %+v, the error is %v", list, err)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	body := []byte(`{"Id":"alice1:op50_0"}`)
+	signature := Sign(testSecret, "1700000000", body)
+	if !Verify(testSecret, "1700000000", body, signature) {
+		t.Fatal("signature isn't verified")
+	}
+	if Verify(testSecret, "1700000001", body, signature) || Verify("other", "1700000000", body, signature) || Verify(testSecret, "1700000000", []byte(`{"Id":"alice1:op60_0"}`), signature) {
+		t.Fatal("signature of another timestamp, secret or body is verified")
+	}
+}
+
+func TestNewDispatcherRejectsDuplicateUrl(t *testing.T) {
+	targets := []config.WebhookTarget{
+		{Url: "http://localhost/hook", Accounts: []string{"alice1"}, Direction: types.TxDirectionReceive},
+		{Url: "http://localhost/hook", Accounts: []string{"bobbob1"}, Direction: types.TxDirectionReceive},
+	}
+	if _,err := NewDispatcher(db.NewMemoryRepository(), nil, targets, DeliveryPolicy{}); err == nil {
+		t.Fatal("duplicate webhook url is accepted")
+	}
+}
@@ -0,0 +1,265 @@
+package webhook
+
+import (
+	"encoding/json"
+	"github.com/jinzhu/gorm"
+	"sync"
+	"time"
+	"transfer_history/config"
+	"transfer_history/db"
+	"transfer_history/logs"
+	"transfer_history/types"
+)
+
+// Outbox keeps the notifications of webhooks with their delivery state, and the position of every watched account.
+// a notification stays pending until the webhook accepts it, so it's delivered at least once across restarts
+type Outbox struct {
+	db *gorm.DB
+	lock sync.Mutex
+	// closed and replaced when notifications become pending
+	changed chan struct{}
+}
+
+// OutboxQuery is the filter of listing the notifications in outbox
+type OutboxQuery struct {
+	// empty for all the webhooks
+	Url string
+	// empty for all the states
+	State string
+	// list the notifications whose id is greater than After
+	After uint64
+	Limit int
+}
+
+// open the outbox db in config and create its tables
+func OpenOutbox() (*Outbox,error) {
+	return openOutbox(config.GetWebhookOutboxDbConfig())
+}
+
+func openOutbox(cfg *config.DbConfig) (*Outbox,error) {
+	logger := logs.GetLogger()
+	store,err := db.OpenDb(cfg)
+	if err != nil {
+		logger.Errorf("OpenOutbox: fail to open outbox, the error is %v", err)
+		return nil,err
+	}
+	if cfg.Driver == config.DbDriverSqlite {
+		// sqlite allows one writer, the deliveries of webhooks write the outbox concurrently
+		store.DB().SetMaxOpenConns(1)
+	}
+	if err := migrateOutbox(store); err != nil {
+		logger.Errorf("OpenOutbox: fail to create tables of outbox, the error is %v", err)
+		store.Close()
+		return nil,err
+	}
+	return &Outbox{db: store, changed: make(chan struct{})},nil
+}
+
+func migrateOutbox(store *gorm.DB) error {
+	if err := store.AutoMigrate(&types.WebhookNotification{}, &types.WebhookCursor{}).Error; err != nil {
+		return err
+	}
+	model := store.Model(&types.WebhookNotification{})
+	if err := model.AddUniqueIndex("idx_outbox_url_notification", "url", "notification_id").Error; err != nil {
+		return err
+	}
+	if err := model.AddIndex("idx_outbox_url_state", "url", "state", "id").Error; err != nil {
+		return err
+	}
+	return store.Model(&types.WebhookCursor{}).AddUniqueIndex("idx_webhook_cursor_url_account", "url", "account").Error
+}
+
+func (o *Outbox) Close() error {
+	return o.db.Close()
+}
+
+// get a channel which is closed when notifications become pending next time
+func (o *Outbox) Changed() <-chan struct{} {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.changed
+}
+
+func (o *Outbox) notify() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	close(o.changed)
+	o.changed = make(chan struct{})
+}
+
+// get the position of the last transfer of account put into the outbox of url, nil if there is none
+func (o *Outbox) GetCursor(url, account string) (*types.TransferCursor,error) {
+	c := &types.WebhookCursor{}
+	err := o.db.Where("url = ? AND account = ?", url, account).First(c).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil,nil
+	}
+	if err != nil {
+		return nil,err
+	}
+	return &types.TransferCursor{BlockHeight: c.BlockHeight, OperationId: c.OperationId},nil
+}
+
+// put the payloads of account into the outbox of url and move the position of account to cursor in one transaction,
+// the payload already in the outbox is skipped
+func (o *Outbox) Enqueue(url, account string, list []*types.WebhookPayload, cursor *types.TransferCursor) error {
+	if len(list) == 0 && cursor == nil {
+		return nil
+	}
+	tx := o.db.Begin()
+	if err := tx.Error; err != nil {
+		return err
+	}
+	defer tx.RollbackUnlessCommitted()
+	if len(list) > 0 {
+		ids := make([]string, 0, len(list))
+		for _,payload := range list {
+			ids = append(ids, payload.Id)
+		}
+		var existing []string
+		if err := tx.Model(&types.WebhookNotification{}).Where("url = ? AND notification_id IN (?)", url, ids).Pluck("notification_id", &existing).Error; err != nil {
+			return err
+		}
+		skip := make(map[string]bool, len(existing))
+		for _,id := range existing {
+			skip[id] = true
+		}
+		now := time.Now()
+		for _,payload := range list {
+			if skip[payload.Id] {
+				continue
+			}
+			js,err := json.Marshal(payload)
+			if err != nil {
+				return err
+			}
+			n := &types.WebhookNotification{
+				Url: url,
+				NotificationId: payload.Id,
+				Payload: string(js),
+				State: types.WebhookNotificationPending,
+				NextAttemptAt: now,
+			}
+			if err := tx.Create(n).Error; err != nil {
+				return err
+			}
+		}
+	}
+	if cursor != nil {
+		c := &types.WebhookCursor{}
+		err := tx.Where(types.WebhookCursor{Url: url, Account: account}).
+			Assign(types.WebhookCursor{BlockHeight: cursor.BlockHeight, OperationId: cursor.OperationId}).
+			FirstOrCreate(c).Error
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	if len(list) > 0 {
+		o.notify()
+	}
+	return nil
+}
+
+// count the pending notifications of url
+func (o *Outbox) CountPending(url string) (int,error) {
+	var count int
+	err := o.db.Model(&types.WebhookNotification{}).Where("url = ? AND state = ?", url, types.WebhookNotificationPending).Count(&count).Error
+	return count,err
+}
+
+// get the oldest pending notification of url, nil if there is none
+func (o *Outbox) NextPending(url string) (*types.WebhookNotification,error) {
+	n := &types.WebhookNotification{}
+	err := o.db.Where("url = ? AND state = ?", url, types.WebhookNotificationPending).Order("id").First(n).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil,nil
+	}
+	if err != nil {
+		return nil,err
+	}
+	return n,nil
+}
+
+// record the successful attempt of the pending notification n
+func (o *Outbox) MarkDelivered(n *types.WebhookNotification) error {
+	now := time.Now()
+	n.Attempts++
+	n.State = types.WebhookNotificationDelivered
+	n.DeliveredAt = &now
+	return o.db.Model(&types.WebhookNotification{}).Where("id = ? AND state = ?", n.ID, types.WebhookNotificationPending).
+		Updates(map[string]interface{}{"state": n.State, "attempts": n.Attempts, "delivered_at": now}).Error
+}
+
+// record the failed attempt of the pending notification n, it's dead if dead is true, otherwise it's retried at next
+func (o *Outbox) MarkFailed(n *types.WebhookNotification, lastErr error, next time.Time, dead bool) error {
+	n.Attempts++
+	n.LastError = lastErr.Error()
+	n.NextAttemptAt = next
+	if dead {
+		n.State = types.WebhookNotificationDead
+	}
+	return o.db.Model(&types.WebhookNotification{}).Where("id = ? AND state = ?", n.ID, types.WebhookNotificationPending).
+		Updates(map[string]interface{}{"state": n.State, "attempts": n.Attempts, "last_error": n.LastError, "next_attempt_at": next}).Error
+}
+
+// list the notifications matching query in id order, return true if there are more
+func (o *Outbox) List(query *OutboxQuery) ([]*types.WebhookNotification,bool,error) {
+	scope := o.db.Where("id > ?", query.After)
+	if query.Url != "" {
+		scope = scope.Where("url = ?", query.Url)
+	}
+	if query.State != "" {
+		scope = scope.Where("state = ?", query.State)
+	}
+	var list []*types.WebhookNotification
+	if err := scope.Order("id").Limit(query.Limit + 1).Find(&list).Error; err != nil {
+		return nil,false,err
+	}
+	if len(list) > query.Limit {
+		return list[:query.Limit],true,nil
+	}
+	return list,false,nil
+}
+
+// get the scope of the dead notifications in ids, or all the dead notifications of url if ids is empty
+func (o *Outbox) deadScope(url string, ids []uint64) *gorm.DB {
+	scope := o.db.Model(&types.WebhookNotification{}).Where("state = ?", types.WebhookNotificationDead)
+	if len(ids) > 0 {
+		scope = scope.Where("id IN (?)", ids)
+	}
+	if url != "" {
+		scope = scope.Where("url = ?", url)
+	}
+	return scope
+}
+
+// make the dead notifications pending again, they are delivered from the first attempt. return the count of them
+func (o *Outbox) Replay(url string, ids []uint64) (int64,error) {
+	res := o.deadScope(url, ids).Updates(map[string]interface{}{
+		"state": types.WebhookNotificationPending,
+		"attempts": 0,
+		"next_attempt_at": time.Now(),
+	})
+	if res.Error != nil {
+		return 0,res.Error
+	}
+	if res.RowsAffected > 0 {
+		o.notify()
+	}
+	return res.RowsAffected,nil
+}
+
+// delete the dead notifications, return the count of them
+func (o *Outbox) Drop(url string, ids []uint64) (int64,error) {
+	res := o.deadScope(url, ids).Delete(&types.WebhookNotification{})
+	return res.RowsAffected,res.Error
+}
+
+// delete the notifications delivered before t
+func (o *Outbox) PruneDelivered(t time.Time) (int64,error) {
+	res := o.db.Where("state = ? AND delivered_at < ?", types.WebhookNotificationDelivered, t).Delete(&types.WebhookNotification{})
+	return res.RowsAffected,res.Error
+}
@@ -0,0 +1,131 @@
+package webhook
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+	"transfer_history/config"
+	"transfer_history/types"
+)
+
+const testUrl = "http://localhost/hook"
+
+// open a sqlite outbox at path, it's closed when the test finishes
+func openTestOutbox(t *testing.T, path string) *Outbox {
+	t.Helper()
+	outbox,err := openOutbox(&config.DbConfig{Driver: config.DbDriverSqlite, DbName: path})
+	if err != nil {
+		t.Fatalf("open outbox: %v", err)
+	}
+	t.Cleanup(func() {
+		outbox.Close()
+	})
+	return outbox
+}
+
+func testPayloads(ids ...string) []*types.WebhookPayload {
+	list := make([]*types.WebhookPayload, 0, len(ids))
+	for _,id := range ids {
+		list = append(list, &types.WebhookPayload{Id: id, Account: "alice1"})
+	}
+	return list
+}
+
+// the notifications and the cursors are kept across reopens, and a notification already in outbox is skipped
+func TestOutboxKeepsPendingAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.db")
+	outbox := openTestOutbox(t, path)
+	if err := outbox.Enqueue(testUrl, "alice1", testPayloads("alice1:op50_0", "alice1:op60_0"), &types.TransferCursor{BlockHeight: 60, OperationId: "op60_0"}); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	// enqueued again after a crash before the cursor moves
+	if err := outbox.Enqueue(testUrl, "alice1", testPayloads("alice1:op60_0", "alice1:op70_0"), &types.TransferCursor{BlockHeight: 70, OperationId: "op70_0"}); err != nil {
+		t.Fatalf("enqueue again: %v", err)
+	}
+	outbox.Close()
+
+	outbox = openTestOutbox(t, path)
+	if count,err := outbox.CountPending(testUrl); err != nil || count != 3 {
+		t.Fatalf("%v notifications are pending, the error is %v", count, err)
+	}
+	n,err := outbox.NextPending(testUrl)
+	if err != nil || n == nil || n.NotificationId != "alice1:op50_0" || n.Attempts != 0 {
+		t.Fatalf("next pending is %+v, the error is %v", n, err)
+	}
+	cursor,err := outbox.GetCursor(testUrl, "alice1")
+	if err != nil || cursor == nil || cursor.BlockHeight != 70 || cursor.OperationId != "op70_0" {
+		t.Fatalf("cursor of alice1 is %+v, the error is %v", cursor, err)
+	}
+	if cursor,err := outbox.GetCursor(testUrl, "bobbob1"); err != nil || cursor != nil {
+		t.Fatalf("cursor of an account never watched is %+v, the error is %v", cursor, err)
+	}
+	if n,err := outbox.NextPending("http://localhost/other"); err != nil || n != nil {
+		t.Fatalf("next pending of another webhook is %+v, the error is %v", n, err)
+	}
+}
+
+func TestOutboxReplayDeadNotifications(t *testing.T) {
+	outbox := openTestOutbox(t, filepath.Join(t.TempDir(), "outbox.db"))
+	if err := outbox.Enqueue(testUrl, "alice1", testPayloads("alice1:op50_0", "alice1:op60_0"), nil); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		n,err := outbox.NextPending(testUrl)
+		if err != nil || n == nil {
+			t.Fatalf("next pending is %+v, the error is %v", n, err)
+		}
+		if err := outbox.MarkFailed(n, errors.New("refused"), time.Now(), true); err != nil {
+			t.Fatalf("mark failed: %v", err)
+		}
+	}
+	dead,more,err := outbox.List(&OutboxQuery{Url: testUrl, State: types.WebhookNotificationDead, Limit: 1})
+	if err != nil || len(dead) != 1 || !more || dead[0].NotificationId != "alice1:op50_0" || dead[0].Attempts != 1 || dead[0].LastError != "refused" {
+		t.Fatalf("first page of dead notifications is %+v, more: %v, the error is %v", dead, more, err)
+	}
+	next,more,err := outbox.List(&OutboxQuery{Url: testUrl, State: types.WebhookNotificationDead, After: dead[0].ID, Limit: 1})
+	if err != nil || len(next) != 1 || more || next[0].NotificationId != "alice1:op60_0" {
+		t.Fatalf("second page of dead notifications is %+v, more: %v, the error is %v", next, more, err)
+	}
+
+	changed := outbox.Changed()
+	if count,err := outbox.Replay(testUrl, []uint64{dead[0].ID}); err != nil || count != 1 {
+		t.Fatalf("replay %v notifications, the error is %v", count, err)
+	}
+	select {
+	case <-changed:
+	default:
+		t.Fatal("replay doesn't notify the dispatcher")
+	}
+	n,err := outbox.NextPending(testUrl)
+	if err != nil || n == nil || n.NotificationId != "alice1:op50_0" || n.Attempts != 0 {
+		t.Fatalf("replayed notification is %+v, the error is %v", n, err)
+	}
+	if err := outbox.MarkDelivered(n); err != nil {
+		t.Fatalf("mark delivered: %v", err)
+	}
+	if n,err := outbox.NextPending(testUrl); err != nil || n != nil {
+		t.Fatalf("next pending after delivery is %+v, the error is %v", n, err)
+	}
+	// the delivered notification can't be replayed or dropped
+	if count,err := outbox.Replay(testUrl, []uint64{dead[0].ID}); err != nil || count != 0 {
+		t.Fatalf("replay %v delivered notifications, the error is %v", count, err)
+	}
+
+	if count,err := outbox.Drop(testUrl, nil); err != nil || count != 1 {
+		t.Fatalf("drop %v notifications, the error is %v", count, err)
+	}
+	list,_,err := outbox.List(&OutboxQuery{Limit: 10})
+	if err != nil || len(list) != 1 || list[0].State != types.WebhookNotificationDelivered {
+		t.Fatalf("notifications after drop are %+v, the error is %v", list, err)
+	}
+	if count,err := outbox.PruneDelivered(time.Now().Add(-time.Hour)); err != nil || count != 0 {
+		t.Fatalf("prune %v notifications delivered in the last hour, the error is %v", count, err)
+	}
+	if count,err := outbox.PruneDelivered(time.Now().Add(time.Second)); err != nil || count != 1 {
+		t.Fatalf("prune %v delivered notifications, the error is %v", count, err)
+	}
+	if list,_,err := outbox.List(&OutboxQuery{Limit: 10}); err != nil || len(list) != 0 {
+		t.Fatalf("notifications after prune are %+v, the error is %v", list, err)
+	}
+}